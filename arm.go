@@ -0,0 +1,77 @@
+package s3update
+
+import (
+	"bufio"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// defaultArmVariant is assumed when the ARM variant can't be detected, builds for it running on every later CPU
+const defaultArmVariant = "6"
+
+var detectedArm struct {
+	once    sync.Once
+	variant string
+}
+
+// armVariant returns the ARM architecture version substituted to {{GOARM}}, e.g. "7", empty on other architectures
+func (u Updater) armVariant() string {
+	if runtime.GOARCH != "arm" {
+		return ""
+	}
+	if u.ArmVariant != "" {
+		return strings.TrimPrefix(strings.TrimPrefix(u.ArmVariant, "arm"), "v")
+	}
+	detectedArm.once.Do(func() {
+		detectedArm.variant = cpuArmVariant()
+	})
+	if detectedArm.variant == "" {
+		u.logger().Debugf("updater: couldn't detect the ARM variant, assuming armv%s", defaultArmVariant)
+		return defaultArmVariant
+	}
+	return detectedArm.variant
+}
+
+// cpuArmVariant reads the ARM architecture version of the CPU from /proc/cpuinfo, 64-bit CPUs running
+// 32-bit binaries being reported as armv7. It returns an empty string when unknown.
+func cpuArmVariant() string {
+	f, err := os.Open("/proc/cpuinfo")
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	arch := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		kv := strings.SplitN(scanner.Text(), ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		value := strings.TrimSpace(kv[1])
+		switch strings.TrimSpace(kv[0]) {
+		case "model name", "Processor":
+			// ARMv6 CPUs such as the one of the first Raspberry Pi claim architecture 7
+			if strings.HasPrefix(value, "ARMv6") {
+				return "6"
+			}
+		case "CPU architecture":
+			if arch == "" {
+				arch = value
+			}
+		}
+	}
+	// the architecture may read "7", "8" or "AArch64"
+	v, err := strconv.Atoi(arch)
+	switch {
+	case arch == "":
+		return ""
+	case err != nil || v >= 7:
+		return "7"
+	case v >= 5:
+		return strconv.Itoa(v)
+	}
+	return ""
+}
@@ -0,0 +1,342 @@
+package s3update
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AWSCredentials holds the keys used to sign requests to private buckets
+type AWSCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	// Expires is the zero time for long-lived credentials
+	Expires time.Time
+}
+
+// errNoAWSCredentials is returned when none of the credential sources yields anything
+var errNoAWSCredentials = errors.New("no AWS credentials found in environment, shared credentials file or instance role")
+
+// metadataClient talks to the ECS/EC2 metadata endpoints, which answer quickly or not at all
+var metadataClient = &http.Client{Timeout: 2 * time.Second}
+
+// credentialsCache avoids hitting the instance metadata service for every request of an update
+var credentialsCache struct {
+	sync.Mutex
+	creds AWSCredentials
+}
+
+// LoadAWSCredentials resolves credentials the same way the AWS tooling does:
+// environment variables, then the shared credentials file, then the ECS task or EC2 instance role.
+func LoadAWSCredentials(ctx context.Context) (AWSCredentials, error) {
+	credentialsCache.Lock()
+	defer credentialsCache.Unlock()
+	c := credentialsCache.creds
+	if c.AccessKeyID != "" && (c.Expires.IsZero() || time.Until(c.Expires) > time.Minute) {
+		return c, nil
+	}
+	c, err := loadAWSCredentials(ctx)
+	if err != nil {
+		return AWSCredentials{}, err
+	}
+	credentialsCache.creds = c
+	return c, nil
+}
+
+func loadAWSCredentials(ctx context.Context) (AWSCredentials, error) {
+	if id := os.Getenv("AWS_ACCESS_KEY_ID"); id != "" {
+		return AWSCredentials{
+			AccessKeyID:     id,
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}, nil
+	}
+	if c, err := sharedAWSCredentials(); err == nil {
+		return c, nil
+	}
+	if uri := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); uri != "" {
+		return fetchRoleCredentials(ctx, "http://169.254.170.2"+uri, nil)
+	}
+	if c, err := instanceRoleCredentials(ctx); err == nil {
+		return c, nil
+	}
+	return AWSCredentials{}, errNoAWSCredentials
+}
+
+// sharedAWSCredentials reads the AWS_PROFILE (or default) section of ~/.aws/credentials
+func sharedAWSCredentials() (AWSCredentials, error) {
+	filename := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if filename == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return AWSCredentials{}, err
+		}
+		filename = filepath.Join(home, ".aws", "credentials")
+	}
+	profile := os.Getenv("AWS_PROFILE")
+	if profile == "" {
+		profile = "default"
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return AWSCredentials{}, err
+	}
+	defer f.Close()
+
+	var c AWSCredentials
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		if section != profile {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		v := strings.TrimSpace(kv[1])
+		switch strings.TrimSpace(kv[0]) {
+		case "aws_access_key_id":
+			c.AccessKeyID = v
+		case "aws_secret_access_key":
+			c.SecretAccessKey = v
+		case "aws_session_token":
+			c.SessionToken = v
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return AWSCredentials{}, err
+	}
+	if c.AccessKeyID == "" {
+		return AWSCredentials{}, fmt.Errorf("no credentials for profile %s in %s", profile, filename)
+	}
+	return c, nil
+}
+
+// instanceRoleCredentials retrieves the EC2 instance role credentials through IMDSv2
+func instanceRoleCredentials(ctx context.Context) (AWSCredentials, error) {
+	const imds = "http://169.254.169.254/latest"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, imds+"/api/token", nil)
+	if err != nil {
+		return AWSCredentials{}, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "300")
+	token, err := metadataGet(req)
+	if err != nil {
+		return AWSCredentials{}, err
+	}
+	header := http.Header{"X-aws-ec2-metadata-token": {token}}
+
+	req, err = http.NewRequestWithContext(ctx, http.MethodGet, imds+"/meta-data/iam/security-credentials/", nil)
+	if err != nil {
+		return AWSCredentials{}, err
+	}
+	req.Header = header
+	roles, err := metadataGet(req)
+	if err != nil {
+		return AWSCredentials{}, err
+	}
+	role := strings.TrimSpace(strings.SplitN(roles, "\n", 2)[0])
+	if role == "" {
+		return AWSCredentials{}, errNoAWSCredentials
+	}
+	return fetchRoleCredentials(ctx, imds+"/meta-data/iam/security-credentials/"+role, header)
+}
+
+// fetchRoleCredentials decodes the credentials document served by the ECS and EC2 metadata endpoints
+func fetchRoleCredentials(ctx context.Context, url string, header http.Header) (AWSCredentials, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return AWSCredentials{}, err
+	}
+	if header != nil {
+		req.Header = header
+	}
+	body, err := metadataGet(req)
+	if err != nil {
+		return AWSCredentials{}, err
+	}
+	var doc struct {
+		AccessKeyID     string `json:"AccessKeyId"`
+		SecretAccessKey string
+		Token           string
+		Expiration      time.Time
+	}
+	if err := json.Unmarshal([]byte(body), &doc); err != nil {
+		return AWSCredentials{}, fmt.Errorf("decoding role credentials: %w", err)
+	}
+	return AWSCredentials{
+		AccessKeyID:     doc.AccessKeyID,
+		SecretAccessKey: doc.SecretAccessKey,
+		SessionToken:    doc.Token,
+		Expires:         doc.Expiration,
+	}, nil
+}
+
+func metadataGet(req *http.Request) (string, error) {
+	resp, err := metadataClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("metadata endpoint %s: unexpected status %d", req.URL, resp.StatusCode)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	return string(body), err
+}
+
+// SignAWSRequest adds an AWS Signature Version 4 Authorization header to req for the s3 service.
+// The payload is left unsigned unless the X-Amz-Content-Sha256 header is already set.
+func SignAWSRequest(req *http.Request, creds AWSCredentials, region string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if req.Header.Get("X-Amz-Content-Sha256") == "" {
+		req.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+	}
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-amz-") {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	query := req.URL.Query()
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var params []string
+	for _, k := range keys {
+		values := query[k]
+		sort.Strings(values)
+		for _, v := range values {
+			params = append(params, awsURIEncode(k, true)+"="+awsURIEncode(v, true))
+		}
+	}
+
+	canonicalPath := req.URL.Path
+	if canonicalPath == "" {
+		canonicalPath = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		awsURIEncode(canonicalPath, false),
+		strings.Join(params, "&"),
+		canonicalHeaders.String(),
+		signedHeaders,
+		req.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+
+	scope := day + "/" + region + "/s3/aws4_request"
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), day)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+creds.AccessKeyID+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// awsURIEncode percent-encodes s the way SigV4 expects, leaving '/' alone unless encodeSlash is set
+func awsURIEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// signRequest signs req when authenticated mode is enabled on u
+func (u Updater) signRequest(req *http.Request) error {
+	if u.Signer != nil {
+		return u.Signer(req)
+	}
+	if !u.UseAWSAuth {
+		return nil
+	}
+	creds, err := LoadAWSCredentials(req.Context())
+	if err != nil {
+		return err
+	}
+	SignAWSRequest(req, creds, u.region(), time.Now())
+	return nil
+}
+
+// region returns the bucket region, falling back on the standard environment variables and us-east-1
+func (u Updater) region() string {
+	if u.S3Region != "" {
+		return u.S3Region
+	}
+	if r := os.Getenv("AWS_REGION"); r != "" {
+		return r
+	}
+	if r := os.Getenv("AWS_DEFAULT_REGION"); r != "" {
+		return r
+	}
+	return "us-east-1"
+}
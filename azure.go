@@ -0,0 +1,112 @@
+package s3update
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// AzureSource reads releases from an Azure Blob Storage container laid out like an S3 bucket: the keys are
+// templates with the placeholders of Updater.S3VersionKey, S3ReleaseKey and ChecksumKey.
+type AzureSource struct {
+	Account     string
+	Container   string
+	VersionKey  string
+	ReleaseKey  string
+	ChecksumKey string
+	Channel     string
+	OSMap       map[string]string
+	ArchMap     map[string]string
+	// TemplateVars defines extra placeholders, as Updater.TemplateVars does
+	TemplateVars map[string]string
+	// SASToken is the shared access signature appended to the URL of every request to a private container,
+	// e.g. "sv=2022-11-02&sr=c&sp=r&sig=...". It never appears in logs nor errors.
+	SASToken string
+	// Endpoint is https://<Account>.blob.core.windows.net when empty, e.g. for Azurite or sovereign clouds
+	Endpoint string
+	// HTTPClient performs every request, that of the Updater reading the source is used when nil
+	HTTPClient *http.Client
+
+	// outer is the Updater reading the source
+	outer Updater
+}
+
+func (s AzureSource) bind(u Updater) Source {
+	s.outer = u
+	return s
+}
+
+// LatestVersion reads the version blob at VersionKey
+func (s AzureSource) LatestVersion(ctx context.Context) (string, error) {
+	version, err := s.s3().LatestVersion(ctx)
+	return version, s.redact(err)
+}
+
+// Artifact downloads the blob at ReleaseKey
+func (s AzureSource) Artifact(ctx context.Context, version string) (io.ReadCloser, int64, error) {
+	body, size, err := s.s3().Artifact(ctx, version)
+	return body, size, s.redact(err)
+}
+
+// Checksum reads the blob at ChecksumKey, none being published when it's empty
+func (s AzureSource) Checksum(ctx context.Context, version string) (string, error) {
+	sum, err := s.s3().Checksum(ctx, version)
+	return sum, s.redact(err)
+}
+
+// ArtifactURL returns the URL of the blob at ReleaseKey, without the SAS token
+func (s AzureSource) ArtifactURL(version string) (string, error) {
+	return s.s3().ArtifactURL(version)
+}
+
+// s3 returns the S3 source reading the container, whose blobs are addressed like path-style S3 objects
+func (s AzureSource) s3() s3Source {
+	endpoint := s.Endpoint
+	if endpoint == "" {
+		endpoint = "https://" + s.Account + ".blob.core.windows.net"
+	}
+	u := s.outer.inherit(Updater{
+		S3Bucket:     s.Container,
+		S3VersionKey: s.VersionKey,
+		S3ReleaseKey: s.ReleaseKey,
+		ChecksumKey:  s.ChecksumKey,
+		Channel:      s.Channel,
+		OSMap:        s.OSMap,
+		ArchMap:      s.ArchMap,
+		TemplateVars: s.TemplateVars,
+		Endpoint:     endpoint,
+		PathStyle:    true,
+		HTTPClient:   s.HTTPClient,
+		Logger:       NopLogger,
+	})
+	// the token is added to requests only, so that the URLs reported everywhere else don't hold it
+	u.Signer = func(req *http.Request) error {
+		if sas := strings.TrimPrefix(s.SASToken, "?"); sas != "" {
+			if req.URL.RawQuery != "" {
+				req.URL.RawQuery += "&"
+			}
+			req.URL.RawQuery += sas
+		}
+		return nil
+	}
+	return s3Source{u: u}
+}
+
+// redact removes the SAS token from the URL of the request err comes from, and suggests setting one when access
+// is denied. Private containers answer anonymous requests with 404.
+func (s AzureSource) redact(err error) error {
+	var ue *url.Error
+	if errors.As(err, &ue) {
+		if i := strings.Index(ue.URL, "?"); i >= 0 {
+			ue.URL = ue.URL[:i]
+		}
+	}
+	var de *DownloadError
+	if errors.As(err, &de) && s.SASToken == "" && (de.StatusCode == http.StatusForbidden || de.StatusCode == http.StatusNotFound) {
+		de.Hint = "the container may be private, set SASToken to authenticate requests"
+	}
+	return err
+}
@@ -0,0 +1,118 @@
+package s3update
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+const testSAS = "sv=2022-11-02&sr=c&sp=r&sig=c2VjcmV0LXNpZ25hdHVyZQ%3D%3D"
+
+// testAzureSource returns the source of the releases published to b as the Azure container "releases"
+func testAzureSource(b *testBucket, sas string) AzureSource {
+	return AzureSource{
+		Account:     "account",
+		Container:   "releases",
+		VersionKey:  "VERSION",
+		ReleaseKey:  "mytool-{{VERSION}}",
+		ChecksumKey: "mytool-{{VERSION}}.sha256",
+		SASToken:    sas,
+		Endpoint:    b.URL,
+	}
+}
+
+func TestAzureSourceURLs(t *testing.T) {
+	for _, sas := range []string{"", testSAS, "?" + testSAS} {
+		s := AzureSource{Account: "account", Container: "releases", ReleaseKey: "mytool/{{VERSION}}/mytool", SASToken: sas}
+		got, err := s.ArtifactURL("v1.1.0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "https://account.blob.core.windows.net/releases/mytool/v1.1.0/mytool"; got != want {
+			t.Errorf("SAS %q: got %s, want %s", sas, got, want)
+		}
+	}
+}
+
+func TestAutoUpdateAzure(t *testing.T) {
+	for _, sas := range []string{"", testSAS, "?" + testSAS} {
+		b := newTestBucket(t)
+		b.publishAt("releases/", "v1.1.0", []byte(exe("new binary")))
+		target := newTarget(t, exe("old binary"))
+
+		if err := AutoUpdate(b.sourceUpdater(target, testAzureSource(b, sas))); err != nil {
+			t.Fatalf("SAS %q: %v", sas, err)
+		}
+		assertContents(t, target, exe("new binary"))
+		b.mu.Lock()
+		for _, r := range b.requests {
+			if got, want := r.URL.RawQuery, strings.TrimPrefix(sas, "?"); got != want {
+				t.Errorf("SAS %q: %s %s sent with query %q", sas, r.Method, r.URL.Path, got)
+			}
+		}
+		b.mu.Unlock()
+	}
+}
+
+func TestAzureRedactsSAS(t *testing.T) {
+	tests := []struct {
+		name string
+		key  string
+		h    http.HandlerFunc
+	}{
+		{"connection failure", "releases/VERSION", func(w http.ResponseWriter, r *http.Request) {
+			if conn, _, err := w.(http.Hijacker).Hijack(); err == nil {
+				conn.Close()
+			}
+		}},
+		// some servers echo the URL requested
+		{"unexpected status", "releases/mytool-v1.1.0.sha256", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("failed to read http://" + r.Host + r.URL.String()))
+		}},
+		{"missing release", "releases/mytool-v1.1.0", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTestBucket(t)
+			b.publishAt("releases/", "v1.1.0", []byte(exe("new binary")))
+			b.handle(tt.key, tt.h)
+			target := newTarget(t, exe("old binary"))
+			logger := &recordingLogger{}
+			u := b.sourceUpdater(target, testAzureSource(b, testSAS))
+			u.Logger, u.Verbose = logger, true
+
+			err := AutoUpdate(u)
+			if err == nil {
+				t.Fatal("update succeeded")
+			}
+			if strings.Contains(err.Error(), "c2VjcmV0") {
+				t.Errorf("%q holds the SAS token", err)
+			}
+			if strings.Contains(logger.String(), "c2VjcmV0") {
+				t.Errorf("the SAS token was logged:\n%s", logger)
+			}
+			var de *DownloadError
+			if errors.As(err, &de) && strings.Contains(de.Hint, "SASToken") {
+				t.Errorf("%q suggests setting SASToken, despite it being set", err)
+			}
+		})
+	}
+}
+
+func TestAzurePrivateHint(t *testing.T) {
+	b := newTestBucket(t)
+	b.handle("releases/VERSION", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	target := newTarget(t, exe("old binary"))
+
+	err := AutoUpdate(b.sourceUpdater(target, testAzureSource(b, "")))
+	var de *DownloadError
+	if !errors.As(err, &de) || !strings.Contains(de.Hint, "SASToken") {
+		t.Fatalf("got %v, want a hint at SASToken", err)
+	}
+}
@@ -0,0 +1,97 @@
+package s3update
+
+import (
+	"context"
+	"sync"
+)
+
+// BackgroundUpdate is an update downloaded while the program runs, see StartBackgroundUpdate
+type BackgroundUpdate struct {
+	u      Updater
+	done   chan UpdateResult
+	ready  chan struct{}
+	once   sync.Once
+	info   *UpdateInfo
+	res    UpdateResult
+	staged *stagedUpdate
+	err    error
+}
+
+// StartBackgroundUpdate checks for an update and downloads it concurrently with the program, the release being
+// verified and staged next to the binary. Commit installs it once the program is done. No progress bar is drawn
+// unless ProgressFunc is set, and the update lock is held from the download until Commit returns.
+func StartBackgroundUpdate(ctx context.Context, u Updater) *BackgroundUpdate {
+	u.DisableProgress = true
+	b := &BackgroundUpdate{u: u, done: make(chan UpdateResult, 1), ready: make(chan struct{})}
+	go b.run(ctx)
+	return b
+}
+
+func (b *BackgroundUpdate) run(ctx context.Context) {
+	defer close(b.done)
+	defer close(b.ready)
+	b.res.FromVersion = b.u.CurrentVersion
+	if updatesDisabled() {
+		b.done <- b.res
+		return
+	}
+	b.u = b.u.withEnv()
+	if b.err = b.u.Validate(); b.err != nil {
+		return
+	}
+	removeStaleBackup(b.u)
+	removeLeftovers(b.u)
+	b.info, b.err = checkForUpdate(ctx, b.u)
+	if b.err != nil {
+		return
+	}
+	b.res.FromVersion, b.res.ToVersion = b.info.CurrentVersion, b.info.RemoteVersion
+	if b.info.UpdateAvailable && !b.u.inUpdateWindow() {
+		b.u.logger().Infof("version %s is available, it will be installed during the update window", b.info.RemoteVersion)
+		b.res.Pending = true
+	} else if b.info.UpdateAvailable {
+		b.u.logger().Debugf("updater: downloading %s in the background", b.info.RemoteVersion)
+		b.staged, b.err = stageUpdate(ctx, b.u, b.info, &b.res)
+		if b.err != nil {
+			return
+		}
+		b.res.Pending = b.staged != nil
+	}
+	b.done <- b.res
+}
+
+// Done receives the outcome of the download once the update is staged or there's nothing to install:
+// Pending is then set when Commit would install an update. Nothing is received when the check or download
+// fails, Err tells why.
+func (b *BackgroundUpdate) Done() <-chan UpdateResult {
+	return b.done
+}
+
+// Err returns what made the background update fail, once Done is closed
+func (b *BackgroundUpdate) Err() error {
+	<-b.ready
+	return b.err
+}
+
+// Commit waits for the background download and swaps the binary with the staged release, which is discarded
+// when anything failed. The binary isn't re-run. Cancelling the context given to StartBackgroundUpdate stops the
+// download. Later calls return the outcome of the first one.
+func (b *BackgroundUpdate) Commit() (*UpdateResult, error) {
+	<-b.ready
+	b.once.Do(func() {
+		if b.err != nil || b.staged == nil {
+			return
+		}
+		if b.u.Confirm && !b.u.confirmUpdate(b.info) {
+			b.staged.discard()
+			return
+		}
+		b.res.Pending = false
+		b.err = b.staged.commit(&b.res)
+	})
+	if b.err != nil {
+		return nil, b.err
+	}
+	res := b.res
+	return &res, nil
+}
@@ -0,0 +1,105 @@
+package s3update
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestBackgroundUpdate(t *testing.T) {
+	b := newTestBucket(t)
+	b.publish("v1.1.0", []byte(exe("new binary")))
+	target := newTarget(t, exe("old binary"))
+	u := b.updater(target)
+
+	bg := StartBackgroundUpdate(context.Background(), u)
+	res, ok := <-bg.Done()
+	if !ok || !res.Pending {
+		t.Fatalf("got %+v, %v", res, ok)
+	}
+	// staged while the program runs, the update lock being held
+	assertContents(t, target, exe("old binary"))
+	if err := AutoUpdate(u); !errors.Is(err, ErrUpdateInProgress) {
+		t.Errorf("got %v, want %v", err, ErrUpdateInProgress)
+	}
+
+	for i := 0; i < 2; i++ {
+		got, err := bg.Commit()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Updated || got.Pending || got.ToVersion != "v1.1.0" {
+			t.Errorf("commit %d: got %+v", i, got)
+		}
+	}
+	assertContents(t, target, exe("new binary"))
+	assertAlone(t, target)
+	if n := downloads(b); n != 1 {
+		t.Errorf("release downloaded %d times", n)
+	}
+}
+
+func TestBackgroundUpdateUpToDate(t *testing.T) {
+	b := newTestBucket(t)
+	b.publish("v1.0.0", []byte(exe("same binary")))
+	target := newTarget(t, exe("old binary"))
+
+	bg := StartBackgroundUpdate(context.Background(), b.updater(target))
+	if res := <-bg.Done(); res.Pending {
+		t.Errorf("got %+v", res)
+	}
+	res, err := bg.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Updated {
+		t.Errorf("got %+v", res)
+	}
+	assertContents(t, target, exe("old binary"))
+}
+
+func TestBackgroundUpdateCancelled(t *testing.T) {
+	b := newTestBucket(t)
+	b.publish("v1.1.0", []byte(exe("new binary")))
+	downloading := make(chan struct{})
+	b.handle("mytool-v1.1.0", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			return
+		}
+		w.Write([]byte("#!"))
+		w.(http.Flusher).Flush()
+		close(downloading)
+		<-r.Context().Done()
+	})
+	target := newTarget(t, exe("old binary"))
+	u := b.updater(target)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	bg := StartBackgroundUpdate(ctx, u)
+	select {
+	case <-downloading:
+	case <-time.After(5 * time.Second):
+		t.Fatal("download not started")
+	}
+	cancel()
+	if res, ok := <-bg.Done(); ok {
+		t.Errorf("got %+v", res)
+	}
+	if err := bg.Err(); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+	if _, err := bg.Commit(); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+	assertContents(t, target, exe("old binary"))
+	assertAlone(t, target)
+	// the update lock got released
+	lock, _, err := acquireLock(target+".lock", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock.release()
+}
@@ -0,0 +1,148 @@
+package s3update
+
+import (
+	"bytes"
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"encoding/binary"
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+var (
+	elfMagic   = []byte("\x7fELF")
+	peMagic    = []byte("MZ")
+	machoMagic = [][]byte{
+		{0xcf, 0xfa, 0xed, 0xfe}, // 64-bit
+		{0xce, 0xfa, 0xed, 0xfe}, // 32-bit
+		{0xca, 0xfe, 0xba, 0xbe}, // universal
+	}
+)
+
+// isExecutable reports whether a file starting with header can be run on this platform
+func isExecutable(header []byte) bool {
+	switch runtime.GOOS {
+	case "windows":
+		return bytes.HasPrefix(header, peMagic)
+	case "darwin", "ios":
+		for _, magic := range machoMagic {
+			if bytes.HasPrefix(header, magic) {
+				return true
+			}
+		}
+	default:
+		if bytes.HasPrefix(header, elfMagic) {
+			return true
+		}
+	}
+	return runtime.GOOS != "windows" && bytes.HasPrefix(header, []byte("#!"))
+}
+
+// binaryFormat returns the executable format expected on this platform
+func binaryFormat() string {
+	switch runtime.GOOS {
+	case "windows":
+		return "PE"
+	case "darwin", "ios":
+		return "Mach-O"
+	}
+	return "ELF"
+}
+
+// validateBinary makes sure filename is an executable for runtime.GOOS and runtime.GOARCH, which
+// catches releases uploaded under the key of another platform. Architectures unknown here aren't checked.
+func validateBinary(filename string) error {
+	format, archs, err := binaryArchs(filename)
+	if err != nil {
+		return fmt.Errorf("%w: %s isn't a %s executable for %s", ErrIncompatibleBinary, filename, binaryFormat(), runtime.GOOS)
+	}
+	if format != binaryFormat() {
+		return fmt.Errorf("%w: release format is %s, expected %s for %s", ErrIncompatibleBinary, format, binaryFormat(), runtime.GOOS)
+	}
+	for _, arch := range archs {
+		if arch == runtime.GOARCH || arch == "" {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: release is built for %s, expected %s", ErrIncompatibleBinary, strings.Join(archs, ", "), runtime.GOARCH)
+}
+
+// binaryArchs returns the format of executable filename and the GOARCH values it was built for,
+// an empty one standing for an architecture unknown here
+func binaryArchs(filename string) (string, []string, error) {
+	if f, err := elf.Open(filename); err == nil {
+		defer f.Close()
+		return "ELF", []string{elfArch(f)}, nil
+	}
+	if f, err := macho.Open(filename); err == nil {
+		defer f.Close()
+		return "Mach-O", []string{machoArch(f.Cpu)}, nil
+	}
+	if f, err := macho.OpenFat(filename); err == nil {
+		defer f.Close()
+		var archs []string
+		for _, a := range f.Arches {
+			archs = append(archs, machoArch(a.Cpu))
+		}
+		return "Mach-O", archs, nil
+	}
+	f, err := pe.Open(filename)
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+	return "PE", []string{peArch(f.Machine)}, nil
+}
+
+func elfArch(f *elf.File) string {
+	switch f.Machine {
+	case elf.EM_X86_64:
+		return "amd64"
+	case elf.EM_386:
+		return "386"
+	case elf.EM_AARCH64:
+		return "arm64"
+	case elf.EM_ARM:
+		return "arm"
+	case elf.EM_RISCV:
+		return "riscv64"
+	case elf.EM_S390:
+		return "s390x"
+	case elf.EM_PPC64:
+		if f.ByteOrder == binary.LittleEndian {
+			return "ppc64le"
+		}
+		return "ppc64"
+	}
+	return ""
+}
+
+func machoArch(cpu macho.Cpu) string {
+	switch cpu {
+	case macho.CpuAmd64:
+		return "amd64"
+	case macho.CpuArm64:
+		return "arm64"
+	case macho.Cpu386:
+		return "386"
+	case macho.CpuArm:
+		return "arm"
+	}
+	return ""
+}
+
+func peArch(machine uint16) string {
+	switch machine {
+	case pe.IMAGE_FILE_MACHINE_AMD64:
+		return "amd64"
+	case pe.IMAGE_FILE_MACHINE_I386:
+		return "386"
+	case pe.IMAGE_FILE_MACHINE_ARM64:
+		return "arm64"
+	case pe.IMAGE_FILE_MACHINE_ARMNT:
+		return "arm"
+	}
+	return ""
+}
@@ -0,0 +1,213 @@
+package s3update
+
+import (
+	"bytes"
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// elfHeader returns the header of a little-endian 64-bit ELF executable for machine
+func elfHeader(machine elf.Machine) []byte {
+	var buf bytes.Buffer
+	buf.Write([]byte{0x7f, 'E', 'L', 'F', byte(elf.ELFCLASS64), byte(elf.ELFDATA2LSB), byte(elf.EV_CURRENT)})
+	buf.Write(make([]byte, 9))
+	binary.Write(&buf, binary.LittleEndian, elf.Header64{
+		Type:    uint16(elf.ET_EXEC),
+		Machine: uint16(machine),
+		Version: uint32(elf.EV_CURRENT),
+		Ehsize:  64,
+	})
+	// Header64 repeats the identification bytes written above
+	return append(buf.Bytes()[:16], buf.Bytes()[16+elf.EI_NIDENT:]...)
+}
+
+// machoHeader returns the header of a 64-bit Mach-O executable for cpu
+func machoHeader(cpu macho.Cpu) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, macho.FileHeader{Magic: macho.Magic64, Cpu: cpu, Type: macho.TypeExec})
+	// reserved field of 64-bit headers
+	buf.Write(make([]byte, 4))
+	return buf.Bytes()
+}
+
+// fatHeader returns a universal Mach-O binary holding an executable for each of cpus
+func fatHeader(cpus ...macho.Cpu) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, []uint32{macho.MagicFat, uint32(len(cpus))})
+	offset := uint32(8 + 20*len(cpus))
+	for _, cpu := range cpus {
+		binary.Write(&buf, binary.BigEndian, macho.FatArchHeader{Cpu: cpu, Offset: offset, Size: 32})
+		offset += 32
+	}
+	for _, cpu := range cpus {
+		buf.Write(machoHeader(cpu))
+	}
+	return buf.Bytes()
+}
+
+// peHeader returns the headers of a PE executable for machine
+func peHeader(machine uint16) []byte {
+	b := make([]byte, 0x80)
+	copy(b, "MZ")
+	binary.LittleEndian.PutUint32(b[0x3c:], 0x80)
+	var buf bytes.Buffer
+	buf.Write(b)
+	buf.WriteString("PE\x00\x00")
+	binary.Write(&buf, binary.LittleEndian, pe.FileHeader{Machine: machine})
+	return buf.Bytes()
+}
+
+func TestBinaryArchs(t *testing.T) {
+	tests := []struct {
+		name   string
+		header []byte
+		format string
+		archs  []string
+	}{
+		{"ELF amd64", elfHeader(elf.EM_X86_64), "ELF", []string{"amd64"}},
+		{"ELF arm64", elfHeader(elf.EM_AARCH64), "ELF", []string{"arm64"}},
+		{"ELF unknown", elfHeader(elf.EM_SPARC), "ELF", []string{""}},
+		{"Mach-O amd64", machoHeader(macho.CpuAmd64), "Mach-O", []string{"amd64"}},
+		{"Mach-O arm64", machoHeader(macho.CpuArm64), "Mach-O", []string{"arm64"}},
+		{"Mach-O universal", fatHeader(macho.CpuAmd64, macho.CpuArm64), "Mach-O", []string{"amd64", "arm64"}},
+		{"PE amd64", peHeader(pe.IMAGE_FILE_MACHINE_AMD64), "PE", []string{"amd64"}},
+		{"PE 386", peHeader(pe.IMAGE_FILE_MACHINE_I386), "PE", []string{"386"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			format, archs, err := binaryArchs(tempFile(t, tt.header))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if format != tt.format || strings.Join(archs, ",") != strings.Join(tt.archs, ",") {
+				t.Errorf("got %s %v, want %s %v", format, archs, tt.format, tt.archs)
+			}
+		})
+	}
+	for name, header := range map[string][]byte{
+		"garbage":          []byte("not an executable"),
+		"empty":            nil,
+		"truncated ELF":    elfHeader(elf.EM_X86_64)[:20],
+		"truncated Mach-O": machoHeader(macho.CpuArm64)[:12],
+		"truncated PE":     peHeader(pe.IMAGE_FILE_MACHINE_AMD64)[:0x88],
+	} {
+		if format, _, err := binaryArchs(tempFile(t, header)); err == nil {
+			t.Errorf("%s: read as %s", name, format)
+		}
+	}
+}
+
+// nativeHeaders returns the header of an executable for the running platform and one for another architecture
+func nativeHeaders(t *testing.T) (native, otherArch []byte) {
+	other := map[string]string{"amd64": "arm64"}[runtime.GOARCH]
+	if other == "" {
+		other = "amd64"
+	}
+	elfMachines := map[string]elf.Machine{"amd64": elf.EM_X86_64, "arm64": elf.EM_AARCH64}
+	machoCpus := map[string]macho.Cpu{"amd64": macho.CpuAmd64, "arm64": macho.CpuArm64}
+	peMachines := map[string]uint16{"amd64": pe.IMAGE_FILE_MACHINE_AMD64, "arm64": pe.IMAGE_FILE_MACHINE_ARM64}
+	if _, ok := elfMachines[runtime.GOARCH]; !ok {
+		t.Skipf("no test executable for %s", runtime.GOARCH)
+	}
+	switch binaryFormat() {
+	case "PE":
+		return peHeader(peMachines[runtime.GOARCH]), peHeader(peMachines[other])
+	case "Mach-O":
+		return machoHeader(machoCpus[runtime.GOARCH]), machoHeader(machoCpus[other])
+	}
+	return elfHeader(elfMachines[runtime.GOARCH]), elfHeader(elfMachines[other])
+}
+
+func TestValidateBinary(t *testing.T) {
+	native, otherArch := nativeHeaders(t)
+	otherFormat := elfHeader(elf.EM_X86_64)
+	if binaryFormat() == "ELF" {
+		otherFormat = machoHeader(macho.CpuAmd64)
+	}
+	tests := []struct {
+		name   string
+		header []byte
+		want   string
+	}{
+		{"matching", native, ""},
+		{"another architecture", otherArch, "release is built for"},
+		{"another format", otherFormat, "release format is"},
+		{"garbage", []byte("not an executable"), "isn't a " + binaryFormat() + " executable"},
+		{"truncated", native[:len(native)/2], "isn't a " + binaryFormat() + " executable"},
+	}
+	if binaryFormat() == "Mach-O" {
+		tests = append(tests, struct {
+			name   string
+			header []byte
+			want   string
+		}{"universal", fatHeader(macho.CpuAmd64, macho.CpuArm64), ""})
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateBinary(tempFile(t, tt.header))
+			if tt.want == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrIncompatibleBinary) || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAutoUpdateSkipBinaryValidation(t *testing.T) {
+	_, otherArch := nativeHeaders(t)
+	otherFormat := elfHeader(elf.EM_X86_64)
+	if binaryFormat() == "ELF" {
+		otherFormat = machoHeader(macho.CpuAmd64)
+	}
+	for name, release := range map[string][]byte{
+		"data":                 []byte("plain data"),
+		"another architecture": otherArch,
+		"another format":       otherFormat,
+	} {
+		t.Run(name, func(t *testing.T) {
+			for _, skip := range []bool{false, true} {
+				b := newTestBucket(t)
+				b.publish("v1.1.0", release)
+				target := newTarget(t, exe("old binary"))
+				u := b.updater(target)
+				u.SkipBinaryValidation = skip
+
+				err := AutoUpdate(u)
+				if skip {
+					if err != nil {
+						t.Fatal(err)
+					}
+					assertContents(t, target, string(release))
+					continue
+				}
+				if err == nil {
+					t.Fatal("release installed")
+				}
+				assertContents(t, target, exe("old binary"))
+			}
+		})
+	}
+}
+
+// tempFile writes b to a temporary file and returns its path
+func tempFile(t *testing.T, b []byte) string {
+	t.Helper()
+	filename := filepath.Join(t.TempDir(), "release")
+	if err := ioutil.WriteFile(filename, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
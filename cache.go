@@ -0,0 +1,276 @@
+package s3update
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// Download fetches and verifies the release of the remote version into destDir/<version>/, CacheDir when destDir
+// is empty, and returns its path. The release is kept as published along with its SHA-256 checksum, and its
+// signature when PublicKey is set, for InstallFromFile to install it later without network access. AutoUpdate
+// installs releases found in CacheDir rather than downloading them again, once verified against the published
+// checksum and signature. ErrUpToDate is returned when the remote version isn't newer, unless ForceUpdate is set.
+func Download(u Updater, destDir string) (string, error) {
+	return DownloadContext(context.Background(), u, destDir)
+}
+
+// DownloadContext is like Download but aborts the version check and download when ctx is done.
+func DownloadContext(ctx context.Context, u Updater, destDir string) (string, error) {
+	if destDir == "" {
+		destDir = u.CacheDir
+	}
+	if destDir == "" {
+		return "", errors.New("no download directory set")
+	}
+	u = u.withEnv()
+	if err := u.Validate(); err != nil {
+		return "", err
+	}
+	info, err := checkForUpdate(ctx, u)
+	if err != nil {
+		return "", err
+	}
+	if !info.UpdateAvailable && !u.ForceUpdate {
+		return "", ErrUpToDate
+	}
+
+	dir := filepath.Join(destDir, info.RemoteVersion)
+	artifact := filepath.Join(dir, artifactName(info.DownloadURL))
+	if u.downloadedArtifact(ctx, info, artifact) != "" {
+		u.logger().Debugf("updater: %s already downloaded", artifact)
+		return artifact, nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("creating %s: %w", dir, err)
+	}
+	res := &UpdateResult{}
+	tmp, alg, checksum, err := fetchRelease(ctx, u, info, dir, artifact, res)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp)
+	if u.ChecksumOf == ChecksumOfBinary && checksum != "" {
+		if err := verifyExtracted(u, info, tmp, alg, checksum); err != nil {
+			return "", err
+		}
+	}
+	h, err := hashFile(tmp, ChecksumSHA256)
+	if err != nil {
+		return "", err
+	}
+	line := fmt.Sprintf("%x  %s\n", h.Sum(nil), filepath.Base(artifact))
+	if err := ioutil.WriteFile(artifact+"."+ChecksumSHA256, []byte(line), 0644); err != nil {
+		return "", err
+	}
+	if res.signature != nil {
+		sig := base64.StdEncoding.EncodeToString(res.signature) + "\n"
+		if err := ioutil.WriteFile(artifact+".sig", []byte(sig), 0644); err != nil {
+			return "", err
+		}
+	}
+	if err := os.Chmod(tmp, 0644); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp, artifact); err != nil {
+		return "", err
+	}
+	u.logger().Infof("downloaded %s to %s", info.RemoteVersion, artifact)
+	return artifact, nil
+}
+
+// verifyExtracted checks the binary extracted from a copy of the release archive at filename against checksum
+func verifyExtracted(u Updater, info *UpdateInfo, filename, alg, checksum string) error {
+	f, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("creating temporary file: %w", err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	if err := copyFile(filename, f.Name()); err != nil {
+		return err
+	}
+	if _, _, err := extractArchive(u, info.DownloadURL, f.Name()); err != nil {
+		return fmt.Errorf("extracting %s: %w", filename, err)
+	}
+	return verifyFile(f.Name(), info.RemoteVersion, alg, checksum)
+}
+
+// InstallFromFile installs the release at filename, e.g. saved by Download, without network access.
+// The version is read from the name of the file or of its directory, and must be newer than CurrentVersion
+// unless ForceUpdate is set. A checksum found next to the file as <filename>.sha256 or <filename>.md5 is verified.
+// When PublicKey is set, the file must match the signature saved next to it by Download as <filename>.sig.
+// The binary isn't re-run.
+func InstallFromFile(u Updater, filename string) error {
+	return InstallFromFileContext(context.Background(), u, filename)
+}
+
+// InstallFromFileContext is like InstallFromFile but aborts the VerifyCommand smoke test when ctx is done.
+func InstallFromFileContext(ctx context.Context, u Updater, filename string) error {
+	u = u.withEnv()
+	if err := u.Validate(); err != nil {
+		return err
+	}
+	current := u.normalize(u.CurrentVersion)
+	version := u.artifactVersion(filename)
+	if !u.ForceUpdate {
+		if version == "" {
+			return fmt.Errorf("no version found in %s, set ForceUpdate to install it anyway", filename)
+		}
+		if u.compareVersions(current, version) >= 0 {
+			return fmt.Errorf("%w: %s isn't newer than %s", ErrUpToDate, version, current)
+		}
+	}
+	if version == "" && u.SymlinkMode == SymlinkInstallVersioned {
+		return fmt.Errorf("no version found in %s, required by %s", filename, SymlinkInstallVersioned)
+	}
+	if _, err := verifyArtifact(filename, version); err != nil {
+		return err
+	}
+	// the checksum next to the file is only as trustworthy as the file itself
+	if len(u.PublicKey) > 0 {
+		if err := verifyLocalSignature(u, filename); err != nil {
+			return err
+		}
+	}
+	info := &UpdateInfo{CurrentVersion: current, RemoteVersion: version, DownloadURL: filename, UpdateAvailable: true}
+	res := &UpdateResult{FromVersion: current, ToVersion: version}
+	staged, err := stageArtifact(ctx, u, info, filename, res)
+	if err != nil || staged == nil {
+		return err
+	}
+	return staged.commit(res)
+}
+
+// versionPattern matches the version within file names such as mytool-v1.4.2-linux-amd64.tgz
+var versionPattern = regexp.MustCompile(`v?[0-9]+\.[0-9]+\.[0-9]+`)
+
+// artifactVersion returns the version of the release at filename, named after it by Download, empty when unknown
+func (u Updater) artifactVersion(filename string) string {
+	for _, v := range []string{filepath.Base(filepath.Dir(filename)), versionPattern.FindString(filepath.Base(filename))} {
+		if v != "" && u.validateVersion(u.normalize(v)) == nil {
+			return u.normalize(v)
+		}
+	}
+	return ""
+}
+
+// verifyArtifact checks filename against the checksum stored next to it, reporting whether there was one
+func verifyArtifact(filename, version string) (bool, error) {
+	for _, alg := range []string{ChecksumSHA256, ChecksumMD5} {
+		f, err := os.Open(filename + "." + alg)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return true, err
+		}
+		defer f.Close()
+		alg, digest, err := parseChecksum(f, alg, filepath.Base(filename))
+		if err != nil {
+			return true, err
+		}
+		return true, verifyFile(filename, version, alg, digest)
+	}
+	return false, nil
+}
+
+// cachedArtifact returns the verified release saved in CacheDir by Download for the update described by info,
+// empty when there's none
+func (u Updater) cachedArtifact(ctx context.Context, info *UpdateInfo) string {
+	if u.CacheDir == "" {
+		return ""
+	}
+	return u.downloadedArtifact(ctx, info, filepath.Join(u.CacheDir, info.RemoteVersion, artifactName(info.DownloadURL)))
+}
+
+// downloadedArtifact returns filename when it holds the release described by info, empty otherwise. The checksum
+// stored next to it could have been replaced along with the file, which is verified against the published checksum
+// and signature too, like a download.
+func (u Updater) downloadedArtifact(ctx context.Context, info *UpdateInfo, filename string) string {
+	found, err := verifyArtifact(filename, info.RemoteVersion)
+	if !found {
+		return ""
+	}
+	if err == nil {
+		err = verifyDownloaded(ctx, u, info, filename)
+	}
+	if err != nil {
+		u.logger().Debugf("updater: ignoring %s: %s", filename, err)
+		return ""
+	}
+	return filename
+}
+
+// verifyDownloaded checks the release at filename against the checksum and signature published for info
+func verifyDownloaded(ctx context.Context, u Updater, info *UpdateInfo, filename string) error {
+	alg, checksum, err := fetchChecksum(ctx, u, info)
+	if err != nil {
+		return err
+	}
+	h, err := newHash(alg)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sigHash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(h, sigHash), f); err != nil {
+		return fmt.Errorf("hashing %s: %w", filename, err)
+	}
+	if checksum != "" {
+		if u.ChecksumOf == ChecksumOfBinary {
+			err = verifyExtracted(u, info, filename, alg, checksum)
+		} else {
+			err = matchDigest(h, info.RemoteVersion, alg, checksum)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if len(u.PublicKey) > 0 {
+		_, err = verifySignature(ctx, u, info.SignatureURL, sigHash.Sum(nil))
+	}
+	return err
+}
+
+// copyRelease copies the release at artifact to a temporary file in tmpDir, and returns its path once extracted
+// along with the permissions recorded by the archive
+func copyRelease(u Updater, artifact, tmpDir, dest string, res *UpdateResult) (string, os.FileMode, error) {
+	fi, err := os.Stat(artifact)
+	if err != nil {
+		return "", 0, err
+	}
+	for _, dir := range []string{tmpDir, filepath.Dir(dest)} {
+		if err := checkSpace(dir, requiredSpace(artifact, fi.Size())); err != nil {
+			return "", 0, err
+		}
+	}
+	f, err := ioutil.TempFile(tmpDir, tempPattern(dest))
+	if err != nil {
+		return "", 0, fmt.Errorf("creating temporary file: %w", err)
+	}
+	f.Close()
+	tmp := f.Name()
+	if err := copyFile(artifact, tmp); err != nil {
+		os.Remove(tmp)
+		return "", 0, err
+	}
+	mode, extracted, err := extractArchive(u, artifact, tmp)
+	res.Extracted = extracted
+	if err != nil {
+		os.Remove(tmp)
+		return "", 0, fmt.Errorf("extracting %s: %w", tmp, err)
+	}
+	return tmp, mode, nil
+}
@@ -0,0 +1,170 @@
+package s3update
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDownloadInstallFromFile(t *testing.T) {
+	b := newTestBucket(t)
+	b.publish("v1.1.0", []byte(exe("new binary")))
+	target := newTarget(t, exe("old binary"))
+	u := b.updater(target)
+	dir := t.TempDir()
+
+	path, err := Download(u, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "v1.1.0", "mytool-v1.1.0"); path != want {
+		t.Errorf("got %s, want %s", path, want)
+	}
+	assertContents(t, path, exe("new binary"))
+	if _, err := os.Stat(path + ".sha256"); err != nil {
+		t.Error(err)
+	}
+	assertContents(t, target, exe("old binary"))
+	// downloaded once
+	if _, err := Download(u, dir); err != nil {
+		t.Fatal(err)
+	}
+	if n := downloads(b); n != 1 {
+		t.Errorf("release downloaded %d times", n)
+	}
+
+	if err := InstallFromFile(u, path); err != nil {
+		t.Fatal(err)
+	}
+	assertContents(t, target, exe("new binary"))
+	u.CurrentVersion = "v1.1.0"
+	if err := InstallFromFile(u, path); !errors.Is(err, ErrUpToDate) {
+		t.Errorf("got %v, want %v", err, ErrUpToDate)
+	}
+	if _, err := Download(u, dir); !errors.Is(err, ErrUpToDate) {
+		t.Errorf("got %v, want %v", err, ErrUpToDate)
+	}
+}
+
+func TestInstallFromFileTampered(t *testing.T) {
+	b := newTestBucket(t)
+	b.publish("v1.1.0", []byte(exe("new binary")))
+	target := newTarget(t, exe("old binary"))
+	u := b.updater(target)
+	path, err := Download(u, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(exe("tampered binary")), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := InstallFromFile(u, path); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("got %v, want %v", err, ErrChecksumMismatch)
+	}
+	assertContents(t, target, exe("old binary"))
+}
+
+func TestInstallFromFileSignature(t *testing.T) {
+	b := newTestBucket(t)
+	b.publish("v1.1.0", []byte(exe("new binary")))
+	target := newTarget(t, exe("old binary"))
+	u := b.updater(target)
+	u.PublicKey, u.SignatureKey = b.sign(t, "v1.1.0", []byte(exe("new binary"))), "mytool-{{VERSION}}.sig"
+	path, err := Download(u, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := ioutil.ReadFile(path + ".sig")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the release and its checksum replaced
+	if err := ioutil.WriteFile(path, []byte(exe("tampered binary")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path+".sha256", []byte(sha256Hex(exe("tampered binary"))), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := InstallFromFile(u, path); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("got %v, want %v", err, ErrInvalidSignature)
+	}
+	// the signature removed
+	os.Remove(path + ".sig")
+	if err := InstallFromFile(u, path); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("got %v, want %v", err, ErrInvalidSignature)
+	}
+	assertContents(t, target, exe("old binary"))
+
+	if err := ioutil.WriteFile(path, []byte(exe("new binary")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path+".sha256", []byte(sha256Hex(exe("new binary"))), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path+".sig", sig, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := InstallFromFile(u, path); err != nil {
+		t.Fatal(err)
+	}
+	assertContents(t, target, exe("new binary"))
+}
+
+func TestAutoUpdateCacheDir(t *testing.T) {
+	b := newTestBucket(t)
+	b.publish("v1.1.0", []byte(exe("new binary")))
+	target := newTarget(t, exe("old binary"))
+	u := b.updater(target)
+	u.CacheDir = t.TempDir()
+	if _, err := Download(u, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := AutoUpdate(u); err != nil {
+		t.Fatal(err)
+	}
+	assertContents(t, target, exe("new binary"))
+	if n := downloads(b); n != 1 {
+		t.Errorf("release downloaded %d times", n)
+	}
+}
+
+func TestAutoUpdateCacheDirTampered(t *testing.T) {
+	for _, verification := range []string{"checksum", "signature"} {
+		t.Run(verification, func(t *testing.T) {
+			b := newTestBucket(t)
+			b.publish("v1.1.0", []byte(exe("new binary")))
+			target := newTarget(t, exe("old binary"))
+			u := b.updater(target)
+			u.CacheDir = t.TempDir()
+			if verification == "signature" {
+				u.ChecksumKey = ""
+				u.PublicKey, u.SignatureKey = b.sign(t, "v1.1.0", []byte(exe("new binary"))), "mytool-{{VERSION}}.sig"
+			}
+			// a release planted in the cache along with its checksum
+			dir := filepath.Join(u.CacheDir, "v1.1.0")
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			cached := filepath.Join(dir, "mytool-v1.1.0")
+			if err := ioutil.WriteFile(cached, []byte(exe("tampered binary")), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(cached+".sha256", []byte(sha256Hex(exe("tampered binary"))), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := AutoUpdate(u); err != nil {
+				t.Fatal(err)
+			}
+			assertContents(t, target, exe("new binary"))
+			if n := downloads(b); n != 1 {
+				t.Errorf("release downloaded %d times", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package s3update
+
+import "time"
+
+// defaultCachedAge is how long CheckCached deems a check fresh when CheckInterval isn't set
+const defaultCachedAge = 24 * time.Hour
+
+// CheckCached describes the outcome of the last check of AutoUpdate or CheckForUpdate, as saved in the state file,
+// without any request, e.g. to print an "update available" banner at the end of a command. It reports false when
+// no check is saved, when it's older than CheckInterval, 24h when not set, or when updates are disabled.
+func CheckCached(u Updater) (*UpdateInfo, bool) {
+	if updatesDisabled() {
+		return nil, false
+	}
+	u = u.withEnv()
+	localVersion := u.normalize(u.CurrentVersion)
+	if err := u.validateVersion(localVersion); err != nil {
+		return nil, false
+	}
+	st := u.loadState()
+	if st.RemoteVersion == "" || time.Since(st.LastCheck) >= durationOr(u.CheckInterval, defaultCachedAge) {
+		return nil, false
+	}
+	info, err := describeRemote(u, localVersion, st.RemoteVersion, st.Manifest, st.MinVersion)
+	if err != nil {
+		return nil, false
+	}
+	if info.UpdateAvailable {
+		yanked := u.listed(u.SkipVersions, info.RemoteVersion) ||
+			(st.Manifest != nil && u.listed(st.Manifest.Yanked, info.RemoteVersion)) ||
+			(u.YankedKey != "" && st.YankedVersion != "" && u.compareVersions(u.normalize(st.YankedVersion), info.RemoteVersion) == 0)
+		if yanked {
+			info.UpdateAvailable, info.Yanked = false, true
+		}
+	}
+	deferRollout(u, info, st.Manifest)
+	return info, true
+}
+
+// recordYanked saves whether the remote version is yanked by YankedKey, for CheckCached
+func (u Updater) recordYanked(version string, yanked bool) {
+	st := u.loadState()
+	if !yanked {
+		if st.YankedVersion != version {
+			return
+		}
+		version = ""
+	}
+	if st.YankedVersion == version {
+		return
+	}
+	st.YankedVersion = version
+	if err := u.saveState(st); err != nil {
+		u.logger().Debugf("updater: saving state: %s", err)
+	}
+}
@@ -0,0 +1,60 @@
+package s3update
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckCached(t *testing.T) {
+	b := newTestBucket(t)
+	b.publish("v1.1.0", []byte(exe("new binary")))
+	u := b.updater(newTarget(t, exe("old binary")))
+
+	if info, ok := CheckCached(u); ok {
+		t.Fatalf("got %+v before any check", info)
+	}
+	if _, err := CheckForUpdate(u); err != nil {
+		t.Fatal(err)
+	}
+	requests := len(b.requested())
+	b.Close()
+
+	info, ok := CheckCached(u)
+	if !ok || !info.UpdateAvailable || info.RemoteVersion != "v1.1.0" || info.CurrentVersion != "v1.0.0" {
+		t.Fatalf("got %+v, %t", info, ok)
+	}
+	if n := len(b.requested()); n != requests {
+		t.Errorf("%d requests made", n-requests)
+	}
+	u.CurrentVersion = "v1.1.0"
+	if info, ok := CheckCached(u); !ok || info.UpdateAvailable {
+		t.Errorf("got %+v, %t once up to date", info, ok)
+	}
+	u.CurrentVersion = "v1.0.0"
+
+	st := u.loadState()
+	st.LastCheck = time.Now().Add(-25 * time.Hour)
+	if err := u.saveState(st); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		interval time.Duration
+		fresh    bool
+	}{
+		// 24h by default
+		{0, false},
+		{time.Hour, false},
+		{48 * time.Hour, true},
+	}
+	for _, tt := range tests {
+		u.CheckInterval = tt.interval
+		if info, ok := CheckCached(u); ok != tt.fresh {
+			t.Errorf("checked 25h ago with CheckInterval %s: got %+v, %t", tt.interval, info, ok)
+		}
+	}
+
+	t.Setenv("S3UPDATE_DISABLED", "1")
+	if info, ok := CheckCached(u); ok {
+		t.Errorf("got %+v with updates disabled", info)
+	}
+}
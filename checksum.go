@@ -0,0 +1,217 @@
+package s3update
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Supported values for Updater.ChecksumAlgorithm
+const (
+	ChecksumMD5    = "md5"
+	ChecksumSHA256 = "sha256"
+)
+
+// Supported values for Updater.ChecksumOf
+const (
+	ChecksumOfArchive = "archive"
+	ChecksumOfBinary  = "binary"
+)
+
+// newHash returns the hash.Hash implementing alg
+func newHash(alg string) (hash.Hash, error) {
+	switch alg {
+	case "", ChecksumMD5:
+		return md5.New(), nil
+	case ChecksumSHA256:
+		return sha256.New(), nil
+	}
+	return nil, fmt.Errorf("unsupported checksum algorithm %q", alg)
+}
+
+// parseChecksum extracts the hex digest of artifact out of a checksum document.
+// Both a bare digest, hex or base64 encoded, and the "<digest>  <filename>" format produced by md5sum/shasum are
+// understood; when several lines are present the one naming artifact is selected.
+// An "<algorithm>:" prefix overrides alg, which defaults to md5.
+func parseChecksum(r io.Reader, alg, artifact string) (string, string, error) {
+	var first, digest string
+	entries := 0
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		entries++
+		if entries == 1 {
+			first = fields[0]
+		}
+		// shasum marks binary mode with a leading '*'
+		if len(fields) > 1 && path.Base(strings.TrimPrefix(fields[len(fields)-1], "*")) == artifact {
+			digest = fields[0]
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", "", err
+	}
+	if digest == "" && entries == 1 {
+		digest = first
+	}
+	if digest == "" {
+		return "", "", fmt.Errorf("%w: no entry for %s", ErrMalformedChecksum, artifact)
+	}
+	if i := strings.Index(digest, ":"); i >= 0 {
+		alg, digest = strings.ToLower(digest[:i]), digest[i+1:]
+	}
+	if alg == "" {
+		alg = ChecksumMD5
+	}
+	h, err := newHash(alg)
+	if err != nil {
+		return "", "", err
+	}
+	b, err := hex.DecodeString(digest)
+	if err != nil || len(b) != h.Size() {
+		// as in the checksum headers of S3 and Google Cloud Storage
+		if b, err = base64.StdEncoding.DecodeString(digest); err != nil || len(b) != h.Size() {
+			return "", "", fmt.Errorf("%w: %q is not a valid %s digest for %s", ErrMalformedChecksum, excerpt(digest), alg, artifact)
+		}
+	}
+	return alg, hex.EncodeToString(b), nil
+}
+
+// VerifyArtifact reads r to its end and checks that it matches checksum, in any format ChecksumKey objects may have:
+// a hex or base64 digest, optionally prefixed by "<algorithm>:" to override alg, or the md5sum/shasum line of a
+// single file. alg defaults to ChecksumMD5. A mismatch is reported as a *ChecksumError.
+func VerifyArtifact(r io.Reader, checksum, alg string) error {
+	return verifyReader(r, checksum, alg, "")
+}
+
+// VerifyArtifactFile is like VerifyArtifact for the file at filename, e.g. saved by Download. The entry named
+// after the file is selected from checksum files listing several.
+func VerifyArtifactFile(filename, checksum, alg string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return verifyReader(f, checksum, alg, filepath.Base(filename))
+}
+
+// verifyReader is VerifyArtifact, selecting the entry of artifact from checksum files listing several
+func verifyReader(r io.Reader, checksum, alg, artifact string) error {
+	alg, digest, err := parseChecksum(strings.NewReader(checksum), alg, artifact)
+	if err != nil {
+		return err
+	}
+	h, err := newHash(alg)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(h, r); err != nil {
+		return err
+	}
+	return matchDigest(h, "", alg, digest)
+}
+
+// matchDigest checks that h sums up to the hex digest expected for the release of version
+func matchDigest(h hash.Hash, version, alg, expected string) error {
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != expected {
+		return &ChecksumError{Version: version, Algorithm: alg, Expected: expected, Actual: sum}
+	}
+	return nil
+}
+
+// fetchChecksum returns the algorithm and digest the release described by info must match.
+// The digest is empty when PublicKey or InsecureSkipChecksum allow releases without checksum.
+func fetchChecksum(ctx context.Context, u Updater, info *UpdateInfo) (string, string, error) {
+	artifact := artifactName(info.DownloadURL)
+	if info.Checksum != "" {
+		return parseChecksum(strings.NewReader(info.Checksum), u.ChecksumAlgorithm, artifact)
+	}
+	if u.Source != nil {
+		sum, err := u.sourceChecksum(ctx, info)
+		if err != nil {
+			return "", "", err
+		}
+		if sum != "" {
+			return parseChecksum(strings.NewReader(sum), u.ChecksumAlgorithm, artifact)
+		}
+	}
+	if info.ChecksumURL == "" && len(u.PublicKey) > 0 {
+		u.logger().Debugf("updater: no checksum published, %s is only verified by its signature", artifact)
+		return ChecksumMD5, "", nil
+	}
+	if info.ChecksumURL == "" && u.InsecureSkipChecksum {
+		u.logger().Debugf("updater: no checksum published, %s won't be verified", artifact)
+		return ChecksumMD5, "", nil
+	}
+	if info.ChecksumURL == "" {
+		return "", "", fmt.Errorf("no checksum published for %s", artifact)
+	}
+	resp, err := u.httpGet(ctx, info.ChecksumURL)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusForbidden {
+		// S3 answers 403 for missing keys when the bucket can't be listed
+		return "", "", u.missingArtifact(info, info.ChecksumURL, true)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", "", u.statusError(info.ChecksumURL, resp)
+	}
+	body, err := readBody(info.ChecksumURL, resp, maxChecksumSize)
+	if err != nil {
+		return "", "", err
+	}
+	return parseChecksum(bytes.NewReader(body), u.ChecksumAlgorithm, artifact)
+}
+
+// hashFile returns the hash of filename contents
+func hashFile(filename, alg string) (hash.Hash, error) {
+	h, err := newHash(alg)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// verifyFile checks that filename contents match the checksum published for version
+func verifyFile(filename, version, alg, checksum string) error {
+	h, err := hashFile(filename, alg)
+	if err != nil {
+		return err
+	}
+	return matchDigest(h, version, alg, checksum)
+}
+
+// artifactName returns the file name of the object behind rawURL
+func artifactName(rawURL string) string {
+	if u, err := url.Parse(rawURL); err == nil {
+		return path.Base(u.Path)
+	}
+	return path.Base(rawURL)
+}
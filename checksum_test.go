@@ -0,0 +1,180 @@
+package s3update
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+const testRelease = "release contents"
+
+var (
+	testMD5    = md5Hex(testRelease)
+	testSHA256 = sha256Hex(testRelease)
+)
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestParseChecksum(t *testing.T) {
+	sum := sha256.Sum256([]byte(testRelease))
+	tests := []struct {
+		name, doc, alg   string
+		wantAlg, wantSum string
+	}{
+		{"md5 by default", testMD5, "", ChecksumMD5, testMD5},
+		{"md5", testMD5 + "\n", ChecksumMD5, ChecksumMD5, testMD5},
+		{"sha256", testSHA256, ChecksumSHA256, ChecksumSHA256, testSHA256},
+		{"uppercase", strings.ToUpper(testSHA256), ChecksumSHA256, ChecksumSHA256, testSHA256},
+		{"prefix overrides the algorithm", "sha256:" + testSHA256, ChecksumMD5, ChecksumSHA256, testSHA256},
+		{"uppercase prefix", "SHA256:" + testSHA256, "", ChecksumSHA256, testSHA256},
+		{"base64", base64.StdEncoding.EncodeToString(sum[:]), ChecksumSHA256, ChecksumSHA256, testSHA256},
+		{"sha256sum line", testSHA256 + "  mytool.tgz\n", ChecksumSHA256, ChecksumSHA256, testSHA256},
+		{"binary mode line", testSHA256 + " *mytool.tgz\n", ChecksumSHA256, ChecksumSHA256, testSHA256},
+		{"line of another file", testMD5 + "  other.tgz", "", ChecksumMD5, testMD5},
+		{"several lines", sha256Hex("other") + "  dist/other.tgz\n\n" + testSHA256 + "  dist/mytool.tgz\n",
+			ChecksumSHA256, ChecksumSHA256, testSHA256},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			alg, sum, err := parseChecksum(strings.NewReader(tt.doc), tt.alg, "mytool.tgz")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if alg != tt.wantAlg || sum != tt.wantSum {
+				t.Errorf("got %s:%s, want %s:%s", alg, sum, tt.wantAlg, tt.wantSum)
+			}
+		})
+	}
+}
+
+func TestParseChecksumMalformed(t *testing.T) {
+	tests := []struct {
+		name, doc, alg string
+	}{
+		{"empty", "", ChecksumMD5},
+		{"not hex", strings.Repeat("z", 32), ChecksumMD5},
+		{"md5 for sha256", testMD5, ChecksumSHA256},
+		{"sha256 for md5", testSHA256, ChecksumMD5},
+		{"truncated", testSHA256[:60], ChecksumSHA256},
+		{"error document", "<?xml version=\"1.0\"?><Error><Code>NoSuchKey</Code></Error>", ChecksumMD5},
+		{"no entry for the artifact", testSHA256 + "  a.tgz\n" + testSHA256 + "  b.tgz\n", ChecksumSHA256},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := parseChecksum(strings.NewReader(tt.doc), tt.alg, "mytool.tgz")
+			if !errors.Is(err, ErrMalformedChecksum) {
+				t.Errorf("got %v, want %v", err, ErrMalformedChecksum)
+			}
+		})
+	}
+	if _, _, err := parseChecksum(strings.NewReader("sha1:"+testMD5), "", "mytool.tgz"); err == nil {
+		t.Error("unsupported algorithm accepted")
+	}
+}
+
+func TestVerifyArtifact(t *testing.T) {
+	for _, tt := range []struct{ alg, sum string }{{ChecksumMD5, testMD5}, {ChecksumSHA256, testSHA256}} {
+		if err := VerifyArtifact(strings.NewReader(testRelease), tt.sum, tt.alg); err != nil {
+			t.Errorf("%s: %v", tt.alg, err)
+		}
+
+		err := VerifyArtifact(strings.NewReader("tampered"), tt.sum, tt.alg)
+		var ce *ChecksumError
+		if !errors.As(err, &ce) || !errors.Is(err, ErrChecksumMismatch) {
+			t.Fatalf("%s: got %v, want a *ChecksumError", tt.alg, err)
+		}
+		if ce.Algorithm != tt.alg || ce.Expected != tt.sum {
+			t.Errorf("%s: got %+v", tt.alg, ce)
+		}
+		if !strings.Contains(err.Error(), tt.alg) {
+			t.Errorf("%q doesn't name the algorithm %s", err, tt.alg)
+		}
+	}
+}
+
+func TestAutoUpdateMD5(t *testing.T) {
+	b := newTestBucket(t)
+	b.publish("v1.1.0", []byte(exe("new binary")))
+	b.put("mytool-v1.1.0.md5", []byte(md5Hex(exe("new binary"))))
+	target := newTarget(t, exe("old binary"))
+	u := b.updater(target)
+	u.ChecksumKey, u.ChecksumAlgorithm = "mytool-{{VERSION}}.md5", ""
+
+	if err := AutoUpdate(u); err != nil {
+		t.Fatal(err)
+	}
+	assertContents(t, target, exe("new binary"))
+}
+
+func TestAutoUpdateMalformedChecksum(t *testing.T) {
+	b := newTestBucket(t)
+	b.publish("v1.1.0", []byte(exe("new binary")))
+	b.put("mytool-v1.1.0.sha256", []byte("not a checksum"))
+	target := newTarget(t, exe("old binary"))
+
+	if err := AutoUpdate(b.updater(target)); !errors.Is(err, ErrMalformedChecksum) {
+		t.Fatalf("got %v, want %v", err, ErrMalformedChecksum)
+	}
+	assertContents(t, target, exe("old binary"))
+	for _, r := range b.requested() {
+		if r == "GET mytool-v1.1.0" {
+			t.Error("release downloaded despite the malformed checksum")
+		}
+	}
+}
+
+func TestChecksumOf(t *testing.T) {
+	binary := exe("new binary")
+	archive := tarball(t, gzipWriter, testEntry{name: "mytool", body: binary})
+	tests := []struct {
+		name, checksumOf, of string
+		ok                   bool
+	}{
+		{"archive by default", "", "archive", true},
+		{"archive", ChecksumOfArchive, "archive", true},
+		{"archive checked against the binary", ChecksumOfArchive, "binary", false},
+		{"binary", ChecksumOfBinary, "binary", true},
+		{"binary checked against the archive", ChecksumOfBinary, "archive", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTestBucket(t)
+			b.put("VERSION", []byte("v1.1.0"))
+			b.put("mytool-v1.1.0.tgz", archive)
+			sum := sha256Hex(string(archive))
+			if tt.of == "binary" {
+				sum = sha256Hex(binary)
+			}
+			b.put("mytool-v1.1.0.tgz.sha256", []byte(sum+"  mytool-v1.1.0.tgz\n"))
+			target := newTarget(t, exe("old binary"))
+			u := b.updater(target)
+			u.S3ReleaseKey, u.ChecksumKey, u.ChecksumOf = "mytool-{{VERSION}}.tgz", "mytool-{{VERSION}}.tgz.sha256", tt.checksumOf
+
+			err := AutoUpdate(u)
+			if !tt.ok {
+				if !errors.Is(err, ErrChecksumMismatch) {
+					t.Fatalf("got %v, want %v", err, ErrChecksumMismatch)
+				}
+				assertContents(t, target, exe("old binary"))
+				assertAlone(t, target)
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertContents(t, target, binary)
+		})
+	}
+}
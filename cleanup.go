@@ -0,0 +1,65 @@
+package s3update
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// defaultCleanupAge is how old the leftovers of interrupted updates get before AutoUpdate removes them
+const defaultCleanupAge = 24 * time.Hour
+
+// removeLeftovers deletes the files left next to the target, in TempDir and in CacheDir by crashed or killed
+// updates once older than CleanupAge. The update lock is held so that an update running in another process keeps
+// its files, the cleanup being skipped when it can't be taken right away.
+func removeLeftovers(u Updater) {
+	age := u.CleanupAge
+	if age == 0 {
+		age = defaultCleanupAge
+	}
+	if age < 0 {
+		return
+	}
+	target, err := u.targetPath()
+	if err != nil {
+		return
+	}
+	lock, _, err := acquireLock(target+".lock", 0)
+	if err != nil {
+		u.logger().Debugf("updater: skipping cleanup: %s", err)
+		return
+	}
+	defer lock.release()
+
+	base := filepath.Base(target)
+	dir := filepath.Dir(target)
+	patterns := []string{
+		filepath.Join(dir, "."+base+".*.tmp*"),
+		filepath.Join(dir, ".s3update.*.tmp"),
+		target + ".tmp-link",
+	}
+	// the single backup is what Rollback restores
+	if !u.KeepBackup {
+		patterns = append(patterns, target+".bak")
+	}
+	if u.TempDir != "" {
+		patterns = append(patterns, filepath.Join(u.TempDir, "."+base+".*.tmp*"))
+	}
+	if u.CacheDir != "" {
+		patterns = append(patterns, filepath.Join(u.CacheDir, "*", ".*.tmp*"), filepath.Join(u.CacheDir, "*", "*.tmp"))
+	}
+	for _, pattern := range patterns {
+		matches, _ := filepath.Glob(pattern)
+		for _, m := range matches {
+			fi, err := os.Lstat(m)
+			if err != nil || fi.IsDir() || time.Since(fi.ModTime()) < age {
+				continue
+			}
+			if err := os.Remove(m); err != nil {
+				u.logger().Debugf("updater: removing leftover %s: %s", m, err)
+				continue
+			}
+			u.logger().Debugf("updater: removed leftover %s", m)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+// Package clihelper provides a self-update command for cobra CLIs kept up to date by s3update.
+// It lives in its own module so that the core package doesn't depend on cobra.
+package clihelper
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/automato-io/s3update"
+	"github.com/spf13/cobra"
+)
+
+// ErrUpdateAvailable is returned with --check when a newer version is available, making the CLI exit with an error
+var ErrUpdateAvailable = errors.New("update available")
+
+// NewSelfUpdateCommand returns a "self-update" command updating the binary with u:
+//
+//	mytool self-update                 installs the latest version when newer, once confirmed on the command input
+//	mytool self-update --yes           doesn't ask for confirmation
+//	mytool self-update --check         reports whether an update is available, failing with ErrUpdateAvailable if so
+//	mytool self-update --version-only  prints the latest version
+//	mytool self-update --to v1.2.0     installs the given version, even when older
+//	mytool self-update --force         reinstalls the current or given version, even when yanked
+//	mytool self-update --history       lists the previous updates
+//
+// Messages go to the command output, or are replaced by the events of u in OutputJSON mode. The updated binary
+// isn't re-run.
+func NewSelfUpdateCommand(u s3update.Updater) *cobra.Command {
+	var check, versionOnly, force, yes, history bool
+	var to string
+	cmd := &cobra.Command{
+		Use:          "self-update",
+		Short:        "Update to the latest version",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+	}
+	cmd.Flags().BoolVar(&check, "check", false, "only check whether an update is available")
+	cmd.Flags().BoolVar(&versionOnly, "version-only", false, "only print the latest version")
+	cmd.Flags().BoolVar(&force, "force", false, "reinstall the current version")
+	cmd.Flags().StringVar(&to, "to", "", "install the given version")
+	cmd.Flags().BoolVarP(&yes, "yes", "y", false, "don't ask for confirmation")
+	cmd.Flags().BoolVar(&history, "history", false, "list the previous updates")
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		u := u
+		// an explicit update is installed right away, and the command isn't re-run
+		u.NoExit, u.NoRestart, u.ForceCheck, u.DeferInstall = true, true, true, false
+		u.UpdateWindow = s3update.UpdateWindow{}
+		u.Confirm = false
+		u.ForceUpdate = force
+		printf := func(format string, args ...interface{}) {
+			if u.OutputFormat != s3update.OutputJSON {
+				fmt.Fprintf(cmd.OutOrStdout(), format+"\n", args...)
+			}
+		}
+		ctx := cmd.Context()
+		if ctx == nil {
+			// run by Execute rather than ExecuteContext
+			ctx = context.Background()
+		}
+
+		if history {
+			return printHistory(cmd.OutOrStdout(), u)
+		}
+		if to != "" {
+			if check || versionOnly {
+				return errors.New("--to can't be combined with --check nor --version-only")
+			}
+			u.AllowDowngrade = true
+			return installed(s3update.UpdateToContext(ctx, u, to))
+		}
+		info, err := s3update.CheckForUpdateContext(ctx, u)
+		if err != nil {
+			return err
+		}
+		switch {
+		case versionOnly:
+			printf("%s", info.RemoteVersion)
+			return nil
+		case check:
+			if !info.UpdateAvailable {
+				printf("%s is the latest version", info.CurrentVersion)
+				return nil
+			}
+			printf("version %s is available (current %s)", info.RemoteVersion, info.CurrentVersion)
+			cmd.SilenceErrors = true
+			return ErrUpdateAvailable
+		case !info.UpdateAvailable && force:
+			return installed(s3update.UpdateToContext(ctx, u, u.CurrentVersion))
+		case !info.UpdateAvailable:
+			printf("%s is the latest version", info.CurrentVersion)
+			return nil
+		}
+		// asked here rather than by the Updater, which skips the versions declined during automatic updates
+		if !yes && !confirm(cmd, u, info) {
+			printf("version %s wasn't installed", info.RemoteVersion)
+			return nil
+		}
+		return installed(s3update.ApplyUpdateContext(ctx, u, info))
+	}
+	return cmd
+}
+
+// confirm asks on the command input whether to install the update described by info, with the prompt of u
+func confirm(cmd *cobra.Command, u s3update.Updater, info *s3update.UpdateInfo) bool {
+	prompt := fmt.Sprintf("Version %s is available (current %s). Update now? [y/N] ", info.RemoteVersion, info.CurrentVersion)
+	if u.ConfirmPrompt != nil {
+		prompt = u.ConfirmPrompt(info)
+	}
+	fmt.Fprint(cmd.ErrOrStderr(), prompt)
+	answer, _ := bufio.NewReader(cmd.InOrStdin()).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
+
+// printHistory lists the update attempts recorded for u, as JSON lines in OutputJSON mode
+func printHistory(w io.Writer, u s3update.Updater) error {
+	entries, err := s3update.History(u)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	for _, e := range entries {
+		if u.OutputFormat == s3update.OutputJSON {
+			if err := enc.Encode(e); err != nil {
+				return err
+			}
+			continue
+		}
+		outcome := "ok"
+		if !e.Success {
+			outcome = "failed: " + e.Error
+		}
+		fmt.Fprintf(w, "%s  %s -> %s  %s\n", e.Time.Local().Format(time.RFC3339), e.From, e.To, outcome)
+	}
+	return nil
+}
+
+// installed drops the errors reporting that the binary got replaced
+func installed(err error) error {
+	if err == s3update.ErrRestartRequired || err == s3update.ErrUpdated {
+		return nil
+	}
+	return err
+}
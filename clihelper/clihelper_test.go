@@ -0,0 +1,119 @@
+package clihelper
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/automato-io/s3update"
+)
+
+// newBucket serves the release v1.1.0 of mytool, counting the version checks
+func newBucket(t *testing.T) (*httptest.Server, *int) {
+	binary := []byte("#!new binary")
+	sum := sha256.Sum256(binary)
+	objects := map[string][]byte{
+		"/VERSION":              []byte("v1.1.0"),
+		"/mytool-v1.1.0":        binary,
+		"/mytool-v1.1.0.sha256": []byte(hex.EncodeToString(sum[:]) + "  mytool-v1.1.0\n"),
+	}
+	var mu sync.Mutex
+	checks := 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		if r.URL.Path == "/VERSION" {
+			checks++
+		}
+		mu.Unlock()
+		data, ok := objects[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(data)
+	}))
+	t.Cleanup(s.Close)
+	return s, &checks
+}
+
+func TestSelfUpdate(t *testing.T) {
+	tests := []struct {
+		name, input string
+		args        []string
+		updated     bool
+	}{
+		{"confirmed", "y\n", nil, true},
+		{"declined", "n\n", nil, false},
+		{"no answer", "", nil, false},
+		{"yes", "", []string{"--yes"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bucket, checks := newBucket(t)
+			dir := t.TempDir()
+			target := filepath.Join(dir, "bin", "mytool")
+			stateFile := filepath.Join(dir, "state", "s3update.json")
+			for filename, data := range map[string]string{
+				target: "#!old binary",
+				// the version was declined when asked by an automatic update
+				stateFile: `{"declinedVersion": "v1.1.0"}`,
+			} {
+				if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := ioutil.WriteFile(filename, []byte(data), 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			cmd := NewSelfUpdateCommand(s3update.Updater{
+				CurrentVersion:       "v1.0.0",
+				BaseURL:              bucket.URL,
+				AllowInsecureHTTP:    true,
+				S3VersionKey:         "VERSION",
+				S3ReleaseKey:         "mytool-{{VERSION}}",
+				ChecksumKey:          "mytool-{{VERSION}}.sha256",
+				ChecksumAlgorithm:    s3update.ChecksumSHA256,
+				TargetPath:           target,
+				StateFile:            stateFile,
+				SkipBinaryValidation: true,
+				DisableProgress:      true,
+				DisableEnvOverrides:  true,
+				Confirm:              true,
+				Logger:               s3update.NopLogger,
+			})
+			var out, errOut bytes.Buffer
+			cmd.SetArgs(tt.args)
+			cmd.SetIn(strings.NewReader(tt.input))
+			cmd.SetOut(&out)
+			cmd.SetErr(&errOut)
+
+			if err := cmd.Execute(); err != nil {
+				t.Fatal(err)
+			}
+			data, err := ioutil.ReadFile(target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if updated := string(data) == "#!new binary"; updated != tt.updated {
+				t.Errorf("updated %v, want %v; output %q", updated, tt.updated, out.String())
+			}
+			if prompted := strings.Contains(errOut.String(), "Update now?"); prompted == (len(tt.args) > 0) {
+				t.Errorf("prompted %v with %v", prompted, tt.args)
+			}
+			if !tt.updated && !strings.Contains(out.String(), "version v1.1.0 wasn't installed") {
+				t.Errorf("got %q", out.String())
+			}
+			if *checks != 1 {
+				t.Errorf("version checked %d times", *checks)
+			}
+		})
+	}
+}
@@ -0,0 +1,11 @@
+module github.com/automato-io/s3update/clihelper
+
+go 1.15
+
+require (
+	github.com/automato-io/s3update v0.0.0
+	github.com/spf13/cobra v1.1.3
+)
+
+// the helper is developed along with the package it wraps
+replace github.com/automato-io/s3update => ../
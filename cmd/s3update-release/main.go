@@ -0,0 +1,141 @@
+// Command s3update-release publishes a release for s3update clients: the artifacts given as arguments,
+// their checksums and finally the version object.
+//
+//	s3update-release -bucket mybucket -version v1.4.2 \
+//		-release-key 'mytool/mytool-{{OS}}-{{ARCH}}' -checksum-key 'mytool/mytool-{{OS}}-{{ARCH}}.md5' \
+//		dist/mytool-linux-amd64 darwin/arm64=dist/mytool-mac
+//
+// The platform of an artifact is read from its file name unless given as <os>/<arch>=<file>.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/automato-io/s3update"
+	"github.com/automato-io/s3update/publish"
+)
+
+func main() {
+	var r publish.Release
+	flag.StringVar(&r.Bucket, "bucket", "", "bucket to publish to")
+	flag.StringVar(&r.Region, "region", "", "region of the bucket, us-east-1 by default")
+	flag.StringVar(&r.Endpoint, "endpoint", "", "base URL of an S3-compatible service")
+	flag.BoolVar(&r.PathStyle, "path-style", false, "address the bucket in the path rather than the host")
+	flag.StringVar(&r.Version, "version", "", "version to publish")
+	flag.StringVar(&r.VersionKey, "version-key", "VERSION", "key of the version object")
+	flag.StringVar(&r.ReleaseKey, "release-key", "", "key template of the artifacts")
+	flag.StringVar(&r.ChecksumKey, "checksum-key", "", "key template of the checksums")
+	flag.StringVar(&r.ChecksumAlgorithm, "checksum-algorithm", s3update.ChecksumMD5, "md5 or sha256")
+	flag.StringVar(&r.Channel, "channel", "", "release channel substituted to {{CHANNEL}}")
+	flag.BoolVar(&r.Overwrite, "force", false, "overwrite artifacts already published")
+	flag.BoolVar(&r.DryRun, "dry-run", false, "print the keys that would be written without uploading")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] [<os>/<arch>=]<file>...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := run(r, flag.Args()); err != nil {
+		fmt.Fprintf(os.Stderr, "s3update-release: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(r publish.Release, args []string) error {
+	r.Artifacts = map[string]io.Reader{}
+	for _, arg := range args {
+		platform, filename, err := parseArtifact(arg)
+		if err != nil {
+			return err
+		}
+		if _, ok := r.Artifacts[platform]; ok {
+			return fmt.Errorf("several artifacts for %s", platform)
+		}
+		f, err := os.Open(filename)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r.Artifacts[platform] = f
+	}
+	// fail before uploading anything when the keys are wrong
+	urls, err := r.URLs()
+	if err != nil {
+		return err
+	}
+	if err := publish.Publish(context.Background(), r); err != nil {
+		return err
+	}
+	platforms := make([]string, 0, len(urls))
+	for p := range urls {
+		platforms = append(platforms, p)
+	}
+	sort.Strings(platforms)
+	if r.DryRun {
+		fmt.Printf("clients would fetch %s from:\n", r.Version)
+	} else {
+		fmt.Printf("published %s, clients will fetch:\n", r.Version)
+	}
+	for _, p := range platforms {
+		fmt.Printf("  %-20s %s\n", p, urls[p])
+	}
+	return nil
+}
+
+// parseArtifact splits an [<os>/<arch>=]<file> argument
+func parseArtifact(arg string) (string, string, error) {
+	if i := strings.Index(arg, "="); i >= 0 {
+		p, err := s3update.ParsePlatform(arg[:i])
+		if err != nil {
+			return "", "", err
+		}
+		return p.String(), arg[i+1:], nil
+	}
+	p, ok := platformOf(filepath.Base(arg))
+	if !ok {
+		return "", "", fmt.Errorf("can't tell the platform of %s, pass it as <os>/<arch>=%s", arg, arg)
+	}
+	return p.String(), arg, nil
+}
+
+var (
+	knownOS = map[string]string{
+		"linux": "linux", "darwin": "darwin", "macos": "darwin", "windows": "windows", "freebsd": "freebsd",
+		"openbsd": "openbsd", "netbsd": "netbsd", "dragonfly": "dragonfly", "solaris": "solaris",
+	}
+	knownArch = map[string]string{
+		"amd64": "amd64", "x86_64": "amd64", "386": "386", "i386": "386", "arm64": "arm64", "aarch64": "arm64",
+		"arm": "arm", "ppc64le": "ppc64le", "s390x": "s390x", "riscv64": "riscv64", "mips64le": "mips64le",
+	}
+)
+
+// platformOf infers the platform from a file name such as mytool-linux-armv7.tar.gz
+func platformOf(name string) (s3update.Platform, bool) {
+	var p s3update.Platform
+	words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return r == '-' || r == '_' || r == '.'
+	})
+	for _, w := range words {
+		switch {
+		case knownOS[w] != "" && p.OS == "":
+			p.OS = knownOS[w]
+		case knownArch[w] != "" && p.Arch == "":
+			p.Arch = knownArch[w]
+		case (w == "armv5" || w == "armv6" || w == "armv7") && p.Arch == "":
+			p.Arch, p.Arm = "arm", w[len("armv"):]
+		case w == s3update.LibcMusl || w == s3update.LibcGlibc || w == "gnu":
+			p.Libc = strings.Replace(w, "gnu", s3update.LibcGlibc, 1)
+		}
+	}
+	return p, p.OS != "" && p.Arch != ""
+}
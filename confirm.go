@@ -0,0 +1,46 @@
+package s3update
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// confirmTerminal returns where the user answers and is prompted, and whether that is a terminal,
+// a variable so that tests can answer
+var confirmTerminal = func() (io.Reader, io.Writer, bool) {
+	return os.Stdin, os.Stderr, isTerminal(os.Stdin)
+}
+
+// confirmUpdate asks the user on the terminal whether to install the update described by info.
+// A declined version is remembered so that the user is asked again only about newer ones.
+// ConfirmDefault is the answer when stdin isn't a terminal.
+func (u Updater) confirmUpdate(info *UpdateInfo) bool {
+	st := u.loadState()
+	if st.DeclinedVersion != "" && u.compareVersions(u.normalize(st.DeclinedVersion), info.RemoteVersion) == 0 {
+		u.logger().Debugf("updater: %s was declined", info.RemoteVersion)
+		return false
+	}
+	in, out, terminal := confirmTerminal()
+	if !terminal {
+		u.logger().Debugf("updater: stdin isn't a terminal, update confirmed: %t", u.ConfirmDefault)
+		return u.ConfirmDefault
+	}
+	prompt := fmt.Sprintf("Version %s is available (current %s). Update now? [y/N] ", info.RemoteVersion, info.CurrentVersion)
+	if u.ConfirmPrompt != nil {
+		prompt = u.ConfirmPrompt(info)
+	}
+	fmt.Fprint(out, prompt)
+	answer, _ := bufio.NewReader(in).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	st.DeclinedVersion = info.RemoteVersion
+	if err := u.saveState(st); err != nil {
+		u.logger().Debugf("updater: saving state: %s", err)
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+package s3update
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+// answer makes the terminal answer the confirmation prompts with answers, in turn, the prompts being written to
+// the buffer returned. No terminal is attached when answers is nil.
+func answer(t *testing.T, answers []string) *bytes.Buffer {
+	var prompts bytes.Buffer
+	orig := confirmTerminal
+	confirmTerminal = func() (io.Reader, io.Writer, bool) {
+		if len(answers) == 0 {
+			return strings.NewReader(""), &prompts, answers != nil
+		}
+		in := strings.NewReader(answers[0] + "\n")
+		answers = answers[1:]
+		return in, &prompts, true
+	}
+	t.Cleanup(func() { confirmTerminal = orig })
+	return &prompts
+}
+
+func TestAutoUpdateConfirm(t *testing.T) {
+	tests := []struct {
+		name      string
+		answers   []string
+		fallback  bool
+		installed bool
+	}{
+		{"approved", []string{"y"}, false, true},
+		{"approved in full", []string{"YES"}, false, true},
+		{"declined", []string{"n"}, false, false},
+		{"no answer", []string{""}, false, false},
+		{"no terminal", nil, false, false},
+		{"no terminal confirmed by default", nil, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prompts := answer(t, tt.answers)
+			b := newTestBucket(t)
+			b.publish("v1.1.0", []byte(exe("new binary")))
+			target := newTarget(t, exe("old binary"))
+			u := b.updater(target)
+			u.Confirm, u.ConfirmDefault = true, tt.fallback
+
+			res, err := AutoUpdateResult(u)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Updated != tt.installed {
+				t.Errorf("updated %v, want %v", res.Updated, tt.installed)
+			}
+			if tt.installed {
+				assertContents(t, target, exe("new binary"))
+			} else {
+				assertContents(t, target, exe("old binary"))
+				if n := downloads(b); n != 0 {
+					t.Errorf("release downloaded %d times", n)
+				}
+			}
+			if want := "Version v1.1.0 is available (current v1.0.0)"; tt.answers != nil && !strings.Contains(prompts.String(), want) {
+				t.Errorf("prompted %q, want %q", prompts, want)
+			}
+		})
+	}
+}
+
+func TestAutoUpdateDeclinedOnce(t *testing.T) {
+	prompts := answer(t, []string{"n", "y"})
+	b := newTestBucket(t)
+	b.publish("v1.1.0", []byte(exe("new binary")))
+	target := newTarget(t, exe("old binary"))
+	u := b.updater(target)
+	u.Confirm = true
+	u.ConfirmPrompt = func(info *UpdateInfo) string {
+		return fmt.Sprintf("install %s? ", info.RemoteVersion)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := AutoUpdate(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the declined version isn't asked about again
+	assertContents(t, target, exe("old binary"))
+	if got := prompts.String(); got != "install v1.1.0? " {
+		t.Errorf("prompted %q", got)
+	}
+
+	b.publish("v1.2.0", []byte(exe("newer binary")))
+	if err := AutoUpdate(u); err != nil {
+		t.Fatal(err)
+	}
+	assertContents(t, target, exe("newer binary"))
+}
@@ -0,0 +1,284 @@
+package s3update
+
+import (
+	"bufio"
+	"compress/bzip2"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/gabstv/go-bsdiff/pkg/bsdiff"
+)
+
+// errNoPatch is returned when no patch is published from the current version to the remote one
+var errNoPatch = errors.New("no patch published")
+
+// CreatePatch writes to patch a bsdiff patch turning the binary oldBin into newBin, to be published at
+// Updater.PatchKey. Both binaries are held in memory.
+func CreatePatch(oldBin, newBin io.Reader, patch io.Writer) error {
+	return bsdiff.Reader(oldBin, newBin, patch)
+}
+
+// patchURL returns the URL of the patch from the current version to the one described by info
+func (u Updater) patchURL(info *UpdateInfo) string {
+	return generateURL(u, u.PatchKey, info.RemoteVersion)
+}
+
+// canPatch reports whether the binary produced by a patch can be verified like the full release would,
+// which requires the checksum and signature to cover the binary rather than an archive
+func (u Updater) canPatch(info *UpdateInfo) bool {
+	if !isArchiveExt(path.Ext(info.DownloadURL)) {
+		return true
+	}
+	return u.ChecksumOf == ChecksumOfBinary && len(u.PublicKey) == 0
+}
+
+// downloadPatch builds the release described by info by applying the patch published at PatchKey to the
+// binary at target, and returns the path of the verified result, a temporary file in tmpDir.
+// The patch is spooled to disk and applied by streaming, so that neither binary is held in memory.
+// Any error means the full release should be downloaded instead.
+func downloadPatch(ctx context.Context, u Updater, info *UpdateInfo, target, tmpDir, dest string, res *UpdateResult) (_ string, err error) {
+	if !u.canPatch(info) {
+		return "", fmt.Errorf("%w: the checksum of %s doesn't cover the binary", errNoPatch, redactURL(info.DownloadURL))
+	}
+	// a patch only applies to the binary it was created from, which may have been modified or replaced
+	if err := checkBase(ctx, u, info, target); err != nil {
+		return "", err
+	}
+	alg, checksum, err := fetchChecksum(ctx, u, info)
+	if err != nil {
+		return "", err
+	}
+	if checksum == "" {
+		return "", fmt.Errorf("%w: patched binaries must be verified by a checksum", errNoPatch)
+	}
+	h, err := newHash(alg)
+	if err != nil {
+		return "", err
+	}
+
+	patchURL := u.patchURL(info)
+	u.logger().Debugf("patchURL: %s", patchURL)
+	patch, size, err := fetchPatch(ctx, u, patchURL, tmpDir, dest)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		patch.Close()
+		os.Remove(patch.Name())
+	}()
+	current, err := os.Open(target)
+	if err != nil {
+		return "", err
+	}
+	defer current.Close()
+	fi, err := current.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	f, err := ioutil.TempFile(tmpDir, tempPattern(dest))
+	if err != nil {
+		return "", fmt.Errorf("creating temporary file: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	digest := sha256.New()
+	if err := applyPatch(current, fi.Size(), patch, size, io.MultiWriter(f, h, digest)); err != nil {
+		return "", fmt.Errorf("applying %s: %w", patchURL, err)
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != checksum {
+		return "", &ChecksumError{Version: info.RemoteVersion, Algorithm: alg, Expected: checksum, Actual: sum}
+	}
+	if len(u.PublicKey) > 0 {
+		if _, err := verifySignature(ctx, u, info.SignatureURL, digest.Sum(nil)); err != nil {
+			return "", err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	res.BytesDownloaded, res.Patched = size, true
+	res.checksum = ChecksumSHA256 + ":" + hex.EncodeToString(digest.Sum(nil))
+	return f.Name(), nil
+}
+
+// checkBase makes sure the binary at target is the one of the current version, as described by the checksum listed
+// for it by the manifest or published for its release. Nothing is checked when that checksum is unknown, the
+// patched binary being verified anyway.
+func checkBase(ctx context.Context, u Updater, info *UpdateInfo, target string) error {
+	base := info.BaseChecksum
+	if base == "" && !u.ManifestMode && u.CurrentVersion != "" {
+		alg, sum, err := fetchChecksum(ctx, u, &UpdateInfo{
+			RemoteVersion: u.normalize(u.CurrentVersion),
+			DownloadURL:   u.artifactURL(u.CurrentVersion),
+			ChecksumURL:   u.checksumURL(u.CurrentVersion),
+			rawVersion:    u.CurrentVersion,
+		})
+		if err != nil {
+			u.logger().Debugf("updater: checksum of %s unavailable: %s", u.CurrentVersion, err)
+			return nil
+		}
+		if sum != "" {
+			base = alg + ":" + sum
+		}
+	}
+	if base == "" {
+		return nil
+	}
+	alg, sum, err := parseChecksum(strings.NewReader(base), u.ChecksumAlgorithm, "")
+	if err != nil {
+		return err
+	}
+	h, err := hashFile(target, alg)
+	if err != nil {
+		return err
+	}
+	if actual := hex.EncodeToString(h.Sum(nil)); actual != sum {
+		return fmt.Errorf("%w: %s doesn't match the checksum of %s", errNoPatch, target, u.CurrentVersion)
+	}
+	return nil
+}
+
+// fetchPatch downloads the patch at patchURL to a temporary file in tmpDir, returned open along with its size
+func fetchPatch(ctx context.Context, u Updater, patchURL, tmpDir, dest string) (*os.File, int64, error) {
+	resp, err := u.httpDownload(ctx, patchURL)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusForbidden {
+		// S3 answers 403 for missing keys when the bucket can't be listed
+		return nil, 0, fmt.Errorf("%w at %s", errNoPatch, patchURL)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, u.statusError(patchURL, resp)
+	}
+	f, err := ioutil.TempFile(tmpDir, "."+filepath.Base(dest)+".*.tmp.bsdiff")
+	if err != nil {
+		return nil, 0, fmt.Errorf("creating temporary file: %w", err)
+	}
+	n, err := io.Copy(f, u.progressReader(u.throttle(ctx, resp.Body), resp.ContentLength))
+	if err == nil && resp.ContentLength >= 0 && n != resp.ContentLength {
+		err = truncatedError(patchURL, n, resp.ContentLength)
+	} else if err != nil {
+		err = &DownloadError{URL: patchURL, Err: err}
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, 0, err
+	}
+	return f, n, nil
+}
+
+// applyPatch writes to w the binary the bsdiff patch of size patchSize turns old, of size oldSize, into.
+// Both are read at the offsets the patch refers to rather than loaded in memory.
+func applyPatch(old io.ReaderAt, oldSize int64, patch io.ReaderAt, patchSize int64, w io.Writer) error {
+	//	0	8	"BSDIFF40"
+	//	8	8	length of the control block
+	//	16	8	length of the diff block
+	//	24	8	size of the new binary
+	//	32	...	bzip2 compressed control, diff and extra blocks
+	// The control block is a list of triples (x, y, z): add x bytes of the diff block to the next x bytes of the
+	// old binary, copy y bytes of the extra block, then move forwards in the old binary by z bytes.
+	var header [32]byte
+	if _, err := patch.ReadAt(header[:], 0); err != nil {
+		return errCorruptPatch
+	}
+	if string(header[:8]) != "BSDIFF40" {
+		return errCorruptPatch
+	}
+	ctrlLen, diffLen, newSize := offtin(header[8:]), offtin(header[16:]), offtin(header[24:])
+	if ctrlLen < 0 || diffLen < 0 || newSize < 0 || 32+ctrlLen+diffLen > patchSize {
+		return errCorruptPatch
+	}
+	ctrl := bzip2.NewReader(bufio.NewReader(io.NewSectionReader(patch, 32, ctrlLen)))
+	diff := bzip2.NewReader(bufio.NewReader(io.NewSectionReader(patch, 32+ctrlLen, diffLen)))
+	extra := bzip2.NewReader(bufio.NewReader(io.NewSectionReader(patch, 32+ctrlLen+diffLen, patchSize-32-ctrlLen-diffLen)))
+
+	buf, oldBuf := make([]byte, 32<<10), make([]byte, 32<<10)
+	var triple [24]byte
+	oldPos, newPos := int64(0), int64(0)
+	for newPos < newSize {
+		if _, err := io.ReadFull(ctrl, triple[:]); err != nil {
+			return errCorruptPatch
+		}
+		x, y, z := offtin(triple[:8]), offtin(triple[8:16]), offtin(triple[16:])
+		if x < 0 || y < 0 || newPos+x+y > newSize {
+			return errCorruptPatch
+		}
+		for x > 0 {
+			n := int64(len(buf))
+			if x < n {
+				n = x
+			}
+			if _, err := io.ReadFull(diff, buf[:n]); err != nil {
+				return errCorruptPatch
+			}
+			if err := readOld(old, oldSize, oldBuf[:n], oldPos); err != nil {
+				return err
+			}
+			for i := range buf[:n] {
+				buf[i] += oldBuf[i]
+			}
+			if _, err := w.Write(buf[:n]); err != nil {
+				return err
+			}
+			x, oldPos, newPos = x-n, oldPos+n, newPos+n
+		}
+		if _, err := io.CopyN(w, extra, y); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return errCorruptPatch
+			}
+			return err
+		}
+		oldPos, newPos = oldPos+z, newPos+y
+	}
+	return nil
+}
+
+// errCorruptPatch is returned by applyPatch for patches that aren't valid bsdiff patches
+var errCorruptPatch = errors.New("corrupt patch")
+
+// readOld fills p with the bytes of old at pos, bytes out of its range of size oldSize reading as zeros
+func readOld(old io.ReaderAt, oldSize int64, p []byte, pos int64) error {
+	for i := range p {
+		p[i] = 0
+	}
+	start, end := pos, pos+int64(len(p))
+	if start < 0 {
+		start = 0
+	}
+	if end > oldSize {
+		end = oldSize
+	}
+	if start >= end {
+		return nil
+	}
+	_, err := old.ReadAt(p[start-pos:end-pos], start)
+	return err
+}
+
+// offtin decodes the signed integers of bsdiff patches: little endian, the highest bit being the sign
+func offtin(b []byte) int64 {
+	v := int64(binary.LittleEndian.Uint64(b) &^ (1 << 63))
+	if b[7]&0x80 != 0 {
+		return -v
+	}
+	return v
+}
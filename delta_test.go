@@ -0,0 +1,135 @@
+package s3update
+
+import (
+	"bytes"
+	"math/rand"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// testPatch returns the bsdiff patch from oldBin to newBin
+func testPatch(t *testing.T, oldBin, newBin string) []byte {
+	t.Helper()
+	var patch bytes.Buffer
+	if err := CreatePatch(strings.NewReader(oldBin), strings.NewReader(newBin), &patch); err != nil {
+		t.Fatal(err)
+	}
+	return patch.Bytes()
+}
+
+func TestApplyPatch(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	random := func(n int) string {
+		b := make([]byte, n)
+		r.Read(b)
+		return string(b)
+	}
+	base := random(200 << 10)
+	tests := []struct{ name, oldBin, newBin string }{
+		{"identical", base, base},
+		{"edited", base, base[:1000] + "edit" + base[1004:100<<10] + random(10) + base[100<<10:]},
+		{"grown", base, base + random(50<<10)},
+		{"shrunk", base, base[10<<10 : 150<<10]},
+		{"rewritten", base, random(100 << 10)},
+		{"from empty", "", random(1000)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patch := testPatch(t, tt.oldBin, tt.newBin)
+			var got bytes.Buffer
+			err := applyPatch(strings.NewReader(tt.oldBin), int64(len(tt.oldBin)), bytes.NewReader(patch), int64(len(patch)), &got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.String() != tt.newBin {
+				t.Errorf("patched binary differs: %d bytes, want %d", got.Len(), len(tt.newBin))
+			}
+		})
+	}
+
+	patch := testPatch(t, base, base+"new")
+	for _, corrupt := range [][]byte{nil, []byte("BSDIFF40"), append([]byte("BSDIFF41"), patch[8:]...), patch[:len(patch)/2]} {
+		err := applyPatch(strings.NewReader(base), int64(len(base)), bytes.NewReader(corrupt), int64(len(corrupt)), &bytes.Buffer{})
+		if err == nil {
+			t.Errorf("corrupt patch of %d bytes applied", len(corrupt))
+		}
+	}
+}
+
+func TestAutoUpdatePatch(t *testing.T) {
+	oldBin, newBin := exe(strings.Repeat("old binary ", 1000)), exe(strings.Repeat("old binary ", 1000)+"new")
+	tests := []struct {
+		name string
+		// installed is the binary being updated
+		installed string
+		patch     []byte
+		patched   bool
+	}{
+		{"patched", oldBin, testPatch(t, oldBin, newBin), true},
+		{"modified binary", oldBin + "modified", testPatch(t, oldBin, newBin), false},
+		{"corrupt patch", oldBin, []byte("BSDIFF40 corrupt"), false},
+		{"no patch", oldBin, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTestBucket(t)
+			b.publish("v1.0.0", []byte(oldBin))
+			b.publish("v1.1.0", []byte(newBin))
+			if tt.patch != nil {
+				b.put("patches/v1.0.0_v1.1.0.bsdiff", tt.patch)
+			}
+			target := newTarget(t, tt.installed)
+			u := b.updater(target)
+			u.PatchKey = "patches/{{FROM}}_{{TO}}.bsdiff"
+
+			res, err := AutoUpdateResult(u)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Patched != tt.patched {
+				t.Errorf("patched %v, want %v", res.Patched, tt.patched)
+			}
+			assertContents(t, target, newBin)
+			assertAlone(t, target)
+			requested, full := false, false
+			for _, r := range b.requested() {
+				requested = requested || r == "GET patches/v1.0.0_v1.1.0.bsdiff"
+				full = full || r == "GET mytool-v1.1.0"
+			}
+			// no patch is requested for a binary it doesn't apply to
+			if want := tt.installed == oldBin; requested != want {
+				t.Errorf("patch requested %v, want %v: %v", requested, want, b.requested())
+			}
+			if full == tt.patched {
+				t.Errorf("full release downloaded %v: %v", full, b.requested())
+			}
+		})
+	}
+}
+
+func TestAutoUpdatePatchManifestBase(t *testing.T) {
+	oldBin, newBin := exe(strings.Repeat("old binary ", 1000)), exe(strings.Repeat("old binary ", 1000)+"new")
+	for _, tt := range []struct {
+		base    string
+		patched bool
+	}{{sha256Hex(oldBin), true}, {sha256Hex("another binary"), false}} {
+		b := newTestBucket(t)
+		b.put("mytool-v1.1.0", []byte(newBin))
+		b.put("patches/v1.0.0_v1.1.0.bsdiff", testPatch(t, oldBin, newBin))
+		b.put("VERSION", []byte(`{"version": "v1.1.0", "artifacts": {"`+runtime.GOOS+"_"+runtime.GOARCH+`": {"url": "mytool-{{VERSION}}", "sha256": "`+
+			sha256Hex(newBin)+`", "bases": {"v1.0.0": "`+tt.base+`"}}}}`))
+		target := newTarget(t, oldBin)
+		u := b.updater(target)
+		u.ManifestMode, u.ChecksumKey, u.PatchKey = true, "", "patches/{{FROM}}_{{TO}}.bsdiff"
+
+		res, err := AutoUpdateResult(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Patched != tt.patched {
+			t.Errorf("base %s: patched %v, want %v", tt.base, res.Patched, tt.patched)
+		}
+		assertContents(t, target, newBin)
+	}
+}
@@ -0,0 +1,68 @@
+package s3update
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// updatesDisabled reports whether S3UPDATE_DISABLED is set to a true value, e.g. 1, true or yes.
+// Values that aren't booleans disable updates as well.
+func updatesDisabled() bool {
+	v := strings.TrimSpace(os.Getenv("S3UPDATE_DISABLED"))
+	if v == "" {
+		return false
+	}
+	switch strings.ToLower(v) {
+	case "yes", "y", "on":
+		return true
+	case "no", "n", "off":
+		return false
+	}
+	if disabled, err := strconv.ParseBool(v); err == nil {
+		return disabled
+	}
+	return true
+}
+
+// envOverrides maps the environment variables overriding fields of the Updater to these fields
+var envOverrides = []struct {
+	env, field string
+	set        func(u *Updater, value string)
+}{
+	{"S3UPDATE_BUCKET", "S3Bucket", func(u *Updater, v string) { u.S3Bucket = v }},
+	{"S3UPDATE_RELEASE_KEY", "S3ReleaseKey", func(u *Updater, v string) { u.S3ReleaseKey = v }},
+	{"S3UPDATE_VERSION_KEY", "S3VersionKey", func(u *Updater, v string) { u.S3VersionKey = v }},
+	{"S3UPDATE_CHECKSUM_KEY", "ChecksumKey", func(u *Updater, v string) { u.ChecksumKey = v }},
+	{"S3UPDATE_ENDPOINT", "Endpoint", func(u *Updater, v string) { u.Endpoint = v }},
+}
+
+// withEnv returns u with the fields set by S3UPDATE_* environment variables overridden, unless DisableEnvOverrides
+// is set. S3UPDATE_VERBOSE takes a boolean, e.g. 1 or false.
+func (u Updater) withEnv() Updater {
+	// every entry point goes through here, starting afresh what the requests of a run share
+	if len(u.Mirrors) > 0 {
+		u.mirror = new(mirrorState)
+	}
+	if u.MaxBytesPerSecond > 0 {
+		u.limiter = newRateLimiter(u.MaxBytesPerSecond)
+	}
+	if u.DisableEnvOverrides {
+		return u
+	}
+	if v := os.Getenv("S3UPDATE_VERBOSE"); v != "" {
+		if verbose, err := strconv.ParseBool(v); err == nil {
+			u.Verbose = verbose
+			u.logger().Debugf("updater: Verbose set by S3UPDATE_VERBOSE")
+		} else {
+			u.logger().Debugf("updater: ignoring S3UPDATE_VERBOSE=%s: %s", v, err)
+		}
+	}
+	for _, o := range envOverrides {
+		if v := os.Getenv(o.env); v != "" {
+			o.set(&u, v)
+			u.logger().Debugf("updater: %s set to %s by %s", o.field, v, o.env)
+		}
+	}
+	return u
+}
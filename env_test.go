@@ -0,0 +1,89 @@
+package s3update
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEnvOverrides(t *testing.T) {
+	tests := []struct {
+		env, value string
+		field      func(u Updater) string
+	}{
+		{"S3UPDATE_BUCKET", "staging-releases", func(u Updater) string { return u.S3Bucket }},
+		{"S3UPDATE_RELEASE_KEY", "staging/mytool-{{VERSION}}", func(u Updater) string { return u.S3ReleaseKey }},
+		{"S3UPDATE_VERSION_KEY", "staging/VERSION", func(u Updater) string { return u.S3VersionKey }},
+		{"S3UPDATE_CHECKSUM_KEY", "staging/mytool-{{VERSION}}.sha256", func(u Updater) string { return u.ChecksumKey }},
+		{"S3UPDATE_ENDPOINT", "https://minio.staging.example.com", func(u Updater) string { return u.Endpoint }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.env, func(t *testing.T) {
+			t.Setenv(tt.env, tt.value)
+			l := &recordingLogger{}
+			u := Updater{S3Bucket: "releases", S3ReleaseKey: "mytool", S3VersionKey: "VERSION", ChecksumKey: "mytool.md5",
+				Endpoint: "https://minio.example.com", Logger: l}
+			if got := tt.field(u.withEnv()); got != tt.value {
+				t.Errorf("got %s, want %s", got, tt.value)
+			}
+			if !strings.Contains(l.String(), "debug updater: ") || !strings.Contains(l.String(), "by "+tt.env) {
+				t.Errorf("override not logged: %q", l)
+			}
+
+			u.DisableEnvOverrides = true
+			if got := tt.field(u.withEnv()); got == tt.value {
+				t.Errorf("overridden despite DisableEnvOverrides")
+			}
+		})
+	}
+
+	// empty variables don't override anything
+	t.Setenv("S3UPDATE_BUCKET", "")
+	u := Updater{S3Bucket: "releases"}
+	if got := u.withEnv(); got.S3Bucket != "releases" {
+		t.Errorf("got %s", got.S3Bucket)
+	}
+}
+
+func TestEnvVerbose(t *testing.T) {
+	for _, tt := range []struct {
+		value   string
+		verbose bool
+		want    bool
+	}{
+		{"1", false, true},
+		{"true", false, true},
+		{"0", true, false},
+		{"false", true, false},
+		{"garbage", true, true},
+		{"garbage", false, false},
+	} {
+		t.Setenv("S3UPDATE_VERBOSE", tt.value)
+		u := Updater{Verbose: tt.verbose, Logger: NopLogger}
+		if got := u.withEnv().Verbose; got != tt.want {
+			t.Errorf("S3UPDATE_VERBOSE=%s over %v: got %v, want %v", tt.value, tt.verbose, got, tt.want)
+		}
+		u.DisableEnvOverrides = true
+		if got := u.withEnv().Verbose; got != tt.verbose {
+			t.Errorf("S3UPDATE_VERBOSE=%s applied despite DisableEnvOverrides", tt.value)
+		}
+	}
+}
+
+func TestAutoUpdateEnvOverrides(t *testing.T) {
+	b := newTestBucket(t)
+	b.publish("v1.1.0", []byte(exe("new binary")))
+	b.put("staging/VERSION", []byte("v1.2.0"))
+	b.put("staging/mytool-v1.2.0", []byte(exe("staging binary")))
+	b.put("staging/mytool-v1.2.0.sha256", []byte(sha256Hex(exe("staging binary"))))
+	t.Setenv("S3UPDATE_VERSION_KEY", "staging/VERSION")
+	t.Setenv("S3UPDATE_RELEASE_KEY", "staging/mytool-{{VERSION}}")
+	t.Setenv("S3UPDATE_CHECKSUM_KEY", "staging/mytool-{{VERSION}}.sha256")
+	target := newTarget(t, exe("old binary"))
+	u := b.updater(target)
+	u.DisableEnvOverrides = false
+
+	if err := AutoUpdate(u); err != nil {
+		t.Fatal(err)
+	}
+	assertContents(t, target, exe("staging binary"))
+}
@@ -0,0 +1,41 @@
+package s3update
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ephemeralExecutable tells whether the running executable is a test binary or one built by go run, replacing
+// which would be pointless
+func ephemeralExecutable() (string, bool) {
+	exe, err := executable()
+	if err != nil || !isEphemeral(exe) {
+		return "", false
+	}
+	return exe, true
+}
+
+// isEphemeral tells whether exe is named like a test binary or lives where go run builds
+func isEphemeral(exe string) bool {
+	name := strings.TrimSuffix(filepath.Base(exe), ".exe")
+	if strings.HasSuffix(name, ".test") {
+		return true
+	}
+	dirs := []string{filepath.Join(os.TempDir(), "go-build")}
+	if cache := os.Getenv("GOCACHE"); cache != "" {
+		dirs = append(dirs, cache)
+	} else if cache, err := os.UserCacheDir(); err == nil {
+		dirs = append(dirs, filepath.Join(cache, "go-build"))
+	}
+	if tmp := os.Getenv("GOTMPDIR"); tmp != "" {
+		dirs = append(dirs, filepath.Join(tmp, "go-build"))
+	}
+	for _, dir := range dirs {
+		// go run builds under go-build* directories of the temporary directory, or caches in GOCACHE
+		if strings.HasPrefix(exe, dir) {
+			return true
+		}
+	}
+	return false
+}
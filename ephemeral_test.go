@@ -0,0 +1,56 @@
+package s3update
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIsEphemeral(t *testing.T) {
+	cache := t.TempDir()
+	t.Setenv("GOCACHE", cache)
+	t.Setenv("GOTMPDIR", "")
+	tests := []struct {
+		exe  string
+		want bool
+	}{
+		{"/usr/local/bin/mytool", false},
+		{"/home/user/go/bin/mytool", false},
+		{"/home/user/src/mytool/mytool.test", true},
+		{`C:\Users\user\mytool.test.exe`, true},
+		{filepath.Join(os.TempDir(), "go-build1234567", "b001", "exe", "mytool"), true},
+		{filepath.Join(cache, "a1", "mytool"), true},
+		{filepath.Join(os.TempDir(), "mytool"), false},
+	}
+	for _, tt := range tests {
+		if got := isEphemeral(tt.exe); got != tt.want {
+			t.Errorf("isEphemeral(%s) = %v, want %v", tt.exe, got, tt.want)
+		}
+	}
+
+	tmp := t.TempDir()
+	t.Setenv("GOTMPDIR", tmp)
+	if exe := filepath.Join(tmp, "go-build42", "b001", "exe", "mytool"); !isEphemeral(exe) {
+		t.Errorf("%s built in GOTMPDIR isn't ephemeral", exe)
+	}
+}
+
+func TestAutoUpdateSkipsTestBinary(t *testing.T) {
+	b := newTestBucket(t)
+	b.publish("v1.1.0", []byte(exe("new binary")))
+	logger := &recordingLogger{}
+	u := b.updater("")
+	u.StateFile, u.Logger = filepath.Join(t.TempDir(), "s3update.json"), logger
+
+	res, err := AutoUpdateResult(u)
+	if err != nil || res.Updated {
+		t.Fatalf("got %+v, %v", res, err)
+	}
+	if requests := b.requested(); len(requests) > 0 {
+		t.Errorf("requested %v", requests)
+	}
+	if log := logger.String(); !strings.Contains(log, "built by go test or go run") {
+		t.Errorf("got\n%s", log)
+	}
+}
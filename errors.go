@@ -0,0 +1,299 @@
+package s3update
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrUpdated is returned by AutoUpdate when the binary got replaced and NoExit is set
+	ErrUpdated = errors.New("binary updated")
+	// ErrRestartRequired is returned once the binary got replaced and NoRestart is set or the process runs as PID 1
+	ErrRestartRequired = errors.New("binary updated, restart required")
+	// ErrUpdateInProgress is returned when another process is already updating the binary
+	ErrUpdateInProgress = errors.New("update in progress in another process")
+	// ErrNoBackup is returned by Rollback when there is no backup to restore
+	ErrNoBackup = errors.New("no backup to roll back to")
+	// ErrCheckSkipped is returned when the bucket couldn't be reached for the update check, e.g. when offline
+	ErrCheckSkipped = errors.New("update check skipped")
+	// ErrDisabled is returned by AutoUpdate when updates are disabled by the S3UPDATE_DISABLED environment variable
+	ErrDisabled = errors.New("updates disabled by S3UPDATE_DISABLED")
+	// ErrVersionTooOld is matched by every *VersionTooOldError
+	ErrVersionTooOld = errors.New("version no longer supported")
+	// ErrUpToDate is returned when asked to apply an update while already running the latest version
+	ErrUpToDate = errors.New("already up to date")
+	// ErrInvalidLocalVersion is returned when CurrentVersion isn't a valid version
+	ErrInvalidLocalVersion = errors.New("invalid local version")
+	// ErrInvalidRemoteVersion is returned when the published version isn't a valid version
+	ErrInvalidRemoteVersion = errors.New("remote version is invalid")
+	// ErrNoRemoteVersion is returned when the object at LatestKey has no version metadata and S3VersionKey isn't set
+	ErrNoRemoteVersion = errors.New("no remote version published")
+	// ErrChecksumMismatch is matched by every *ChecksumError
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+	// ErrMalformedChecksum is returned when the published checksum can't be parsed
+	ErrMalformedChecksum = errors.New("malformed checksum")
+	// ErrInvalidSignature is returned when the release signature doesn't verify against Updater.PublicKey
+	ErrInvalidSignature = errors.New("invalid release signature")
+	// ErrIncompatibleBinary is returned when the release isn't an executable for the running platform
+	ErrIncompatibleBinary = errors.New("release isn't built for this platform")
+	// ErrVerifyFailed is returned when the new binary fails its VerifyCommand smoke test
+	ErrVerifyFailed = errors.New("new binary failed verification")
+	// ErrPostInstallFailed is returned when PostInstallCommand fails on the new binary
+	ErrPostInstallFailed = errors.New("post-install command failed")
+	// ErrPermission is matched by every *PermissionError
+	ErrPermission = errors.New("permission denied")
+	// ErrInsufficientSpace is matched by every *SpaceError
+	ErrInsufficientSpace = errors.New("insufficient disk space")
+	// ErrResponseTooLarge is returned when a version, checksum, signature, manifest or yanked list is unexpectedly large
+	ErrResponseTooLarge = errors.New("response too large")
+	// ErrUpdateLoopDetected is returned when the binary just updated still isn't the remote version,
+	// which must then be fixed in the bucket
+	ErrUpdateLoopDetected = errors.New("update loop detected")
+	// ErrInvalidConfig is matched by every *ValidationError and *ConfigError
+	ErrInvalidConfig = errors.New("invalid configuration")
+	// ErrMissingField is returned when a required field of the Updater isn't set
+	ErrMissingField = errors.New("not set")
+	// ErrUnknownPlaceholder is returned when a key template holds a placeholder that isn't defined
+	ErrUnknownPlaceholder = errors.New("unknown placeholder")
+	// ErrMissingArtifact is matched by every *MissingArtifactError
+	ErrMissingArtifact = errors.New("release artifact not published")
+	// ErrUntrustedHost is matched by every *UntrustedHostError
+	ErrUntrustedHost = errors.New("untrusted host")
+	// ErrDownloadFailed is matched by every *DownloadError
+	ErrDownloadFailed = errors.New("download failed")
+)
+
+// PermissionError is returned before downloading anything when the binary can't be replaced,
+// because its directory isn't writable or the file belongs to someone else
+type PermissionError struct {
+	Path string
+	Err  error
+}
+
+func (e *PermissionError) Error() string {
+	return fmt.Sprintf("cannot write %s; re-run with sudo or set TargetPath: %v", e.Path, e.Err)
+}
+
+// Unwrap returns the underlying error, which matches os.ErrPermission
+func (e *PermissionError) Unwrap() error {
+	return e.Err
+}
+
+// Is makes errors.Is(err, ErrPermission) report true
+func (e *PermissionError) Is(target error) bool {
+	return target == ErrPermission
+}
+
+// SpaceError is returned before downloading when the filesystem receiving the update is too full
+type SpaceError struct {
+	Path      string
+	Required  uint64
+	Available uint64
+}
+
+func (e *SpaceError) Error() string {
+	return fmt.Sprintf("insufficient disk space in %s: %d bytes required, %d available", e.Path, e.Required, e.Available)
+}
+
+// Is makes errors.Is(err, ErrInsufficientSpace) report true
+func (e *SpaceError) Is(target error) bool {
+	return target == ErrInsufficientSpace
+}
+
+// MissingArtifactError is returned before downloading when the release or its checksum isn't published for the
+// running platform, e.g. when the version got bumped before every artifact was uploaded
+type MissingArtifactError struct {
+	Version  string
+	Platform string
+	// Key is where the artifact was looked for, its URL when outside of the bucket
+	Key string
+	// Checksum is set when the checksum is missing rather than the release
+	Checksum bool
+}
+
+func (e *MissingArtifactError) Error() string {
+	what := "artifact"
+	if e.Checksum {
+		what = "checksum"
+	}
+	return fmt.Sprintf("release %s has no %s for %s at %s", e.Version, what, e.Platform, e.Key)
+}
+
+// Is makes errors.Is(err, ErrMissingArtifact) report true
+func (e *MissingArtifactError) Is(target error) bool {
+	return target == ErrMissingArtifact
+}
+
+// UntrustedHostError is returned instead of requesting URL, or following a redirect to it, when it isn't served by
+// the bucket endpoint, BaseURL, a mirror nor one of Updater.AllowedHosts
+type UntrustedHostError struct {
+	URL string
+}
+
+func (e *UntrustedHostError) Error() string {
+	return fmt.Sprintf("untrusted host: %s, list it in AllowedHosts to download from it", redactURL(e.URL))
+}
+
+// Is makes errors.Is(err, ErrUntrustedHost) report true
+func (e *UntrustedHostError) Is(target error) bool {
+	return target == ErrUntrustedHost
+}
+
+// VersionTooOldError is returned by AutoUpdate when the current version is below the published minimum version
+// and couldn't be updated, Err telling why when known. Callers would usually exit with instructions to update.
+type VersionTooOldError struct {
+	Current    string
+	MinVersion string
+	Err        error
+}
+
+func (e *VersionTooOldError) Error() string {
+	msg := fmt.Sprintf("current version %s is below the minimum supported %s", e.Current, e.MinVersion)
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// Unwrap returns the reason why the update failed
+func (e *VersionTooOldError) Unwrap() error {
+	return e.Err
+}
+
+// Is makes errors.Is(err, ErrVersionTooOld) report true
+func (e *VersionTooOldError) Is(target error) bool {
+	return target == ErrVersionTooOld
+}
+
+// ChecksumError is returned when the downloaded release doesn't match its published checksum
+type ChecksumError struct {
+	Version   string
+	Algorithm string
+	Expected  string
+	Actual    string
+}
+
+func (e *ChecksumError) Error() string {
+	msg := fmt.Sprintf("%s checksum mismatch: expected %s, got %s", e.Algorithm, e.Expected, e.Actual)
+	if e.Version != "" {
+		msg = e.Version + " " + msg
+	}
+	return msg
+}
+
+// Is makes errors.Is(err, ErrChecksumMismatch) report true
+func (e *ChecksumError) Is(target error) bool {
+	return target == ErrChecksumMismatch
+}
+
+// DownloadError is returned when a remote object couldn't be fetched,
+// either because of a network failure (Err is set) or an unexpected HTTP status (StatusCode is set)
+type DownloadError struct {
+	URL        string
+	StatusCode int
+	// S3Code and S3Message come from the S3 error document, e.g. NoSuchKey and "The specified key does not exist."
+	S3Code    string
+	S3Message string
+	// Body is the beginning of an unexpected response that isn't an S3 error document
+	Body string
+	Err  error
+	// Hint suggests how to fix the failure, when known
+	Hint string
+}
+
+func (e *DownloadError) Error() string {
+	var msg string
+	switch {
+	case e.Err != nil:
+		msg = fmt.Sprintf("fetching %s: %v", e.URL, e.Err)
+	case e.S3Code != "":
+		msg = fmt.Sprintf("fetching %s: status %d: %s", e.URL, e.StatusCode, e.S3Code)
+		if e.S3Message != "" {
+			msg += " (" + e.S3Message + ")"
+		}
+	default:
+		msg = fmt.Sprintf("fetching %s: unexpected status %d", e.URL, e.StatusCode)
+		if e.Body != "" {
+			msg += ": " + e.Body
+		}
+	}
+	if e.Hint != "" {
+		msg += " (" + e.Hint + ")"
+	}
+	// presigned URLs and the URLs redirected to hold credentials
+	return redactURLs(msg)
+}
+
+// Unwrap returns the underlying network error, if any
+func (e *DownloadError) Unwrap() error {
+	return e.Err
+}
+
+// Is makes errors.Is(err, ErrDownloadFailed) report true
+func (e *DownloadError) Is(target error) bool {
+	return target == ErrDownloadFailed
+}
+
+// ConfigError reports an invalid field of the Updater
+type ConfigError struct {
+	Field string
+	Err   error
+}
+
+func (e *ConfigError) Error() string {
+	return e.Field + ": " + e.Err.Error()
+}
+
+// Unwrap returns the problem with the field, e.g. ErrMissingField
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// Is makes errors.Is(err, ErrInvalidConfig) report true
+func (e *ConfigError) Is(target error) bool {
+	return target == ErrInvalidConfig
+}
+
+// ValidationError is returned by Updater.Validate and lists every *ConfigError found
+type ValidationError struct {
+	Errors []error
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return "invalid configuration: " + strings.Join(msgs, "; ")
+}
+
+// Unwrap returns the errors found, which errors.Is and errors.As only walk from Go 1.20 on
+func (e *ValidationError) Unwrap() []error {
+	return e.Errors
+}
+
+// As makes errors.As(err, target) find the first of the errors found matching target, e.g. a *ConfigError,
+// with the Go versions that don't walk Unwrap() []error
+func (e *ValidationError) As(target interface{}) bool {
+	for _, err := range e.Errors {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Is makes errors.Is(err, ErrInvalidConfig) report true, as well as errors.Is(err, target) for every target
+// matched by one of the errors found
+func (e *ValidationError) Is(target error) bool {
+	if target == ErrInvalidConfig {
+		return true
+	}
+	for _, err := range e.Errors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,26 @@
+package s3update
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidationErrorAs(t *testing.T) {
+	err := Updater{}.Validate()
+	ve, ok := err.(*ValidationError)
+	if !ok || len(ve.Errors) < 2 {
+		t.Fatalf("got %v, want several errors", err)
+	}
+	// As works without errors.As walking Unwrap() []error
+	var ce *ConfigError
+	if !ve.As(&ce) || ce != ve.Errors[0] {
+		t.Errorf("got %v, want %v", ce, ve.Errors[0])
+	}
+	var de *DownloadError
+	if ve.As(&de) {
+		t.Error("found a *DownloadError")
+	}
+	if !errors.Is(err, ErrInvalidConfig) || !errors.Is(err, ErrMissingField) {
+		t.Errorf("%v doesn't match its errors", err)
+	}
+}
@@ -0,0 +1,69 @@
+package s3update
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+)
+
+// Supported values for Updater.OutputFormat.
+//
+// In OutputJSON mode no text is printed, and the progress bar isn't drawn. Newline-delimited JSON events are
+// written to Updater.Output instead:
+//
+//	{"event":"check","current":"v1.2.0","remote":"v1.3.0","available":true}
+//	{"event":"progress","downloaded":1048576,"total":5242880}
+//	{"event":"updated","version":"v1.3.0"}
+//	{"event":"error","message":"..."}
+//
+// check follows every version check, available telling whether an update is going to be installed.
+// progress is reported while downloading, at ProgressInterval, total being -1 when unknown.
+// updated follows the installation of a version, and error the failure of AutoUpdate.
+const (
+	OutputText = "text"
+	OutputJSON = "json"
+)
+
+type checkEvent struct {
+	Event     string `json:"event"`
+	Current   string `json:"current"`
+	Remote    string `json:"remote"`
+	Available bool   `json:"available"`
+}
+
+type progressEvent struct {
+	Event      string `json:"event"`
+	Downloaded int64  `json:"downloaded"`
+	Total      int64  `json:"total"`
+}
+
+type updatedEvent struct {
+	Event   string `json:"event"`
+	Version string `json:"version"`
+}
+
+type errorEvent struct {
+	Event   string `json:"event"`
+	Message string `json:"message"`
+}
+
+// jsonOutput reports whether events are emitted rather than text
+func (u Updater) jsonOutput() bool {
+	return u.OutputFormat == OutputJSON
+}
+
+// emit writes the event e as a line of JSON in OutputJSON mode
+func (u Updater) emit(e interface{}) {
+	if !u.jsonOutput() {
+		return
+	}
+	var w io.Writer = os.Stderr
+	if u.Output != nil {
+		w = u.Output
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	w.Write(append(b, '\n'))
+}
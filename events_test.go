@@ -0,0 +1,71 @@
+package s3update
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files of testdata")
+
+// assertGolden compares got with testdata/name, which -update rewrites
+func assertGolden(t *testing.T, name, got string) {
+	t.Helper()
+	golden := filepath.Join("testdata", name)
+	if *update {
+		if err := ioutil.WriteFile(golden, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Errorf("%s differs, run go test -update to accept the changes:\ngot:\n%s\nwant:\n%s", golden, got, want)
+	}
+}
+
+func TestJSONOutput(t *testing.T) {
+	b := newTestBucket(t)
+	b.publish("v1.1.0", []byte(exe("new binary")))
+	target := newTarget(t, exe("old binary"))
+	var out bytes.Buffer
+	u := b.updater(target)
+	u.OutputFormat, u.Output, u.Logger, u.DisableProgress = OutputJSON, &out, nil, false
+	// a single progress event is reported before the last one
+	u.ProgressInterval = time.Hour
+
+	var err error
+	stdout, stderr := captureOutput(t, func() {
+		if err = AutoUpdate(u); err != nil {
+			return
+		}
+		// up to date
+		u.CurrentVersion = "v1.1.0"
+		if err = AutoUpdate(u); err != nil {
+			return
+		}
+		b.publish("v1.2.0", []byte(exe("newer binary")))
+		b.put("mytool-v1.2.0.sha256", []byte(sha256Hex("another binary")))
+		AutoUpdate(u)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stdout != "" || stderr != "" {
+		t.Errorf("text printed in JSON mode: %q, %q", stdout, stderr)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var event map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &event); err != nil || event["event"] == nil {
+			t.Errorf("not an event: %s", line)
+		}
+	}
+	assertGolden(t, "events.golden", strings.ReplaceAll(out.String(), b.URL, "http://bucket"))
+}
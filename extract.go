@@ -0,0 +1,303 @@
+package s3update
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	zipMagic  = []byte("PK\x03\x04")
+)
+
+// extractArchive replaces filename with the executable it contains when it holds an archive, reporting whether
+// it did so. The format is told by the content, as URLs may lose their suffix on the way, so that a release that
+// is neither an archive nor an executable for this platform is rejected, unless SkipBinaryValidation installs it as
+// is. The permissions recorded by the archive are returned, zero when unknown.
+func extractArchive(u Updater, downloadURL, filename string) (os.FileMode, bool, error) {
+	header, err := readHeader(filename)
+	if err != nil {
+		return 0, false, err
+	}
+	sel := &entrySelector{want: u.binaryName(), explicit: u.BinaryName != ""}
+	switch {
+	case bytes.HasPrefix(header, gzipMagic):
+		mode, err := decompressFile(filename, gunzip, sel)
+		return mode, true, err
+	case bytes.HasPrefix(header, zstdMagic):
+		mode, err := decompressFile(filename, unzstd, sel)
+		return mode, true, err
+	case bytes.HasPrefix(header, zipMagic):
+		mode, err := unzipFile(filename, sel)
+		return mode, true, err
+	case isExecutable(header):
+		return 0, false, nil
+	}
+	if ext := path.Ext(downloadURL); isArchiveExt(ext) {
+		return 0, false, fmt.Errorf("release has a %s suffix but isn't a %s archive", ext, ext[1:])
+	}
+	if u.SkipBinaryValidation {
+		return 0, false, nil
+	}
+	if _, _, err := binaryArchs(filename); err == nil {
+		// an executable for another platform
+		return 0, false, validateBinary(filename)
+	}
+	return 0, false, fmt.Errorf("release is neither a known archive nor an executable for %s", runtime.GOOS)
+}
+
+// binaryName returns the name of the executable to look for within archives
+func (u Updater) binaryName() string {
+	if u.BinaryName != "" {
+		return u.BinaryName
+	}
+	if u.TargetPath != "" {
+		return filepath.Base(u.TargetPath)
+	}
+	exe, err := executable()
+	if err != nil {
+		return ""
+	}
+	return filepath.Base(exe)
+}
+
+// entrySelector picks the executable among the entries of an archive.
+// Unless the name was set explicitly, an archive holding a single file is accepted whatever its name.
+type entrySelector struct {
+	want     string
+	explicit bool
+	seen     []string
+}
+
+// matches records name and reports whether it designates the executable
+func (s *entrySelector) matches(name string) bool {
+	s.seen = append(s.seen, name)
+	// archives created on windows may use backslashes as separators
+	base := path.Base(strings.Replace(name, "\\", "/", -1))
+	return base == s.want || base == s.want+exeSuffix
+}
+
+func (s *entrySelector) notFound() error {
+	if len(s.seen) == 0 {
+		return fmt.Errorf("archive contains no regular file named %q, it's empty", s.want)
+	}
+	return fmt.Errorf("archive contains no regular file named %q, found: %s", s.want, strings.Join(s.seen, ", "))
+}
+
+// checkEntryName rejects the entries whose cleaned name is absolute or leads out of the directory the archive would
+// be extracted to: the executable is never written there, but such an archive is not to be trusted
+func checkEntryName(name string) error {
+	clean := path.Clean(strings.Replace(name, "\\", "/", -1))
+	// a drive letter makes a windows path absolute
+	if path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") || (len(clean) > 1 && clean[1] == ':') {
+		return fmt.Errorf("archive entry %q escapes the extraction directory", name)
+	}
+	return nil
+}
+
+// readHeader returns the first bytes of filename, enough to tell its format
+func readHeader(filename string) ([]byte, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	buf := make([]byte, 64)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// isArchiveExt reports whether ext is the suffix of an archive format supported by extractArchive
+func isArchiveExt(ext string) bool {
+	switch ext {
+	case ".tgz", ".gz", ".zst", ".tzst", ".zip":
+		return true
+	}
+	return false
+}
+
+func gunzip(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+func unzstd(r io.Reader) (io.ReadCloser, error) {
+	d, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return d.IOReadCloser(), nil
+}
+
+// decompressFile replaces filename, compressed as undone by decompress, with its contents: the entry picked by sel
+// for a tarball, the whole stream otherwise. The permissions recorded by the tarball are returned, zero when unknown.
+// Contents are streamed to disk so the binary is never held in memory.
+func decompressFile(filename string, decompress func(io.Reader) (io.ReadCloser, error), sel *entrySelector) (os.FileMode, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	r, err := decompress(f)
+	if err != nil {
+		return 0, err
+	}
+	br := bufio.NewReaderSize(r, tarHeaderSize)
+	var found string
+	var mode os.FileMode
+	if header, _ := br.Peek(tarHeaderSize); isTar(header) {
+		found, mode, err = untar(filename, br, sel)
+	} else {
+		found, err = writeTemp(filename, br)
+	}
+	r.Close()
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(found)
+	// the archive must be closed before being replaced on windows
+	f.Close()
+	return mode, os.Rename(found, filename)
+}
+
+// tarHeaderSize is the size of a tar header block
+const tarHeaderSize = 512
+
+// isTar reports whether header is the first block of a ustar or GNU tarball
+func isTar(header []byte) bool {
+	return len(header) >= 262 && bytes.Equal(header[257:262], []byte("ustar"))
+}
+
+// untar streams the entry of the tarball r picked by sel to a temporary file next to filename,
+// and returns its path and permissions
+func untar(filename string, r io.Reader, sel *entrySelector) (string, os.FileMode, error) {
+	tr := tar.NewReader(r)
+
+	var found, candidate string
+	var mode, candidateMode os.FileMode
+	regular := 0
+	defer func() {
+		if candidate != "" {
+			os.Remove(candidate)
+		}
+	}()
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", 0, err
+		}
+		if err := checkEntryName(header.Name); err != nil {
+			return "", 0, err
+		}
+		// directories, links, special files and global pax headers are never the executable
+		if header.Typeflag != tar.TypeReg {
+			sel.seen = append(sel.seen, header.Name)
+			continue
+		}
+		regular++
+		if sel.matches(header.Name) {
+			if found, err = writeTemp(filename, tr); err != nil {
+				return "", 0, err
+			}
+			mode = header.FileInfo().Mode().Perm()
+			break
+		}
+		// keep the first file around in case it turns out to be the only one
+		if regular == 1 && !sel.explicit {
+			if candidate, err = writeTemp(filename, tr); err != nil {
+				return "", 0, err
+			}
+			candidateMode = header.FileInfo().Mode().Perm()
+		}
+	}
+	if found == "" && regular == 1 && candidate != "" {
+		found, candidate, mode = candidate, "", candidateMode
+	}
+	if found == "" {
+		return "", 0, sel.notFound()
+	}
+	return found, mode, nil
+}
+
+// writeTemp streams r into a new temporary file next to filename and returns its path
+func writeTemp(filename string, r io.Reader) (string, error) {
+	w, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+	defer w.Close()
+	if _, err := io.Copy(w, r); err != nil {
+		os.Remove(w.Name())
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		os.Remove(w.Name())
+		return "", err
+	}
+	return w.Name(), nil
+}
+
+// unzipFile replaces filename, a zip archive, with the entry picked by sel
+func unzipFile(filename string, sel *entrySelector) (os.FileMode, error) {
+	zr, err := zip.OpenReader(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer zr.Close()
+
+	var entry *zip.File
+	var regular []*zip.File
+	for _, f := range zr.File {
+		if err := checkEntryName(f.Name); err != nil {
+			return 0, err
+		}
+		if !f.Mode().IsRegular() {
+			sel.seen = append(sel.seen, f.Name)
+			continue
+		}
+		regular = append(regular, f)
+		if sel.matches(f.Name) {
+			entry = f
+			break
+		}
+	}
+	if entry == nil && len(regular) == 1 && !sel.explicit {
+		entry = regular[0]
+	}
+	if entry == nil {
+		return 0, sel.notFound()
+	}
+
+	rc, err := entry.Open()
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+	tmp, err := writeTemp(filename, rc)
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp)
+	rc.Close()
+	zr.Close()
+	return entry.Mode().Perm(), os.Rename(tmp, filename)
+}
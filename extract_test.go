@@ -0,0 +1,462 @@
+package s3update
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// testEntry is a file of the archives written by tests
+type testEntry struct {
+	name, body string
+	// mode defaults to 0755
+	mode os.FileMode
+}
+
+func (e testEntry) perm() os.FileMode {
+	if e.mode == 0 {
+		return 0755
+	}
+	return e.mode
+}
+
+// writeZip writes a zip archive of entries to filename
+func writeZip(t *testing.T, filename string, entries ...testEntry) {
+	t.Helper()
+	f, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for _, e := range entries {
+		h := &zip.FileHeader{Name: e.name, Method: zip.Deflate}
+		h.SetMode(e.perm())
+		if strings.HasSuffix(e.name, "/") {
+			h.SetMode(os.ModeDir | 0755)
+		}
+		w, err := zw.CreateHeader(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, e.body)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// tarball returns a tar archive of entries compressed by compress, e.g. gzip.NewWriter
+func tarball(t *testing.T, compress func(io.Writer) io.WriteCloser, entries ...testEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	cw := compress(&buf)
+	tw := tar.NewWriter(cw)
+	for _, e := range entries {
+		h := &tar.Header{Name: e.name, Mode: int64(e.perm()), Size: int64(len(e.body)), Typeflag: tar.TypeReg}
+		if strings.HasSuffix(e.name, "/") {
+			h.Typeflag, h.Mode = tar.TypeDir, 0755
+		}
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(tw, e.body)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := cw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func gzipWriter(w io.Writer) io.WriteCloser {
+	return gzip.NewWriter(w)
+}
+
+func zstdWriter(w io.Writer) io.WriteCloser {
+	zw, _ := zstd.NewWriter(w)
+	return zw
+}
+
+// noCompression writes a plain tar archive
+func noCompression(w io.Writer) io.WriteCloser {
+	return nopCloser{w}
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
+
+// extractTest extracts the archive at filename for the binary named want, returning the contents and mode found
+func extractTest(t *testing.T, filename, want string) (string, os.FileMode, error) {
+	t.Helper()
+	mode, extracted, err := extractArchive(Updater{BinaryName: want}, filename, filename)
+	if err != nil {
+		return "", 0, err
+	}
+	if !extracted {
+		t.Fatalf("%s not extracted", filepath.Base(filename))
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data), mode, nil
+}
+
+// zeros reads as an endless stream of zero bytes
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+func TestExtractLargeArchiveBoundedMemory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("writes a 256 MiB binary")
+	}
+	const size = 256 << 20
+	archive := filepath.Join(t.TempDir(), "mytool.tgz")
+	f, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw, _ := gzip.NewWriterLevel(f, gzip.BestSpeed)
+	tw := tar.NewWriter(zw)
+	if err := tw.WriteHeader(&tar.Header{Name: "mytool", Mode: 0755, Size: size, Typeflag: tar.TypeReg}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(tw, io.LimitReader(zeros{}, size)); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []io.Closer{tw, zw, f} {
+		if err := c.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	mode, extracted, err := extractArchive(Updater{BinaryName: "mytool"}, archive, archive)
+	runtime.ReadMemStats(&after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 8<<20 {
+		t.Errorf("extracting a %d MiB binary allocated %d MiB", size>>20, allocated>>20)
+	}
+	if !extracted || mode != 0755 {
+		t.Errorf("got mode %v, extracted %v", mode, extracted)
+	}
+	if fi, err := os.Stat(archive); err != nil || fi.Size() != size {
+		t.Errorf("extracted %v, %v", fi, err)
+	}
+}
+
+func TestExtractZip(t *testing.T) {
+	tests := []struct {
+		name    string
+		binary  string
+		entries []testEntry
+		want    string
+		mode    os.FileMode
+	}{
+		{"single file", "", []testEntry{{name: "mytool" + exeSuffix, body: "binary"}}, "binary", 0755},
+		{"single file named otherwise", "", []testEntry{{name: "mytool-linux-amd64", body: "binary"}}, "binary", 0755},
+		{"directory prefix", "mytool", []testEntry{
+			{name: "mytool_1.1.0/"},
+			{name: "mytool_1.1.0/README.md", body: "readme", mode: 0644},
+			{name: "mytool_1.1.0/mytool" + exeSuffix, body: "binary"},
+		}, "binary", 0755},
+		{"backslashes", "mytool", []testEntry{
+			{name: "dist\\LICENSE", body: "license", mode: 0644},
+			{name: "dist\\mytool" + exeSuffix, body: "binary"},
+		}, "binary", 0755},
+		{"executable bit", "mytool", []testEntry{{name: "mytool" + exeSuffix, body: "binary", mode: 0750}}, "binary", 0750},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			archive := filepath.Join(t.TempDir(), "mytool.zip")
+			writeZip(t, archive, tt.entries...)
+			got, mode, err := extractTest(t, archive, tt.binary)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want || mode != tt.mode {
+				t.Errorf("got %q with mode %v, want %q with mode %v", got, mode, tt.want, tt.mode)
+			}
+		})
+	}
+}
+
+func TestExtractZipNotFound(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "mytool.zip")
+	writeZip(t, archive, testEntry{name: "README.md", body: "readme"}, testEntry{name: "other", body: "binary"})
+	_, _, err := extractTest(t, archive, "mytool")
+	if err == nil || !strings.Contains(err.Error(), `no regular file named "mytool"`) {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestAutoUpdateZip(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "mytool.zip")
+	writeZip(t, archive, testEntry{name: "mytool/README.md", body: "readme"},
+		testEntry{name: "mytool/mytool" + exeSuffix, body: exe("new binary")})
+	data, err := ioutil.ReadFile(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := newTestBucket(t)
+	b.publish("v1.1.0", data)
+	target := newTarget(t, exe("old binary"))
+	u := b.updater(target)
+	u.BinaryName = "mytool"
+
+	res, err := AutoUpdateResult(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Extracted {
+		t.Error("release not reported as extracted")
+	}
+	assertContents(t, target, exe("new binary"))
+	assertAlone(t, target)
+}
+
+func TestExtractTarMode(t *testing.T) {
+	for _, mode := range []os.FileMode{0755, 0750, 0700} {
+		archive := filepath.Join(t.TempDir(), "mytool.tgz")
+		data := tarball(t, gzipWriter, testEntry{name: "README.md", body: "readme", mode: 0644},
+			testEntry{name: "mytool", body: "binary", mode: mode})
+		if err := ioutil.WriteFile(archive, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		got, gotMode, err := extractTest(t, archive, "mytool")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != "binary" || gotMode != mode {
+			t.Errorf("got %q with mode %v, want mode %v", got, gotMode, mode)
+		}
+	}
+}
+
+func TestExtractFormats(t *testing.T) {
+	entries := []testEntry{
+		{name: "mytool_1.1.0/"},
+		{name: "mytool_1.1.0/README.md", body: "readme", mode: 0644},
+		{name: "mytool_1.1.0/mytool", body: "binary", mode: 0750},
+	}
+	archive := func(format string) func(t *testing.T) []byte {
+		return func(t *testing.T) []byte {
+			switch format {
+			case "zip":
+				filename := filepath.Join(t.TempDir(), "fixture.zip")
+				writeZip(t, filename, entries...)
+				data, err := ioutil.ReadFile(filename)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return data
+			case "zstd":
+				return tarball(t, zstdWriter, entries...)
+			}
+			return tarball(t, gzipWriter, entries...)
+		}
+	}
+	tests := []struct {
+		name    string
+		archive func(t *testing.T) []byte
+	}{
+		{"mytool.tgz", archive("gzip")},
+		{"mytool.tar.gz", archive("gzip")},
+		{"mytool.zip", archive("zip")},
+		{"mytool.tar.zst", archive("zstd")},
+		{"mytool.tzst", archive("zstd")},
+		// told by the magic number
+		{"mytool-gzip", archive("gzip")},
+		{"mytool-zip", archive("zip")},
+		{"mytool-zstd", archive("zstd")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), tt.name)
+			if err := ioutil.WriteFile(filename, tt.archive(t), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, mode, err := extractTest(t, filename, "mytool")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != "binary" || (runtime.GOOS != "windows" && mode != 0750) {
+				t.Errorf("got %q with mode %v", got, mode)
+			}
+		})
+	}
+}
+
+func TestExtractSuffixMismatch(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "mytool.tar.zst")
+	if err := ioutil.WriteFile(filename, tarball(t, noCompression, testEntry{name: "mytool", body: "binary"}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, _, err := extractArchive(Updater{BinaryName: "mytool"}, filename, filename)
+	if err == nil || !strings.Contains(err.Error(), ".zst suffix") {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestAutoUpdateZstd(t *testing.T) {
+	archive := tarball(t, zstdWriter, testEntry{name: "mytool" + exeSuffix, body: exe("new binary")})
+	b := newTestBucket(t)
+	b.put("VERSION", []byte("v1.1.0"))
+	b.put("mytool-v1.1.0.tar.zst", archive)
+	b.put("mytool-v1.1.0.tar.zst.sha256", []byte(sha256Hex(string(archive))))
+	target := newTarget(t, exe("old binary"))
+	u := b.updater(target)
+	u.S3ReleaseKey, u.ChecksumKey = "mytool-{{VERSION}}.tar.zst", "mytool-{{VERSION}}.tar.zst.sha256"
+
+	res, err := AutoUpdateResult(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Extracted {
+		t.Error("release not reported as extracted")
+	}
+	assertContents(t, target, exe("new binary"))
+	assertAlone(t, target)
+}
+
+// craftedTar writes the tar entries of headers, each followed by its body when a regular file, truncating the
+// archive to its first size bytes when size is positive
+func craftedTar(t *testing.T, size int, headers ...*tar.Header) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, h := range headers {
+		if h.Typeflag == tar.TypeReg && h.Size == 0 {
+			h.Size = int64(len("binary"))
+		}
+		if h.Mode == 0 && h.Typeflag != tar.TypeXGlobalHeader {
+			h.Mode = 0755
+		}
+		if err := tw.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		if h.Typeflag == tar.TypeReg {
+			io.WriteString(tw, "binary")
+		}
+	}
+	// the error of an entry larger than its body is ignored, as crafting one is the point
+	tw.Flush()
+	data := buf.Bytes()
+	if size > 0 && size < len(data) {
+		data = data[:size]
+	}
+	return data
+}
+
+func TestExtractTarEntries(t *testing.T) {
+	reg := func(name string) *tar.Header { return &tar.Header{Name: name, Typeflag: tar.TypeReg} }
+	tests := []struct {
+		name    string
+		archive func(t *testing.T) []byte
+		mode    os.FileMode
+		err     string
+	}{
+		{"skips what isn't a regular file", func(t *testing.T) []byte {
+			return craftedTar(t, 0,
+				&tar.Header{Typeflag: tar.TypeXGlobalHeader, PAXRecords: map[string]string{"comment": "release"}},
+				&tar.Header{Name: "mytool/", Typeflag: tar.TypeDir},
+				&tar.Header{Name: "mytool/bin/mytool", Typeflag: tar.TypeSymlink, Linkname: "../mytool"},
+				&tar.Header{Name: "mytool/mytool.link", Typeflag: tar.TypeLink, Linkname: "mytool/mytool"},
+				reg("mytool/mytool"))
+		}, 0755, ""},
+		{"symlink only", func(t *testing.T) []byte {
+			return craftedTar(t, 0, reg("README.md"), reg("LICENSE"),
+				&tar.Header{Name: "mytool", Typeflag: tar.TypeSymlink, Linkname: "/usr/bin/sh"})
+		}, 0, `archive contains no regular file named "mytool", found: README.md, LICENSE, mytool`},
+		{"hardlink only", func(t *testing.T) []byte {
+			return craftedTar(t, 0, reg("README.md"), reg("LICENSE"), &tar.Header{Name: "mytool", Typeflag: tar.TypeLink, Linkname: "README.md"})
+		}, 0, `archive contains no regular file named "mytool"`},
+		{"empty", func(t *testing.T) []byte {
+			return craftedTar(t, 0, &tar.Header{Name: "mytool/", Typeflag: tar.TypeDir})
+		}, 0, `archive contains no regular file named "mytool", found: mytool/`},
+		{"permission bits only", func(t *testing.T) []byte {
+			return craftedTar(t, 0, &tar.Header{Name: "mytool", Typeflag: tar.TypeReg, Mode: 04750})
+		}, 0750, ""},
+		{"cleaned name inside", func(t *testing.T) []byte {
+			return craftedTar(t, 0, reg("./bin/../mytool"))
+		}, 0755, ""},
+		{"parent directory", func(t *testing.T) []byte {
+			return craftedTar(t, 0, reg("../mytool"))
+		}, 0, `archive entry "../mytool" escapes the extraction directory`},
+		{"parent directory after cleaning", func(t *testing.T) []byte {
+			return craftedTar(t, 0, reg("bin/../../etc/mytool"))
+		}, 0, "escapes the extraction directory"},
+		{"skipped entry escaping", func(t *testing.T) []byte {
+			return craftedTar(t, 0, &tar.Header{Name: "../../link", Typeflag: tar.TypeSymlink, Linkname: "/etc"}, reg("mytool"))
+		}, 0, "escapes the extraction directory"},
+		{"absolute", func(t *testing.T) []byte {
+			return craftedTar(t, 0, reg("/usr/local/bin/mytool"))
+		}, 0, "escapes the extraction directory"},
+		{"windows parent directory", func(t *testing.T) []byte {
+			return craftedTar(t, 0, reg(`bin\..\..\mytool.exe`))
+		}, 0, "escapes the extraction directory"},
+		{"windows drive", func(t *testing.T) []byte {
+			return craftedTar(t, 0, reg(`C:\mytool.exe`))
+		}, 0, "escapes the extraction directory"},
+		{"entry larger than the archive", func(t *testing.T) []byte {
+			data := craftedTar(t, 0, &tar.Header{Name: "mytool", Typeflag: tar.TypeReg, Size: 1 << 40})
+			return data[:tarHeaderSize+1024]
+		}, 0, "unexpected EOF"},
+		{"truncated header", func(t *testing.T) []byte {
+			return craftedTar(t, 2*tarHeaderSize+256, reg("README.md"), reg("mytool"))
+		}, 0, "unexpected EOF"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			gw := gzip.NewWriter(&buf)
+			gw.Write(tt.archive(t))
+			gw.Close()
+			dir := t.TempDir()
+			archive := filepath.Join(dir, "mytool.tgz")
+			if err := ioutil.WriteFile(archive, buf.Bytes(), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			got, mode, err := extractTest(t, archive, "mytool")
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got %v, want %q", err, tt.err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			} else if got != "binary" || mode != tt.mode {
+				t.Errorf("got %q with mode %v, want mode %v", got, mode, tt.mode)
+			}
+			if entries, _ := ioutil.ReadDir(dir); len(entries) != 1 {
+				t.Errorf("%d files left in %s", len(entries), dir)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package s3update
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+)
+
+// defaultGCSEndpoint serves the objects of every Google Cloud Storage bucket as <endpoint>/<bucket>/<key>
+const defaultGCSEndpoint = "https://storage.googleapis.com"
+
+// GCSSource reads releases from a Google Cloud Storage bucket laid out like an S3 one: the keys are templates
+// with the placeholders of Updater.S3VersionKey, S3ReleaseKey and ChecksumKey.
+type GCSSource struct {
+	Bucket      string
+	VersionKey  string
+	ReleaseKey  string
+	ChecksumKey string
+	Channel     string
+	OSMap       map[string]string
+	ArchMap     map[string]string
+	// TemplateVars defines extra placeholders, as Updater.TemplateVars does
+	TemplateVars map[string]string
+	// UseADC authenticates requests with the Application Default Credentials, as private buckets require:
+	// GOOGLE_APPLICATION_CREDENTIALS, the gcloud credentials file, then the service account of the instance
+	UseADC bool
+	// Endpoint is https://storage.googleapis.com when empty
+	Endpoint string
+	// HTTPClient performs every request, that of the Updater reading the source is used when nil
+	HTTPClient *http.Client
+
+	// outer is the Updater reading the source
+	outer Updater
+}
+
+func (s GCSSource) bind(u Updater) Source {
+	s.outer = u
+	return s
+}
+
+// LatestVersion reads the version object at VersionKey
+func (s GCSSource) LatestVersion(ctx context.Context) (string, error) {
+	version, err := s.s3().LatestVersion(ctx)
+	return version, s.hint(err)
+}
+
+// Artifact downloads the object at ReleaseKey
+func (s GCSSource) Artifact(ctx context.Context, version string) (io.ReadCloser, int64, error) {
+	body, size, err := s.s3().Artifact(ctx, version)
+	return body, size, s.hint(err)
+}
+
+// Checksum reads the object at ChecksumKey, none being published when it's empty
+func (s GCSSource) Checksum(ctx context.Context, version string) (string, error) {
+	sum, err := s.s3().Checksum(ctx, version)
+	return sum, s.hint(err)
+}
+
+// ArtifactURL returns the URL of the object at ReleaseKey
+func (s GCSSource) ArtifactURL(version string) (string, error) {
+	return s.s3().ArtifactURL(version)
+}
+
+// s3 returns the S3 source reading the bucket through its XML API, which is compatible
+func (s GCSSource) s3() s3Source {
+	endpoint := s.Endpoint
+	if endpoint == "" {
+		endpoint = defaultGCSEndpoint
+	}
+	u := s.outer.inherit(Updater{
+		S3Bucket:     s.Bucket,
+		S3VersionKey: s.VersionKey,
+		S3ReleaseKey: s.ReleaseKey,
+		ChecksumKey:  s.ChecksumKey,
+		Channel:      s.Channel,
+		OSMap:        s.OSMap,
+		ArchMap:      s.ArchMap,
+		TemplateVars: s.TemplateVars,
+		Endpoint:     endpoint,
+		PathStyle:    true,
+		HTTPClient:   s.HTTPClient,
+		Logger:       NopLogger,
+	})
+	if s.UseADC {
+		// the token is requested through the same transport, e.g. a proxy
+		u.Signer = func(req *http.Request) error {
+			client, err := u.httpClient()
+			if err != nil {
+				return err
+			}
+			return authorizeGoogleRequest(req, client)
+		}
+	}
+	return s3Source{u: u}
+}
+
+// hint replaces the suggestion of AWS authentication made for private buckets
+func (s GCSSource) hint(err error) error {
+	var de *DownloadError
+	if errors.As(err, &de) && de.StatusCode == http.StatusForbidden && !s.UseADC {
+		de.Hint = "the bucket may be private, set UseADC to authenticate requests"
+	}
+	return err
+}
@@ -0,0 +1,125 @@
+package s3update
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testGCSSource returns the source of the releases published to b as the GCS bucket "releases"
+func testGCSSource(b *testBucket) GCSSource {
+	return GCSSource{
+		Bucket:      "releases",
+		VersionKey:  "VERSION",
+		ReleaseKey:  "mytool-{{VERSION}}",
+		ChecksumKey: "mytool-{{VERSION}}.sha256",
+		Endpoint:    b.URL,
+	}
+}
+
+func TestGCSSourceURLs(t *testing.T) {
+	tests := []struct{ endpoint, want string }{
+		{"", "https://storage.googleapis.com/releases/mytool/v1.1.0/mytool"},
+		{"http://127.0.0.1:4443", "http://127.0.0.1:4443/releases/mytool/v1.1.0/mytool"},
+	}
+	for _, tt := range tests {
+		s := GCSSource{Bucket: "releases", ReleaseKey: "mytool/{{VERSION}}/mytool", Endpoint: tt.endpoint}
+		got, err := s.ArtifactURL("v1.1.0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("got %s, want %s", got, tt.want)
+		}
+	}
+}
+
+func TestAutoUpdateGCS(t *testing.T) {
+	b := newTestBucket(t)
+	b.publishAt("releases/", "v1.1.0", []byte(exe("new binary")))
+	target := newTarget(t, exe("old binary"))
+
+	if err := AutoUpdate(b.sourceUpdater(target, testGCSSource(b))); err != nil {
+		t.Fatal(err)
+	}
+	assertContents(t, target, exe("new binary"))
+	for _, want := range []string{"GET releases/VERSION", "GET releases/mytool-v1.1.0.sha256", "GET releases/mytool-v1.1.0"} {
+		if !strings.Contains(strings.Join(b.requested(), ", "), want) {
+			t.Errorf("%s not requested: %v", want, b.requested())
+		}
+	}
+}
+
+func TestAutoUpdateGCSPrivate(t *testing.T) {
+	b := newTestBucket(t)
+	b.publishAt("releases/", "v1.1.0", []byte(exe("new binary")))
+	b.handle("token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("grant_type") != "refresh_token" || r.Form.Get("refresh_token") != "refresh" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "secret-token", "expires_in": 3600})
+	})
+	creds, _ := json.Marshal(map[string]string{
+		"type":          "authorized_user",
+		"client_id":     "id",
+		"client_secret": "secret",
+		"refresh_token": "refresh",
+		"token_uri":     b.URL + "/token",
+	})
+	filename := filepath.Join(t.TempDir(), "credentials.json")
+	if err := ioutil.WriteFile(filename, creds, 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", filename)
+	googleTokenCache.Lock()
+	googleTokenCache.token = googleToken{}
+	googleTokenCache.Unlock()
+	t.Cleanup(func() {
+		googleTokenCache.Lock()
+		googleTokenCache.token = googleToken{}
+		googleTokenCache.Unlock()
+	})
+	target := newTarget(t, exe("old binary"))
+	s := testGCSSource(b)
+	s.UseADC = true
+
+	if err := AutoUpdate(b.sourceUpdater(target, s)); err != nil {
+		t.Fatal(err)
+	}
+	assertContents(t, target, exe("new binary"))
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	tokens := 0
+	for _, r := range b.requests {
+		if r.URL.Path == "/token" {
+			tokens++
+			continue
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer secret-token" {
+			t.Errorf("%s %s authorized with %q", r.Method, r.URL.Path, got)
+		}
+	}
+	if tokens != 1 {
+		t.Errorf("%d tokens requested", tokens)
+	}
+}
+
+func TestGCSForbiddenHint(t *testing.T) {
+	b := newTestBucket(t)
+	b.handle("releases/VERSION", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	})
+	target := newTarget(t, exe("old binary"))
+
+	err := AutoUpdate(b.sourceUpdater(target, testGCSSource(b)))
+	var de *DownloadError
+	if !errors.As(err, &de) || de.StatusCode != http.StatusForbidden || !strings.Contains(de.Hint, "UseADC") {
+		t.Fatalf("got %v, want a 403 hinting at UseADC", err)
+	}
+}
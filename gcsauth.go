@@ -0,0 +1,232 @@
+package s3update
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// googleScope is the only permission the access token is requested for
+const googleScope = "https://www.googleapis.com/auth/devstorage.read_only"
+
+// defaultGoogleTokenURL issues access tokens for user credentials and service account keys
+const defaultGoogleTokenURL = "https://oauth2.googleapis.com/token"
+
+// errNoGoogleCredentials is returned when none of the credential sources yields anything
+var errNoGoogleCredentials = errors.New(
+	"no Google credentials found in GOOGLE_APPLICATION_CREDENTIALS, gcloud credentials or instance service account")
+
+// authorizeGoogleRequest adds the OAuth2 access token of the Application Default Credentials to req, exchanging
+// credentials for it with client
+func authorizeGoogleRequest(req *http.Request, client *http.Client) error {
+	token, err := loadGoogleToken(req.Context(), client)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	return nil
+}
+
+// googleToken is an OAuth2 access token
+type googleToken struct {
+	AccessToken string
+	Expires     time.Time
+}
+
+// googleTokenCache avoids requesting an access token for every request of an update
+var googleTokenCache struct {
+	sync.Mutex
+	token googleToken
+}
+
+// loadGoogleToken returns an access token for the Application Default Credentials, looked up like the Google
+// client libraries do: the file at GOOGLE_APPLICATION_CREDENTIALS, the file written by
+// `gcloud auth application-default login`, then the service account of the GCE instance or GKE workload.
+func loadGoogleToken(ctx context.Context, client *http.Client) (googleToken, error) {
+	googleTokenCache.Lock()
+	defer googleTokenCache.Unlock()
+	if t := googleTokenCache.token; t.AccessToken != "" && time.Until(t.Expires) > time.Minute {
+		return t, nil
+	}
+	t, err := fetchGoogleToken(ctx, client)
+	if err != nil {
+		return googleToken{}, err
+	}
+	googleTokenCache.token = t
+	return t, nil
+}
+
+func fetchGoogleToken(ctx context.Context, client *http.Client) (googleToken, error) {
+	filename := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	if filename == "" {
+		filename = gcloudCredentialsFile()
+	}
+	if b, err := ioutil.ReadFile(filename); err == nil {
+		return credentialsFileToken(ctx, client, filename, b)
+	} else if os.Getenv("GOOGLE_APPLICATION_CREDENTIALS") != "" {
+		return googleToken{}, err
+	}
+	if t, err := metadataToken(ctx); err == nil {
+		return t, nil
+	}
+	return googleToken{}, errNoGoogleCredentials
+}
+
+// gcloudCredentialsFile returns the location of the credentials written by gcloud
+func gcloudCredentialsFile() string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(os.Getenv("APPDATA"), "gcloud", "application_default_credentials.json")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", "gcloud", "application_default_credentials.json")
+}
+
+// credentialsFileToken exchanges the credentials of a service account key or of an authorized user for a token
+func credentialsFileToken(ctx context.Context, client *http.Client, filename string, b []byte) (googleToken, error) {
+	var creds struct {
+		Type         string `json:"type"`
+		ClientEmail  string `json:"client_email"`
+		PrivateKey   string `json:"private_key"`
+		TokenURI     string `json:"token_uri"`
+		ClientID     string `json:"client_id"`
+		ClientSecret string `json:"client_secret"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.Unmarshal(b, &creds); err != nil {
+		return googleToken{}, fmt.Errorf("decoding %s: %w", filename, err)
+	}
+	tokenURL := creds.TokenURI
+	if tokenURL == "" {
+		tokenURL = defaultGoogleTokenURL
+	}
+	switch creds.Type {
+	case "service_account":
+		assertion, err := signGoogleJWT(creds.ClientEmail, creds.PrivateKey, tokenURL, time.Now())
+		if err != nil {
+			return googleToken{}, fmt.Errorf("%s: %w", filename, err)
+		}
+		return exchangeGoogleToken(ctx, client, tokenURL, url.Values{
+			"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+			"assertion":  {assertion},
+		})
+	case "authorized_user":
+		return exchangeGoogleToken(ctx, client, tokenURL, url.Values{
+			"grant_type":    {"refresh_token"},
+			"client_id":     {creds.ClientID},
+			"client_secret": {creds.ClientSecret},
+			"refresh_token": {creds.RefreshToken},
+		})
+	}
+	return googleToken{}, fmt.Errorf("%s: unsupported credentials type %q", filename, creds.Type)
+}
+
+// signGoogleJWT returns the assertion a service account exchanges for an access token
+func signGoogleJWT(email, privateKey, audience string, now time.Time) (string, error) {
+	block, _ := pem.Decode([]byte(privateKey))
+	if block == nil {
+		return "", errors.New("no PEM private key")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		if key, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+			return "", fmt.Errorf("parsing private key: %w", err)
+		}
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return "", errors.New("private key isn't an RSA key")
+	}
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   email,
+		"scope": googleScope,
+		"aud":   audience,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + enc.EncodeToString(sig), nil
+}
+
+// exchangeGoogleToken requests an access token from the OAuth2 token endpoint
+func exchangeGoogleToken(ctx context.Context, client *http.Client, tokenURL string, form url.Values) (googleToken, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return googleToken{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := client.Do(req)
+	if err != nil {
+		return googleToken{}, err
+	}
+	defer resp.Body.Close()
+	return decodeGoogleToken(tokenURL, resp)
+}
+
+// metadataToken requests an access token for the service account of the instance from the metadata server
+func metadataToken(ctx context.Context) (googleToken, error) {
+	host := os.Getenv("GCE_METADATA_HOST")
+	if host == "" {
+		host = "169.254.169.254"
+	}
+	tokenURL := "http://" + host + "/computeMetadata/v1/instance/service-accounts/default/token?scopes=" +
+		url.QueryEscape(googleScope)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, tokenURL, nil)
+	if err != nil {
+		return googleToken{}, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := metadataClient.Do(req)
+	if err != nil {
+		return googleToken{}, err
+	}
+	defer resp.Body.Close()
+	return decodeGoogleToken(tokenURL, resp)
+}
+
+// decodeGoogleToken reads the token answered by tokenURL
+func decodeGoogleToken(tokenURL string, resp *http.Response) (googleToken, error) {
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return googleToken{}, fmt.Errorf("requesting a token from %s: unexpected status %d: %s",
+			tokenURL, resp.StatusCode, excerpt(string(body)))
+	}
+	var doc struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxManifestSize)).Decode(&doc); err != nil {
+		return googleToken{}, fmt.Errorf("decoding token from %s: %w", tokenURL, err)
+	}
+	if doc.AccessToken == "" {
+		return googleToken{}, fmt.Errorf("no access token in the answer of %s", tokenURL)
+	}
+	return googleToken{AccessToken: doc.AccessToken, Expires: time.Now().Add(time.Duration(doc.ExpiresIn) * time.Second)}, nil
+}
@@ -0,0 +1,276 @@
+package s3update
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultGitHubAPI is the root of the GitHub API, GitHubSource.APIURL overriding it for GitHub Enterprise
+const defaultGitHubAPI = "https://api.github.com"
+
+// maxReleaseSize caps the size of the description of a GitHub release
+const maxReleaseSize = 4 << 20
+
+// githubAssetHosts serve the release assets the API redirects downloads to
+var githubAssetHosts = []string{"objects.githubusercontent.com", "release-assets.githubusercontent.com"}
+
+// errRateLimited is returned when the GitHub API rate limit is exceeded
+var errRateLimited = errors.New("GitHub API rate limit exceeded")
+
+// GitHubSource fetches releases from GitHub Releases. The latest version is the tag of the release GitHub marks as
+// latest, drafts and pre-releases being left out, and the artifact is its asset named by AssetTemplate.
+type GitHubSource struct {
+	Owner string
+	Repo  string
+	// AssetTemplate names the asset of the running platform, e.g. mytool-{{OS}}-{{ARCH}}.tgz, with the
+	// placeholders of key templates which OSMap, ArchMap and TemplateVars apply to
+	AssetTemplate string
+	// ChecksumTemplate names the asset holding the checksum, e.g. checksums.txt as written by sha256sum, parsed
+	// with Updater.ChecksumAlgorithm. The SHA-256 digest GitHub records for the asset is used when empty.
+	ChecksumTemplate string
+	OSMap            map[string]string
+	ArchMap          map[string]string
+	TemplateVars     map[string]string
+	// Token authenticates the requests, as required by private repositories and to raise the API rate limit
+	Token string
+	// APIURL is the root of the API, https://api.github.com when empty. The host GitHub Enterprise redirects
+	// asset downloads to must be listed in Updater.AllowedHosts.
+	APIURL string
+	// HTTPClient performs every request, that of the Updater reading the source is used when nil
+	HTTPClient *http.Client
+
+	// outer is the Updater reading the source
+	outer Updater
+}
+
+func (s GitHubSource) bind(u Updater) Source {
+	s.outer = u
+	return s
+}
+
+// githubRelease is the part of a release returned by the GitHub API that is used
+type githubRelease struct {
+	TagName string        `json:"tag_name"`
+	Assets  []githubAsset `json:"assets"`
+}
+
+type githubAsset struct {
+	Name string `json:"name"`
+	// URL is the API endpoint of the asset, which redirects to the file itself
+	URL  string `json:"url"`
+	Size int64  `json:"size"`
+	// Digest is "sha256:<digest>", recorded for assets uploaded since mid-2025
+	Digest string `json:"digest"`
+}
+
+// LatestVersion returns the tag of the latest release. ErrCheckSkipped is returned when the API rate limit is hit.
+func (s GitHubSource) LatestVersion(ctx context.Context) (string, error) {
+	release, err := s.release(ctx, "latest")
+	if errors.Is(err, errRateLimited) {
+		return "", fmt.Errorf("%w: %v", ErrCheckSkipped, err)
+	}
+	if err != nil {
+		return "", err
+	}
+	return release.TagName, nil
+}
+
+// Artifact downloads the asset of the release tagged version
+func (s GitHubSource) Artifact(ctx context.Context, version string) (io.ReadCloser, int64, error) {
+	release, err := s.taggedRelease(ctx, version)
+	if err != nil {
+		return nil, 0, err
+	}
+	asset, err := s.asset(release, s.AssetTemplate)
+	if err != nil {
+		return nil, 0, err
+	}
+	resp, err := s.download(ctx, asset)
+	if err != nil {
+		return nil, 0, err
+	}
+	if resp.ContentLength < 0 {
+		return resp.Body, asset.Size, nil
+	}
+	return resp.Body, resp.ContentLength, nil
+}
+
+// Checksum returns the contents of the asset named by ChecksumTemplate, or the digest GitHub recorded for the
+// asset of the release tagged version
+func (s GitHubSource) Checksum(ctx context.Context, version string) (string, error) {
+	release, err := s.taggedRelease(ctx, version)
+	if err != nil {
+		return "", err
+	}
+	if s.ChecksumTemplate == "" {
+		asset, err := s.asset(release, s.AssetTemplate)
+		if err != nil {
+			return "", err
+		}
+		return asset.Digest, nil
+	}
+	asset, err := s.asset(release, s.ChecksumTemplate)
+	if err != nil {
+		return "", err
+	}
+	resp, err := s.download(ctx, asset)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := readBody(asset.URL, resp, maxChecksumSize)
+	return string(body), err
+}
+
+// ArtifactURL returns the URL the asset of version is downloaded from by browsers
+func (s GitHubSource) ArtifactURL(version string) (string, error) {
+	name, err := s.assetName(s.AssetTemplate, version)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("https://github.com/%s/%s/releases/download/%s/%s", s.Owner, s.Repo, version, name), nil
+}
+
+// taggedRelease returns the release tagged version, which may be written with or without its leading v
+func (s GitHubSource) taggedRelease(ctx context.Context, version string) (*githubRelease, error) {
+	release, err := s.release(ctx, "tags/"+url.PathEscape(version))
+	var de *DownloadError
+	if errors.As(err, &de) && de.StatusCode == http.StatusNotFound {
+		other := "v" + version
+		if strings.HasPrefix(version, "v") {
+			other = version[1:]
+		}
+		if r, err := s.release(ctx, "tags/"+url.PathEscape(other)); err == nil {
+			return r, nil
+		}
+	}
+	return release, err
+}
+
+// release fetches the description of a release, path being either "latest" or "tags/<tag>"
+func (s GitHubSource) release(ctx context.Context, path string) (*githubRelease, error) {
+	releaseURL := fmt.Sprintf("%s/repos/%s/%s/releases/%s", s.apiURL(), url.PathEscape(s.Owner), url.PathEscape(s.Repo), path)
+	resp, err := s.get(ctx, releaseURL, "application/vnd.github+json")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := readBody(releaseURL, resp, maxReleaseSize)
+	if err != nil {
+		return nil, err
+	}
+	var release githubRelease
+	if err := json.Unmarshal(body, &release); err != nil {
+		return nil, fmt.Errorf("decoding release %s: %w", releaseURL, err)
+	}
+	return &release, nil
+}
+
+// download fetches asset. The API answers with a redirect to a presigned URL, which the client follows without
+// the Authorization header since it's on another host.
+func (s GitHubSource) download(ctx context.Context, asset *githubAsset) (*http.Response, error) {
+	resp, err := s.api("application/octet-stream").httpDownload(ctx, asset.URL)
+	return s.checkResponse(asset.URL, resp, err)
+}
+
+// apiURL returns the root of the API
+func (s GitHubSource) apiURL() string {
+	if api := strings.TrimSuffix(s.APIURL, "/"); api != "" {
+		return api
+	}
+	return defaultGitHubAPI
+}
+
+// asset returns the asset of release named by tmpl
+func (s GitHubSource) asset(release *githubRelease, tmpl string) (*githubAsset, error) {
+	name, err := s.assetName(tmpl, release.TagName)
+	if err != nil {
+		return nil, err
+	}
+	for i := range release.Assets {
+		if release.Assets[i].Name == name {
+			return &release.Assets[i], nil
+		}
+	}
+	return nil, fmt.Errorf("release %s of %s/%s has no asset named %s", release.TagName, s.Owner, s.Repo, name)
+}
+
+func (s GitHubSource) assetName(tmpl, version string) (string, error) {
+	u := Updater{OSMap: s.OSMap, ArchMap: s.ArchMap, TemplateVars: s.TemplateVars}
+	return u.expandKey(tmpl, version, nil)
+}
+
+// get issues an API request, answered with a *DownloadError unless its status is 200
+func (s GitHubSource) get(ctx context.Context, rawURL, accept string) (*http.Response, error) {
+	resp, err := s.api(accept).httpGet(ctx, rawURL)
+	return s.checkResponse(rawURL, resp, err)
+}
+
+// api returns the Updater issuing the requests to the API, with the transport, trusted hosts, timeouts and retries
+// of the one reading the source
+func (s GitHubSource) api(accept string) Updater {
+	u := Updater{
+		BaseURL:    s.apiURL(),
+		HTTPClient: s.HTTPClient,
+		Logger:     NopLogger,
+		RequestHook: func(req *http.Request) {
+			req.Header.Set("Accept", accept)
+			req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+			req.Header.Set("User-Agent", "s3update")
+			if s.Token != "" {
+				req.Header.Set("Authorization", "Bearer "+s.Token)
+			}
+		},
+	}
+	if s.APIURL == "" {
+		u.AllowedHosts = githubAssetHosts
+	}
+	return s.outer.inherit(u)
+}
+
+// checkResponse returns resp when its status is 200, a *DownloadError otherwise
+func (s GitHubSource) checkResponse(rawURL string, resp *http.Response, err error) (*http.Response, error) {
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusOK {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	de := &DownloadError{URL: rawURL, StatusCode: resp.StatusCode}
+	if reset, limited := rateLimited(resp); limited {
+		de.Err = fmt.Errorf("%w until %s", errRateLimited, reset.Format(time.RFC3339))
+		if s.Token == "" {
+			de.Hint = "set Token to raise the limit"
+		}
+		return nil, de
+	}
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	de.Body = excerpt(string(body))
+	return nil, de
+}
+
+// rateLimited reports whether resp tells that the API rate limit got exceeded, and when requests may be issued again
+func rateLimited(resp *http.Response) (time.Time, bool) {
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+		return time.Time{}, false
+	}
+	// secondary rate limits tell how long to wait
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		return time.Now().Add(time.Duration(secs) * time.Second), true
+	}
+	if resp.Header.Get("X-RateLimit-Remaining") != "0" {
+		return time.Time{}, false
+	}
+	reset, _ := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64)
+	return time.Unix(reset, 0), true
+}
@@ -0,0 +1,84 @@
+package s3update
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGitHubSourceRateLimited(t *testing.T) {
+	reset := time.Now().Add(time.Hour).Truncate(time.Second)
+	tests := []struct {
+		name    string
+		status  int
+		headers map[string]string
+		token   string
+		// until is when the limit is reported to be lifted, the zero time when the response isn't a rate limit
+		until time.Time
+	}{
+		{"primary", http.StatusForbidden, map[string]string{
+			"X-RateLimit-Remaining": "0", "X-RateLimit-Reset": strconv.FormatInt(reset.Unix(), 10)}, "", reset},
+		{"primary with token", http.StatusTooManyRequests, map[string]string{
+			"X-RateLimit-Remaining": "0", "X-RateLimit-Reset": strconv.FormatInt(reset.Unix(), 10)}, "secret", reset},
+		{"secondary", http.StatusForbidden, map[string]string{"Retry-After": "3600"}, "", reset},
+		{"forbidden", http.StatusForbidden, map[string]string{"X-RateLimit-Remaining": "42"}, "", time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{"repos/automato-io/mytool/releases/latest", "repos/automato-io/mytool/releases/tags/v1.1.0"} {
+				b := newTestBucket(t)
+				publishGitHubRelease(b, b, []byte(exe("new binary")))
+				b.handle(key, func(w http.ResponseWriter, r *http.Request) {
+					for k, v := range tt.headers {
+						w.Header().Set(k, v)
+					}
+					w.WriteHeader(tt.status)
+					w.Write([]byte(`{"message":"Resource not accessible"}`))
+				})
+				target := newTarget(t, exe("old binary"))
+				src := testGitHubSource(b)
+				src.Token = tt.token
+				u := b.sourceUpdater(target, src)
+				u.MaxRetries = 0
+
+				err := AutoUpdate(u)
+				assertContents(t, target, exe("old binary"))
+				if err == nil {
+					t.Fatalf("%s: update installed", key)
+				}
+				msg := err.Error()
+				if tt.until.IsZero() {
+					var de *DownloadError
+					if !errors.As(err, &de) || de.StatusCode != tt.status || errors.Is(err, errRateLimited) ||
+						!strings.Contains(msg, "Resource not accessible") {
+						t.Errorf("%s: got %v, want a plain %d", key, err, tt.status)
+					}
+					continue
+				}
+				// the latest version being unknown, the check is skipped
+				latest := strings.HasSuffix(key, "latest")
+				if latest != errors.Is(err, ErrCheckSkipped) {
+					t.Errorf("%s: got %v, skipped check %t", key, err, latest)
+				}
+				if !latest {
+					var de *DownloadError
+					if !errors.As(err, &de) || de.StatusCode != tt.status || !errors.Is(err, errRateLimited) {
+						t.Errorf("%s: got %v, want a rate limited %d", key, err, tt.status)
+					}
+				}
+				if !strings.Contains(msg, "GitHub API rate limit exceeded until ") {
+					t.Errorf("%s: got %q", key, msg)
+				}
+				if tt.headers["Retry-After"] == "" && !strings.Contains(msg, tt.until.Format(time.RFC3339)) {
+					t.Errorf("%s: got %q, want the limit lifted at %s", key, msg, tt.until.Format(time.RFC3339))
+				}
+				if hinted := strings.Contains(msg, "set Token to raise the limit"); hinted != (tt.token == "") {
+					t.Errorf("%s: got %q", key, msg)
+				}
+			}
+		})
+	}
+}
@@ -3,6 +3,8 @@ module github.com/automato-io/s3update
 go 1.15
 
 require (
+	github.com/gabstv/go-bsdiff v1.0.5
+	github.com/klauspost/compress v1.14.4
 	github.com/mitchellh/ioprogress v0.0.0-20180201004757-6a23b12fa88e
 	golang.org/x/mod v0.3.0
 )
@@ -0,0 +1,162 @@
+package s3update
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// defaultHistorySize is how many entries the update history keeps when HistorySize isn't set
+const defaultHistorySize = 100
+
+// HistoryEntry records an update attempt
+type HistoryEntry struct {
+	Time   time.Time `json:"time"`
+	From   string    `json:"from"`
+	To     string    `json:"to"`
+	Target string    `json:"target,omitempty"`
+	URL    string    `json:"url,omitempty"`
+	// Checksum is the SHA-256 checksum of the release downloaded, or of the binary built from a patch.
+	// It is the published one when the update failed or installed a release already downloaded.
+	Checksum string        `json:"checksum,omitempty"`
+	Duration time.Duration `json:"duration"`
+	Success  bool          `json:"success"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// History returns the update attempts recorded next to the state file, oldest first
+func History(u Updater) ([]HistoryEntry, error) {
+	path, err := u.historyPath()
+	if err != nil {
+		return nil, err
+	}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []HistoryEntry
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var e HistoryEntry
+		// a line cut by a crash is skipped
+		if err := json.Unmarshal(scanner.Bytes(), &e); err == nil {
+			entries = append(entries, e)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// historyPath returns the location of the history file, next to the state file: s3update.json keeps its
+// history in s3update.history.jsonl
+func (u Updater) historyPath() (string, error) {
+	path, err := u.statePath()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(path, filepath.Ext(path)) + ".history.jsonl", nil
+}
+
+// recordHistory appends e to the history file, dropping the oldest entries beyond HistorySize when the update lock
+// of e.Target is held, as told by locked, or free. Failing to do so doesn't fail the update.
+func (u Updater) recordHistory(e HistoryEntry, locked bool) {
+	if u.HistorySize < 0 {
+		return
+	}
+	if err := u.appendHistory(e); err != nil {
+		u.logger().Debugf("updater: recording update history: %s", err)
+		return
+	}
+	if !locked {
+		if e.Target == "" {
+			return
+		}
+		// the history gets trimmed by the next update when another one is in progress
+		lock, _, err := acquireLock(e.Target+".lock", 0)
+		if err != nil {
+			return
+		}
+		defer lock.release()
+	}
+	if err := u.trimHistory(); err != nil {
+		u.logger().Debugf("updater: trimming update history: %s", err)
+	}
+}
+
+// appendHistory writes e at the end of the history file, in a single write so that the entries of concurrent
+// updates don't interleave
+func (u Updater) appendHistory(e HistoryEntry) error {
+	path, err := u.historyPath()
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// trimHistory rewrites the history file without the oldest entries beyond HistorySize
+func (u Updater) trimHistory() error {
+	entries, err := History(u)
+	if err != nil {
+		return err
+	}
+	size := u.HistorySize
+	if size == 0 {
+		size = defaultHistorySize
+	}
+	if len(entries) <= size {
+		return nil
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range entries[len(entries)-size:] {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	path, err := u.historyPath()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, buf.Bytes())
+}
+
+// historyEntry describes the attempt to install info into target, which started at start and ended with err
+func (u Updater) historyEntry(info *UpdateInfo, target string, start time.Time, err error) HistoryEntry {
+	e := HistoryEntry{
+		Time:     time.Now(),
+		From:     u.normalize(u.CurrentVersion),
+		To:       info.RemoteVersion,
+		Target:   target,
+		URL:      redactURL(info.DownloadURL),
+		Checksum: info.Checksum,
+		Duration: time.Since(start),
+		Success:  err == nil,
+	}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	return e
+}
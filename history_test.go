@@ -0,0 +1,86 @@
+package s3update
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestAutoUpdateHistory(t *testing.T) {
+	b := newTestBucket(t)
+	b.publish("v1.1.0", []byte(exe("new binary")))
+	target := newTarget(t, exe("old binary"))
+	u := b.updater(target)
+
+	if err := AutoUpdate(u); err != nil {
+		t.Fatal(err)
+	}
+	b.publish("v1.2.0", []byte(exe("newer binary")))
+	b.put("mytool-v1.2.0.sha256", []byte(sha256Hex("tampered")))
+	u.CurrentVersion = "v1.1.0"
+	if err := AutoUpdate(u); err == nil {
+		t.Fatal("tampered release installed")
+	}
+
+	entries, err := History(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries: %+v", len(entries), entries)
+	}
+	if e := entries[0]; !e.Success || e.From != "v1.0.0" || e.To != "v1.1.0" || e.Target != target ||
+		e.Checksum != ChecksumSHA256+":"+sha256Hex(exe("new binary")) {
+		t.Errorf("got %+v", e)
+	}
+	if e := entries[1]; e.Success || e.To != "v1.2.0" || e.Error == "" {
+		t.Errorf("got %+v", e)
+	}
+}
+
+func TestHistoryTrimmed(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "mytool")
+	u := Updater{StateFile: filepath.Join(dir, "s3update.json"), HistorySize: 3, Logger: NopLogger}
+	record := func(to string) {
+		u.recordHistory(HistoryEntry{To: to, Target: target}, false)
+	}
+	assertHistory := func(want ...string) {
+		t.Helper()
+		entries, err := History(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make([]string, len(entries))
+		for i, e := range entries {
+			got[i] = e.To
+		}
+		if len(got) != len(want) {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+		for i := range got {
+			if got[i] != want[i] {
+				t.Fatalf("got %v, want %v", got, want)
+			}
+		}
+	}
+
+	for _, v := range []string{"v1", "v2", "v3", "v4"} {
+		record(v)
+	}
+	assertHistory("v2", "v3", "v4")
+
+	// another update holds the lock, the entry is only appended
+	lock, _, err := acquireLock(target+".lock", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record("v5")
+	assertHistory("v2", "v3", "v4", "v5")
+	u.recordHistory(HistoryEntry{To: "v6", Target: target}, true)
+	assertHistory("v4", "v5", "v6")
+	lock.release()
+
+	u.HistorySize = -1
+	record("v7")
+	assertHistory("v4", "v5", "v6")
+}
@@ -0,0 +1,64 @@
+package s3update
+
+import "time"
+
+// Events receives the outcome of every stage of an update, e.g. to collect fleet metrics.
+// Callbacks are called synchronously, a panic is recovered and logged.
+type Events interface {
+	// CheckCompleted follows every version check, remote being empty when it failed
+	CheckCompleted(current, remote string, err error)
+	// DownloadCompleted follows the download of a release or patch, bytes being how much was read
+	DownloadCompleted(bytes int64, d time.Duration, err error)
+	// UpdateApplied follows the installation of a version
+	UpdateApplied(from, to string)
+	// UpdateFailed tells that AutoUpdate failed at stage "validate", "check", "download", "install" or "restart"
+	UpdateFailed(stage string, err error)
+}
+
+// events returns the configured Events, guarded against panics, or a no-op implementation
+func (u Updater) events() Events {
+	return safeEvents{u}
+}
+
+// safeEvents forwards to Updater.Events, recovering from panics
+type safeEvents struct {
+	u Updater
+}
+
+func (e safeEvents) recover() {
+	if r := recover(); r != nil {
+		e.u.logger().Errorf("s3update: events callback panicked: %v", r)
+	}
+}
+
+func (e safeEvents) CheckCompleted(current, remote string, err error) {
+	if e.u.Events == nil {
+		return
+	}
+	defer e.recover()
+	e.u.Events.CheckCompleted(current, remote, err)
+}
+
+func (e safeEvents) DownloadCompleted(bytes int64, d time.Duration, err error) {
+	if e.u.Events == nil {
+		return
+	}
+	defer e.recover()
+	e.u.Events.DownloadCompleted(bytes, d, err)
+}
+
+func (e safeEvents) UpdateApplied(from, to string) {
+	if e.u.Events == nil {
+		return
+	}
+	defer e.recover()
+	e.u.Events.UpdateApplied(from, to)
+}
+
+func (e safeEvents) UpdateFailed(stage string, err error) {
+	if e.u.Events == nil {
+		return
+	}
+	defer e.recover()
+	e.u.Events.UpdateFailed(stage, err)
+}
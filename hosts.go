@@ -0,0 +1,88 @@
+package s3update
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// maxRedirects is how many redirects a request follows, as the default http.Client does
+const maxRedirects = 10
+
+// checkHost fails with an *UntrustedHostError unless rawURL is served by the bucket endpoint or BaseURL, a mirror
+// or one of AllowedHosts, over https unless the host was configured with http or AllowInsecureHTTP is set
+func (u Updater) checkHost(rawURL string) error {
+	p, err := url.Parse(rawURL)
+	if err != nil {
+		// left to the request to report
+		return nil
+	}
+	insecure := p.Scheme != "https" && !(p.Scheme == "http" && u.AllowInsecureHTTP)
+	for _, base := range append([]string{u.ObjectURL("")}, u.Mirrors...) {
+		b, err := url.Parse(base)
+		if err == nil && strings.EqualFold(b.Host, p.Host) && (b.Scheme == p.Scheme || !insecure) {
+			return nil
+		}
+	}
+	if !insecure {
+		for _, h := range u.AllowedHosts {
+			if strings.EqualFold(h, p.Host) || strings.EqualFold(h, p.Hostname()) {
+				return nil
+			}
+		}
+	}
+	return &UntrustedHostError{URL: rawURL}
+}
+
+// signingHeaders are set by signRequest, Authorization being the only one the client drops on redirects to
+// other hosts
+var signingHeaders = []string{"Authorization", "X-Amz-Security-Token", "X-Amz-Date", "X-Amz-Content-Sha256"}
+
+// bucketHost tells whether host serves the bucket, the only one requests are signed for
+func (u Updater) bucketHost(host string) bool {
+	b, err := url.Parse(u.ObjectURL(""))
+	return err == nil && strings.EqualFold(b.Host, host)
+}
+
+// trustingClient returns client refusing to follow redirects to untrusted hosts, and sending credentials along
+// only to the bucket
+func (u Updater) trustingClient(client *http.Client) *http.Client {
+	c := *client
+	c.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if err := u.checkHost(req.URL.String()); err != nil {
+			return err
+		}
+		if !u.bucketHost(req.URL.Host) {
+			for _, h := range signingHeaders {
+				req.Header.Del(h)
+			}
+		}
+		if client.CheckRedirect != nil {
+			return client.CheckRedirect(req, via)
+		}
+		if len(via) >= maxRedirects {
+			return fmt.Errorf("stopped after %d redirects", maxRedirects)
+		}
+		return nil
+	}
+	return &c
+}
+
+// checkAllowedHost tells whether h is a host, optionally with a port, as listed by AllowedHosts
+func checkAllowedHost(h string) error {
+	if h == "" || strings.ContainsAny(h, "/?#@ ") {
+		return fmt.Errorf("must be a host name, optionally with a port: %q", h)
+	}
+	if _, err := url.Parse("https://" + h); err != nil {
+		return fmt.Errorf("must be a host name, optionally with a port: %q", h)
+	}
+	return nil
+}
+
+// untrustedHost returns the *UntrustedHostError err holds, if any
+func untrustedHost(err error) (*UntrustedHostError, bool) {
+	var he *UntrustedHostError
+	return he, errors.As(err, &he)
+}
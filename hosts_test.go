@@ -0,0 +1,106 @@
+package s3update
+
+import (
+	"errors"
+	"net/http"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// signed tells which of the requests to b carry the headers set by testSigner
+func signed(b *testBucket) map[string]bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	m := map[string]bool{}
+	for _, r := range b.requests {
+		m[r.Method+" "+strings.TrimPrefix(r.URL.Path, "/")] = r.Header.Get("Authorization") != "" ||
+			r.Header.Get("X-Amz-Security-Token") != "" || r.Header.Get("X-Amz-Date") != ""
+	}
+	return m
+}
+
+func testSigner(req *http.Request) error {
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential=AKIA/20240101/us-east-1/s3/aws4_request")
+	req.Header.Set("X-Amz-Security-Token", "session-token")
+	req.Header.Set("X-Amz-Date", "20240101T000000Z")
+	return nil
+}
+
+func TestSigningOnlyForBucket(t *testing.T) {
+	binary := exe("new binary")
+	tests := []struct {
+		name    string
+		publish func(b, cdn *testBucket)
+	}{
+		{"artifact on another host", func(b, cdn *testBucket) {
+			b.put("VERSION", []byte(`{"version": "v1.1.0", "artifacts": {"`+runtime.GOOS+"_"+runtime.GOARCH+`": {"url": "`+
+				cdn.URL+`/mytool-v1.1.0", "sha256": "`+sha256Hex(binary)+`"}}}`))
+		}},
+		{"redirect to another host", func(b, cdn *testBucket) {
+			b.put("VERSION", []byte(`{"version": "v1.1.0", "artifacts": {"`+runtime.GOOS+"_"+runtime.GOARCH+`": {"url": "mytool-{{VERSION}}", "sha256": "`+
+				sha256Hex(binary)+`"}}}`))
+			b.handle("mytool-v1.1.0", func(w http.ResponseWriter, r *http.Request) {
+				http.Redirect(w, r, cdn.URL+"/mytool-v1.1.0", http.StatusFound)
+			})
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, cdn := newTestBucket(t), newTestBucket(t)
+			cdn.put("mytool-v1.1.0", []byte(binary))
+			tt.publish(b, cdn)
+			target := newTarget(t, exe("old binary"))
+			u := b.updater(target)
+			u.ManifestMode, u.ChecksumKey = true, ""
+			u.Signer = testSigner
+			u.AllowedHosts = []string{strings.TrimPrefix(cdn.URL, "http://")}
+
+			if err := AutoUpdate(u); err != nil {
+				t.Fatal(err)
+			}
+			assertContents(t, target, binary)
+			for r, ok := range signed(b) {
+				if !ok {
+					t.Errorf("%s to the bucket isn't signed", r)
+				}
+			}
+			for r, ok := range signed(cdn) {
+				if ok {
+					t.Errorf("%s to another host is signed", r)
+				}
+			}
+			if len(cdn.requested()) == 0 {
+				t.Error("the other host wasn't requested")
+			}
+		})
+	}
+}
+
+func TestUntrustedHostNotSigned(t *testing.T) {
+	b, other := newTestBucket(t), newTestBucket(t)
+	other.put("mytool-v1.1.0", []byte(exe("new binary")))
+	b.put("VERSION", []byte(`{"version": "v1.1.0", "artifacts": {"`+runtime.GOOS+"_"+runtime.GOARCH+`": {"url": "`+
+		other.URL+`/mytool-v1.1.0", "sha256": "`+sha256Hex(exe("new binary"))+`"}}}`))
+	target := newTarget(t, exe("old binary"))
+	u := b.updater(target)
+	u.ManifestMode, u.ChecksumKey = true, ""
+	signings := 0
+	u.Signer = func(req *http.Request) error {
+		if req.URL.Host != strings.TrimPrefix(b.URL, "http://") {
+			t.Errorf("signing the request to %s", req.URL)
+		}
+		signings++
+		return testSigner(req)
+	}
+
+	if err := AutoUpdate(u); !errors.Is(err, ErrUntrustedHost) {
+		t.Fatalf("got %v, want %v", err, ErrUntrustedHost)
+	}
+	if requests := other.requested(); len(requests) > 0 {
+		t.Errorf("untrusted host requested: %v", requests)
+	}
+	if signings == 0 {
+		t.Error("request to the bucket not signed")
+	}
+}
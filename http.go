@@ -0,0 +1,274 @@
+package s3update
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRetryBackoff    = 500 * time.Millisecond
+	maxRetryBackoff        = 30 * time.Second
+	defaultCheckTimeout    = 3 * time.Second
+	defaultRequestTimeout  = 10 * time.Second
+	defaultDownloadTimeout = 60 * time.Second
+)
+
+// defaultHTTPClient is used when Updater.HTTPClient is nil, shared so that requests to the bucket reuse connections.
+// It bounds connection setup and response headers, the whole requests being bounded by RequestTimeout and DownloadTimeout.
+var defaultHTTPClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   10 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 10 * time.Second,
+		IdleConnTimeout:       90 * time.Second,
+	},
+}
+
+// httpClient returns the client configured on u, the one honoring CAFile, CACertPEM and ProxyURL, or the default one
+func (u Updater) httpClient() (*http.Client, error) {
+	if u.HTTPClient != nil {
+		return u.HTTPClient, nil
+	}
+	if u.customTransport() {
+		return u.customClient()
+	}
+	return defaultHTTPClient, nil
+}
+
+// httpGet issues a GET request for a small object bound to ctx, network failures are reported as *DownloadError.
+// The whole request, body included, must complete within RequestTimeout.
+// Connection failures and 429/5xx responses are retried up to MaxRetries times. Only obtaining the
+// response is retried: a body failing midway would have to be downloaded again, which is left to the caller.
+func (u Updater) httpGet(ctx context.Context, url string) (*http.Response, error) {
+	return u.httpDo(ctx, http.MethodGet, url, durationOr(u.RequestTimeout, defaultRequestTimeout))
+}
+
+// httpDownload is like httpGet for releases, which must be downloaded within DownloadTimeout
+func (u Updater) httpDownload(ctx context.Context, url string) (*http.Response, error) {
+	return u.httpDo(ctx, http.MethodGet, url, durationOr(u.DownloadTimeout, defaultDownloadTimeout))
+}
+
+// httpHead issues a HEAD request bound to ctx, with the same retries and timeout as httpGet
+func (u Updater) httpHead(ctx context.Context, url string) (*http.Response, error) {
+	return u.httpDo(ctx, http.MethodHead, url, durationOr(u.RequestTimeout, defaultRequestTimeout))
+}
+
+func (u Updater) httpDo(ctx context.Context, method, url string, timeout time.Duration) (*http.Response, error) {
+	if len(u.Mirrors) > 0 {
+		return u.mirrorDo(ctx, method, url, timeout)
+	}
+	return u.httpRetry(ctx, method, url, timeout)
+}
+
+// httpRetry issues the request to url, retrying it as told by MaxRetries
+func (u Updater) httpRetry(ctx context.Context, method, url string, timeout time.Duration) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		resp, err := u.doRequestTimeout(ctx, method, url, timeout)
+		if attempt > u.MaxRetries || ctx.Err() != nil || !retryable(resp, err) {
+			return resp, err
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+		delay := u.retryDelay(attempt)
+		u.logger().Debugf("updater: retrying %s in %s (attempt %d/%d)", url, delay, attempt, u.MaxRetries)
+		select {
+		case <-ctx.Done():
+			return nil, &DownloadError{URL: url, Err: ctx.Err()}
+		case <-time.After(delay):
+		}
+	}
+}
+
+// doRequestTimeout issues a single request which must complete within timeout, up to the closing of its body
+func (u Updater) doRequestTimeout(ctx context.Context, method, url string, timeout time.Duration) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	resp, err := u.doRequest(ctx, method, url)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelBody releases the context of its request once closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// durationOr returns d, or def when d isn't set
+func durationOr(d, def time.Duration) time.Duration {
+	if d > 0 {
+		return d
+	}
+	return def
+}
+
+// isOffline reports whether err is the kind of network failure to expect without connectivity, a timeout or an
+// unresolvable host
+func isOffline(err error) bool {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	return errors.As(err, &dnsErr) || errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+}
+
+// retryable tells whether a request is worth retrying given its outcome
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		// an untrusted certificate won't get any better
+		var de *DownloadError
+		return errors.As(err, &de) && !untrustedCertificate(err)
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
+
+// retryDelay returns the exponential backoff delay before the given retry, with up to 50% of jitter
+func (u Updater) retryDelay(attempt int) time.Duration {
+	base := u.RetryBackoff
+	if base <= 0 {
+		base = defaultRetryBackoff
+	}
+	d := base << uint(attempt-1)
+	if d <= 0 || d > maxRetryBackoff {
+		d = maxRetryBackoff
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// doRequest issues a single request
+func (u Updater) doRequest(ctx context.Context, method, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if u.RequestHook != nil {
+		u.RequestHook(req)
+	}
+	if err := u.checkHost(req.URL.String()); err != nil {
+		return nil, err
+	}
+	// credentials are only for the bucket, not for the other hosts artifacts may be served by
+	if u.bucketHost(req.URL.Host) {
+		if err := u.signRequest(req); err != nil {
+			return nil, fmt.Errorf("signing request to %s: %w", url, err)
+		}
+	}
+	client, err := u.httpClient()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := u.trustingClient(client).Do(req)
+	if err != nil {
+		// a redirect to an untrusted host isn't worth retrying
+		if he, ok := untrustedHost(err); ok {
+			return nil, he
+		}
+		de := &DownloadError{URL: url, Err: err}
+		if untrustedCertificate(err) {
+			de.Hint = tlsHint
+		}
+		return nil, de
+	}
+
+	// S3 answers 301 without a Location header when the bucket lives in another region
+	region := resp.Header.Get("X-Amz-Bucket-Region")
+	if resp.StatusCode == http.StatusMovedPermanently && region != "" && u.Endpoint == "" && u.BaseURL == "" &&
+		region != u.S3Region {
+		resp.Body.Close()
+		from := u.awsHost()
+		u.S3Region = region
+		return u.doRequest(ctx, method, strings.Replace(url, from, u.awsHost(), 1))
+	}
+	return resp, nil
+}
+
+// truncatedError reports a response body of url that ended after n bytes out of length
+func truncatedError(url string, n, length int64) error {
+	return &DownloadError{URL: url, Err: fmt.Errorf("got %d bytes out of %d: %w", n, length, io.ErrUnexpectedEOF)}
+}
+
+// maxErrorBody caps how much of an unexpected response ends up in error messages
+const maxErrorBody = 200
+
+// Size limits of the small objects read in memory, so that a key pointing at a release by mistake fails early
+const (
+	maxVersionSize   = 1 << 10
+	maxSignatureSize = 1 << 10
+	// checksum documents may list the artifacts of every platform
+	maxChecksumSize = 64 << 10
+	maxYankedSize   = 64 << 10
+	maxManifestSize = 1 << 20
+)
+
+// readBody reads the body of resp from url, failing with ErrResponseTooLarge beyond max bytes
+func readBody(url string, resp *http.Response, max int64) ([]byte, error) {
+	if resp.ContentLength > max {
+		return nil, fmt.Errorf("%w: %s is %d bytes, more than %d", ErrResponseTooLarge, url, resp.ContentLength, max)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, max+1))
+	if err != nil {
+		return nil, &DownloadError{URL: url, Err: err}
+	}
+	if int64(len(body)) > max {
+		return nil, fmt.Errorf("%w: %s is more than %d bytes", ErrResponseTooLarge, url, max)
+	}
+	return body, nil
+}
+
+// statusError reports an unexpected response status for url, along with the S3 error code and message when present
+func (u Updater) statusError(url string, resp *http.Response) error {
+	err := &DownloadError{URL: url, StatusCode: resp.StatusCode}
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	if code, message, ok := s3Error(body); ok {
+		err.S3Code, err.S3Message = code, message
+	} else {
+		err.Body = excerpt(string(body))
+	}
+	if resp.StatusCode == http.StatusForbidden && !u.UseAWSAuth && u.Signer == nil && u.BaseURL == "" {
+		err.Hint = "the bucket may be private, set UseAWSAuth to sign requests"
+	}
+	return err
+}
+
+// s3Error extracts the code and message of the S3 error document body
+func s3Error(body []byte) (code, message string, ok bool) {
+	var doc struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string
+		Message string
+	}
+	if xml.Unmarshal(body, &doc) != nil || doc.Code == "" {
+		return "", "", false
+	}
+	return doc.Code, doc.Message, true
+}
+
+// excerpt returns the beginning of s, short enough to be part of an error message
+func excerpt(s string) string {
+	s = strings.TrimSpace(s)
+	if len(s) > maxErrorBody {
+		return s[:maxErrorBody] + "..."
+	}
+	return s
+}
@@ -0,0 +1,231 @@
+package s3update
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingTransport records the URLs of the requests it forwards
+type recordingTransport struct {
+	mu   sync.Mutex
+	urls []string
+}
+
+func (rt *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt.mu.Lock()
+	rt.urls = append(rt.urls, req.Method+" "+req.URL.Path)
+	rt.mu.Unlock()
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestHTTPClient(t *testing.T) {
+	b := newTestBucket(t)
+	b.publish("v1.1.0", []byte(exe("new binary")))
+	target := newTarget(t, exe("old binary"))
+	rt := &recordingTransport{}
+	u := b.updater(target)
+	u.HTTPClient = &http.Client{Transport: rt}
+
+	if err := AutoUpdate(u); err != nil {
+		t.Fatal(err)
+	}
+	assertContents(t, target, exe("new binary"))
+	got := strings.Join(rt.urls, ", ")
+	for _, want := range []string{"GET /VERSION", "GET /mytool-v1.1.0.sha256", "GET /mytool-v1.1.0"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("%s didn't go through HTTPClient: %s", want, got)
+		}
+	}
+	if len(rt.urls) != len(b.requested()) {
+		t.Errorf("%d requests through HTTPClient, %d received", len(rt.urls), len(b.requested()))
+	}
+}
+
+func TestRequestHook(t *testing.T) {
+	b := newTestBucket(t)
+	b.publish("v1.1.0", []byte(exe("new binary")))
+	target := newTarget(t, exe("old binary"))
+	u := b.updater(target)
+	u.RequestHook = func(req *http.Request) {
+		req.Header.Set("Cookie", "CloudFront-Signature=abc")
+	}
+
+	if err := AutoUpdate(u); err != nil {
+		t.Fatal(err)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, r := range b.requests {
+		if r.Header.Get("Cookie") != "CloudFront-Signature=abc" {
+			t.Errorf("%s %s sent without the header of RequestHook", r.Method, r.URL.Path)
+		}
+	}
+}
+
+func TestDefaultHTTPClient(t *testing.T) {
+	client, err := Updater{}.httpClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client != defaultHTTPClient {
+		t.Fatal("not the default client")
+	}
+	tr := client.Transport.(*http.Transport)
+	if tr.ResponseHeaderTimeout == 0 || tr.TLSHandshakeTimeout == 0 || tr.Proxy == nil {
+		t.Errorf("default transport without timeouts or proxy: %+v", tr)
+	}
+
+	custom := &http.Client{}
+	if client, _ := (Updater{HTTPClient: custom}).httpClient(); client != custom {
+		t.Error("HTTPClient not used")
+	}
+}
+
+func TestStatusErrors(t *testing.T) {
+	errs := []struct {
+		status int
+		code   string
+	}{
+		{http.StatusForbidden, "AccessDenied"},
+		{http.StatusNotFound, "NoSuchKey"},
+		{http.StatusInternalServerError, "InternalError"},
+	}
+	for _, key := range []string{"VERSION", "mytool-v1.1.0.sha256", "mytool-v1.1.0"} {
+		for _, e := range errs {
+			key, status, code := key, e.status, e.code
+			t.Run(fmt.Sprintf("%s %d", key, status), func(t *testing.T) {
+				b := newTestBucket(t)
+				b.publish("v1.1.0", []byte(exe("new binary")))
+				b.handle(key, func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("Content-Type", "application/xml")
+					w.WriteHeader(status)
+					fmt.Fprintf(w, "<Error><Code>%s</Code><Message>failed</Message></Error>", code)
+				})
+				target := newTarget(t, exe("old binary"))
+
+				err := AutoUpdate(b.updater(target))
+				var de *DownloadError
+				var me *MissingArtifactError
+				switch {
+				// S3 answers 403 for missing keys when the bucket can't be listed
+				case key != "VERSION" && (status == http.StatusNotFound || status == http.StatusForbidden):
+					if !errors.As(err, &me) || me.Key != key || me.Checksum != strings.HasSuffix(key, ".sha256") {
+						t.Fatalf("got %v, want a *MissingArtifactError for %s", err, key)
+					}
+				case !errors.As(err, &de):
+					t.Fatalf("got %v, want a *DownloadError", err)
+				default:
+					if de.StatusCode != status || de.URL != b.URL+"/"+key || de.S3Code != code {
+						t.Errorf("got %+v", de)
+					}
+					for _, want := range []string{strconv.Itoa(status), b.URL + "/" + key, code} {
+						if !strings.Contains(err.Error(), want) {
+							t.Errorf("%q doesn't mention %s", err, want)
+						}
+					}
+				}
+				assertContents(t, target, exe("old binary"))
+				// the release isn't downloaded once the version or checksum failed
+				for _, r := range b.requested() {
+					if r == "GET mytool-v1.1.0" && key != "mytool-v1.1.0" {
+						t.Errorf("release downloaded after %s failed", key)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestOversizedResponses(t *testing.T) {
+	tests := []struct {
+		key  string
+		size int
+	}{
+		{"VERSION", maxVersionSize + 1},
+		{"mytool-v1.1.0.sha256", maxChecksumSize + 1},
+	}
+	for _, tt := range tests {
+		for _, chunked := range []bool{false, true} {
+			key, size, chunked := tt.key, tt.size, chunked
+			t.Run(fmt.Sprintf("%s chunked %v", key, chunked), func(t *testing.T) {
+				b := newTestBucket(t)
+				b.publish("v1.1.0", []byte(exe("new binary")))
+				b.handle(key, func(w http.ResponseWriter, r *http.Request) {
+					body := []byte(strings.Repeat("1", size))
+					if !chunked {
+						w.Header().Set("Content-Length", strconv.Itoa(size))
+						w.Write(body)
+						return
+					}
+					// no Content-Length, the limit applies while reading
+					for len(body) > 0 {
+						n := 512
+						if n > len(body) {
+							n = len(body)
+						}
+						w.Write(body[:n])
+						w.(http.Flusher).Flush()
+						body = body[n:]
+					}
+				})
+				target := newTarget(t, exe("old binary"))
+
+				err := AutoUpdate(b.updater(target))
+				if !errors.Is(err, ErrResponseTooLarge) {
+					t.Fatalf("got %v, want %v", err, ErrResponseTooLarge)
+				}
+				if !strings.Contains(err.Error(), b.URL+"/"+key) {
+					t.Errorf("%q doesn't name the URL", err)
+				}
+				assertContents(t, target, exe("old binary"))
+				for _, r := range b.requested() {
+					if r == "GET mytool-v1.1.0" {
+						t.Error("release downloaded")
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	for _, key := range []string{"VERSION", "mytool-v1.1.0.sha256"} {
+		b := newTestBucket(t)
+		b.publish("v1.1.0", []byte(exe("new binary")))
+		release := make(chan struct{})
+		b.handle(key, func(w http.ResponseWriter, r *http.Request) {
+			// headers are sent, the body hangs
+			w.Header().Set("Content-Length", "100")
+			w.Write([]byte("v1"))
+			w.(http.Flusher).Flush()
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		})
+		target := newTarget(t, exe("old binary"))
+		u := b.updater(target)
+		u.CheckTimeout, u.RequestTimeout = 100*time.Millisecond, 100*time.Millisecond
+
+		start := time.Now()
+		err := AutoUpdate(u)
+		close(release)
+		if err == nil {
+			t.Fatalf("%s: no error", key)
+		}
+		if !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), b.URL+"/"+key) {
+			t.Errorf("%s: got %v, want a timeout naming the URL", key, err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("%s: failed after %v", key, elapsed)
+		}
+		assertContents(t, target, exe("old binary"))
+	}
+}
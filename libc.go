@@ -0,0 +1,89 @@
+package s3update
+
+import (
+	"debug/elf"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// Values of the {{LIBC}} placeholder on linux
+const (
+	LibcGlibc = "glibc"
+	LibcMusl  = "musl"
+)
+
+// detectLibc finds out the C library of the system, a variable so that detection can be stubbed
+var detectLibc = func() (string, error) {
+	if exe, err := os.Executable(); err == nil {
+		if libc := interpreterLibc(exe); libc != "" {
+			return libc, nil
+		}
+	}
+	// statically linked binaries have no interpreter, look for the loaders installed instead
+	if m, _ := filepath.Glob("/lib/ld-musl-*.so.1"); len(m) > 0 {
+		return LibcMusl, nil
+	}
+	for _, pattern := range []string{"/lib*/ld-linux*.so.*", "/lib/*-linux-gnu*/ld-linux*.so.*"} {
+		if m, _ := filepath.Glob(pattern); len(m) > 0 {
+			return LibcGlibc, nil
+		}
+	}
+	return "", errors.New("couldn't detect the C library, set Updater.Libc or Updater.DefaultLibc")
+}
+
+var detectedLibc struct {
+	once sync.Once
+	libc string
+	err  error
+}
+
+// libc returns the C library substituted to {{LIBC}}, empty on other systems than linux
+func (u Updater) libc() (string, error) {
+	if runtime.GOOS != "linux" {
+		return "", nil
+	}
+	if u.Libc != "" {
+		return u.Libc, nil
+	}
+	detectedLibc.once.Do(func() {
+		detectedLibc.libc, detectedLibc.err = detectLibc()
+	})
+	if detectedLibc.err != nil {
+		if u.DefaultLibc != "" {
+			u.logger().Debugf("updater: %s, assuming %s", detectedLibc.err, u.DefaultLibc)
+			return u.DefaultLibc, nil
+		}
+		return "", detectedLibc.err
+	}
+	return detectedLibc.libc, nil
+}
+
+// interpreterLibc tells the C library of the dynamically linked executable filename from its interpreter
+func interpreterLibc(filename string) string {
+	f, err := elf.Open(filename)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	for _, p := range f.Progs {
+		if p.Type != elf.PT_INTERP {
+			continue
+		}
+		interp, err := ioutil.ReadAll(p.Open())
+		if err != nil {
+			return ""
+		}
+		switch name := filepath.Base(strings.TrimRight(string(interp), "\x00")); {
+		case strings.HasPrefix(name, "ld-musl"):
+			return LibcMusl
+		case strings.HasPrefix(name, "ld-linux"):
+			return LibcGlibc
+		}
+	}
+	return ""
+}
@@ -0,0 +1,112 @@
+package s3update
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+// stubLibc replaces the detection of the C library for the duration of the test, returning the number of detections
+func stubLibc(t *testing.T, libc string, err error) *int {
+	t.Helper()
+	detect, calls := detectLibc, 0
+	detectLibc = func() (string, error) {
+		calls++
+		return libc, err
+	}
+	reset := func() {
+		detectedLibc.once, detectedLibc.libc, detectedLibc.err = sync.Once{}, "", nil
+	}
+	reset()
+	t.Cleanup(func() {
+		detectLibc = detect
+		reset()
+		if calls > 1 {
+			t.Errorf("C library detected %d times", calls)
+		}
+	})
+	return &calls
+}
+
+func TestLibcPlaceholder(t *testing.T) {
+	detectErr := errors.New("no loader")
+	tests := []struct {
+		name        string
+		detected    string
+		err         error
+		libc, deflt string
+		want        string
+		wantErr     bool
+	}{
+		{"glibc", LibcGlibc, nil, "", "", "glibc", false},
+		{"musl", LibcMusl, nil, "", "", "musl", false},
+		{"override", LibcGlibc, nil, LibcMusl, "", "musl", false},
+		{"override without detection", "", detectErr, LibcMusl, "", "musl", false},
+		{"default on failure", "", detectErr, "", LibcGlibc, "glibc", false},
+		{"default ignored when detected", LibcMusl, nil, "", LibcGlibc, "musl", false},
+		{"failure", "", detectErr, "", "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stubLibc(t, tt.detected, tt.err)
+			u := Updater{BaseURL: "https://downloads.example.com", Libc: tt.libc, DefaultLibc: tt.deflt}
+			got, err := u.GenerateURL("mycli_{{OS}}_{{LIBC}}", "")
+			if runtime.GOOS != "linux" {
+				if err != nil || got != "https://downloads.example.com/mycli_"+runtime.GOOS+"_" {
+					t.Errorf("got %s, %v, want an empty libc", got, err)
+				}
+				return
+			}
+			if tt.wantErr {
+				if !errors.Is(err, detectErr) {
+					t.Errorf("got %s, %v, want %v", got, err, detectErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := "https://downloads.example.com/mycli_linux_" + tt.want; got != want {
+				t.Errorf("got %s, want %s", got, want)
+			}
+			// detection is cached
+			u.GenerateURL("mycli_{{LIBC}}", "")
+		})
+	}
+}
+
+func TestLibcDetectedOnlyWhenUsed(t *testing.T) {
+	calls := stubLibc(t, "", errors.New("no loader"))
+	if _, err := (Updater{BaseURL: "https://downloads.example.com"}).GenerateURL("mycli_{{OS}}", ""); err != nil {
+		t.Fatal(err)
+	}
+	if *calls != 0 {
+		t.Error("C library detected without {{LIBC}}")
+	}
+}
+
+func TestInterpreterLibc(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "mytool")
+	if err := ioutil.WriteFile(filename, []byte(exe("not an ELF binary")), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if libc := interpreterLibc(filename); libc != "" {
+		t.Errorf("got %q for a script", libc)
+	}
+	if runtime.GOOS != "linux" {
+		return
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	switch libc := interpreterLibc(exe); libc {
+	case "", LibcGlibc, LibcMusl:
+	default:
+		t.Errorf("got %q for the test binary", libc)
+	}
+}
@@ -0,0 +1,137 @@
+package s3update
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// versionMarker and semverMarker stand for the version while turning S3ReleaseKey into a pattern
+const (
+	versionMarker = "S3UPDATEVERSION"
+	semverMarker  = "S3UPDATESEMVER"
+)
+
+var versionMarkers = strings.NewReplacer("{{VERSION}}", versionMarker, "{{TO}}", versionMarker, "{{SEMVER}}", semverMarker)
+
+// ListVersions returns the versions published in the bucket, newest first. They're read from the keys listed under
+// ListPrefix that match S3ReleaseKey for the running platform, so that versions without an artifact for it are left
+// out. Keys that don't hold a valid version are ignored.
+func ListVersions(ctx context.Context, u Updater) ([]string, error) {
+	u = u.withEnv()
+	if err := u.Validate(); err != nil {
+		return nil, err
+	}
+	if u.Source != nil {
+		return nil, errors.New("ListVersions lists the bucket, which Source replaces")
+	}
+	pattern, prefix, err := u.releaseKeyPattern()
+	if err != nil {
+		return nil, err
+	}
+	if u.ListPrefix != "" {
+		prefix = u.ListPrefix
+	}
+
+	seen := map[string]bool{}
+	var versions []string
+	token := ""
+	for {
+		keys, next, err := u.listObjects(ctx, prefix, token)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			m := pattern.FindStringSubmatch(key)
+			if m == nil {
+				continue
+			}
+			v := ""
+			for _, group := range m[1:] {
+				if group != "" {
+					v = group
+				}
+			}
+			v = u.normalize(v)
+			if seen[v] || u.validateVersion(v) != nil {
+				continue
+			}
+			seen[v] = true
+			versions = append(versions, v)
+		}
+		if next == "" {
+			break
+		}
+		token = next
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return u.compareVersions(versions[i], versions[j]) > 0
+	})
+	return versions, nil
+}
+
+// releaseKeyPattern turns S3ReleaseKey into a pattern capturing the version of the keys, and returns it along
+// with the part of the keys before the version
+func (u Updater) releaseKeyPattern() (*regexp.Regexp, string, error) {
+	key, err := u.expandKey(versionMarkers.Replace(u.S3ReleaseKey), "", nil)
+	if err != nil {
+		return nil, "", err
+	}
+	i := strings.Index(key, versionMarker)
+	if j := strings.Index(key, semverMarker); j >= 0 && (i < 0 || j < i) {
+		i = j
+	}
+	if i < 0 {
+		return nil, "", errors.New("S3ReleaseKey has no {{VERSION}} or {{SEMVER}} placeholder")
+	}
+	expr := strings.NewReplacer(versionMarker, "([^/]+)", semverMarker, "v([^/]+)").Replace(regexp.QuoteMeta(key))
+	pattern, err := regexp.Compile("^" + expr + "$")
+	if err != nil {
+		return nil, "", err
+	}
+	return pattern, key[:i], nil
+}
+
+// listBucketResult is the answer to ListObjectsV2
+type listBucketResult struct {
+	Contents []struct {
+		Key string
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+// listObjects returns a page of the keys starting with prefix, and the token of the next page if any
+func (u Updater) listObjects(ctx context.Context, prefix, token string) ([]string, string, error) {
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+	if token != "" {
+		query.Set("continuation-token", token)
+	}
+	listURL := strings.TrimSuffix(u.ObjectURL(""), "/") + "/?" + query.Encode()
+	resp, err := u.httpGet(ctx, listURL)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", u.statusError(listURL, resp)
+	}
+	var res listBucketResult
+	if err := xml.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, "", fmt.Errorf("decoding the listing of %s: %w", listURL, err)
+	}
+	keys := make([]string, 0, len(res.Contents))
+	for _, c := range res.Contents {
+		keys = append(keys, c.Key)
+	}
+	if !res.IsTruncated {
+		return keys, "", nil
+	}
+	return keys, res.NextContinuationToken, nil
+}
@@ -0,0 +1,82 @@
+package s3update
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestListVersionsPaginated(t *testing.T) {
+	pages := map[string]struct {
+		keys []string
+		next string
+	}{
+		"":     {[]string{"mytool-v1.0.0", "mytool-v1.0.0.sha256", "mytool-v1.2.0"}, "tok2"},
+		"tok2": {[]string{"mytool-v1.10.0", "mytool-v1.2.0", "mytool-latest"}, "tok3"},
+		"tok3": {[]string{"mytool-v1.9.0", "mytool-v1.9.0.sha256"}, ""},
+	}
+	var (
+		mu     sync.Mutex
+		tokens []string
+	)
+	b := newTestBucket(t)
+	b.handle("", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("list-type") != "2" || q.Get("prefix") != "mytool-" {
+			t.Errorf("got query %s", r.URL.RawQuery)
+		}
+		token := q.Get("continuation-token")
+		mu.Lock()
+		tokens = append(tokens, token)
+		mu.Unlock()
+		page, ok := pages[token]
+		if !ok {
+			http.Error(w, "unknown token", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><ListBucketResult>`)
+		for _, key := range page.keys {
+			fmt.Fprintf(w, "<Contents><Key>%s</Key></Contents>", key)
+		}
+		fmt.Fprintf(w, "<IsTruncated>%t</IsTruncated>", page.next != "")
+		if page.next != "" {
+			fmt.Fprintf(w, "<NextContinuationToken>%s</NextContinuationToken>", page.next)
+		}
+		fmt.Fprint(w, "</ListBucketResult>")
+	})
+
+	versions, err := ListVersions(context.Background(), b.updater(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Join(versions, " "), "v1.10.0 v1.9.0 v1.2.0 v1.0.0"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if got, want := strings.Join(tokens, ","), ",tok2,tok3"; got != want {
+		t.Errorf("requested pages %q, want %q", got, want)
+	}
+}
+
+func TestListVersionsTokenWithoutTruncation(t *testing.T) {
+	b := newTestBucket(t)
+	b.handle("", func(w http.ResponseWriter, r *http.Request) {
+		if token := r.URL.Query().Get("continuation-token"); token != "" {
+			t.Errorf("requested page %q of a complete listing", token)
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		fmt.Fprint(w, `<ListBucketResult><Contents><Key>mytool-v1.1.0</Key></Contents>`+
+			`<IsTruncated>false</IsTruncated><NextContinuationToken>stale</NextContinuationToken></ListBucketResult>`)
+	})
+
+	versions, err := ListVersions(context.Background(), b.updater(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 1 || versions[0] != "v1.1.0" {
+		t.Errorf("got %v", versions)
+	}
+}
@@ -0,0 +1,50 @@
+package s3update
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+// errLocked is returned by tryLock when another process holds the lock
+var errLocked = errors.New("file is locked")
+
+// lockPollInterval is how often a waiting process retries to take the lock
+const lockPollInterval = 100 * time.Millisecond
+
+// fileLock is an exclusive advisory lock held on a file
+type fileLock struct {
+	f *os.File
+}
+
+// acquireLock takes the exclusive lock on path, waiting up to timeout for another process to release it.
+// It reports whether it had to wait, and returns ErrUpdateInProgress when the lock couldn't be taken in time.
+func acquireLock(path string, timeout time.Duration) (*fileLock, bool, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, false, err
+	}
+	deadline := time.Now().Add(timeout)
+	waited := false
+	for {
+		err := tryLock(f)
+		if err == nil {
+			return &fileLock{f: f}, waited, nil
+		}
+		if err != errLocked || !time.Now().Before(deadline) {
+			f.Close()
+			if err == errLocked {
+				return nil, waited, ErrUpdateInProgress
+			}
+			return nil, waited, err
+		}
+		waited = true
+		time.Sleep(lockPollInterval)
+	}
+}
+
+// release unlocks and closes the lock file, it's left on disk so that every process locks the same inode
+func (l *fileLock) release() {
+	unlock(l.f)
+	l.f.Close()
+}
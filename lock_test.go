@@ -0,0 +1,124 @@
+package s3update
+
+import (
+	"errors"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAcquireLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mytool.lock")
+	lock, waited, err := acquireLock(path, 0)
+	if err != nil || waited {
+		t.Fatalf("got %v, waited %v", err, waited)
+	}
+	if _, _, err := acquireLock(path, 0); err != ErrUpdateInProgress {
+		t.Fatalf("got %v, want %v", err, ErrUpdateInProgress)
+	}
+
+	go func() {
+		time.Sleep(2 * lockPollInterval)
+		lock.release()
+	}()
+	next, waited, err := acquireLock(path, time.Minute)
+	if err != nil || !waited {
+		t.Fatalf("got %v, waited %v", err, waited)
+	}
+	next.release()
+}
+
+// concurrentUpdates runs n updates of the same target at once, the release being served once every one of them
+// checked the version
+func concurrentUpdates(t *testing.T, n int, lockTimeout time.Duration) (*testBucket, string, []*UpdateResult, []error) {
+	b := newTestBucket(t)
+	binary := []byte(exe("new binary"))
+	b.publish("v1.1.0", binary)
+	checked := make(chan struct{}, n)
+	b.handle("VERSION", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("v1.1.0"))
+		checked <- struct{}{}
+	})
+	var once sync.Once
+	b.handle("mytool-v1.1.0", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(binary)))
+		if r.Method == http.MethodHead {
+			return
+		}
+		once.Do(func() {
+			for i := 0; i < n; i++ {
+				<-checked
+			}
+			// leaves the others time to wait for the lock
+			time.Sleep(200 * time.Millisecond)
+		})
+		w.Write(binary)
+	})
+	target := newTarget(t, exe("old binary"))
+	u := b.updater(target)
+	u.LockTimeout = lockTimeout
+
+	results := make([]*UpdateResult, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = AutoUpdateResult(u)
+		}(i)
+	}
+	wg.Wait()
+	return b, target, results, errs
+}
+
+// downloads counts the downloads of the release
+func downloads(b *testBucket) int {
+	n := 0
+	for _, r := range b.requested() {
+		if r == "GET mytool-v1.1.0" {
+			n++
+		}
+	}
+	return n
+}
+
+func TestConcurrentUpdatesWait(t *testing.T) {
+	b, target, results, errs := concurrentUpdates(t, 6, time.Minute)
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("update %d: %v", i, err)
+		} else if !results[i].Updated {
+			t.Errorf("update %d: got %+v", i, results[i])
+		}
+	}
+	if n := downloads(b); n != 1 {
+		t.Errorf("release downloaded %d times", n)
+	}
+	assertContents(t, target, exe("new binary"))
+	assertAlone(t, target)
+}
+
+func TestConcurrentUpdatesSkip(t *testing.T) {
+	b, target, _, errs := concurrentUpdates(t, 6, 0)
+	succeeded := 0
+	for i, err := range errs {
+		switch {
+		case err == nil:
+			succeeded++
+		case !errors.Is(err, ErrUpdateInProgress):
+			t.Errorf("update %d: got %v, want %v", i, err, ErrUpdateInProgress)
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("%d updates succeeded", succeeded)
+	}
+	if n := downloads(b); n != 1 {
+		t.Errorf("release downloaded %d times", n)
+	}
+	assertContents(t, target, exe("new binary"))
+	assertAlone(t, target)
+}
@@ -0,0 +1,55 @@
+package s3update
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// Logger receives the messages emitted while updating
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+	Errorf(format string, args ...interface{})
+}
+
+// NopLogger discards every message
+var NopLogger Logger = nopLogger{}
+
+type nopLogger struct{}
+
+func (nopLogger) Debugf(format string, args ...interface{}) {}
+func (nopLogger) Infof(format string, args ...interface{})  {}
+func (nopLogger) Errorf(format string, args ...interface{}) {}
+
+// writerLogger writes messages to w, one per line, debug ones only when debug is set
+type writerLogger struct {
+	w     io.Writer
+	debug bool
+}
+
+func (l writerLogger) Debugf(format string, args ...interface{}) {
+	if l.debug {
+		fmt.Fprintf(l.w, format+"\n", args...)
+	}
+}
+
+func (l writerLogger) Infof(format string, args ...interface{}) {
+	fmt.Fprintf(l.w, format+"\n", args...)
+}
+
+func (l writerLogger) Errorf(format string, args ...interface{}) {
+	fmt.Fprintf(l.w, format+"\n", args...)
+}
+
+// logger returns the configured Logger, defaulting to stderr with debug messages enabled by Verbose.
+// The default logger is silent in OutputJSON mode. Credentials in the URLs of messages are redacted.
+func (u Updater) logger() Logger {
+	if u.Logger == NopLogger || (u.Logger == nil && u.jsonOutput()) {
+		return NopLogger
+	}
+	if u.Logger != nil {
+		return redactingLogger{l: u.Logger}
+	}
+	return redactingLogger{l: writerLogger{w: os.Stderr, debug: u.Verbose}}
+}
@@ -0,0 +1,151 @@
+package s3update
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recordingLogger records the messages it receives, prefixed by their level
+type recordingLogger struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (l *recordingLogger) record(level, format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.messages = append(l.messages, level+" "+fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) Debugf(format string, args ...interface{}) {
+	l.record("debug", format, args...)
+}
+
+func (l *recordingLogger) Infof(format string, args ...interface{}) {
+	l.record("info", format, args...)
+}
+
+func (l *recordingLogger) Errorf(format string, args ...interface{}) {
+	l.record("error", format, args...)
+}
+
+func (l *recordingLogger) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return strings.Join(l.messages, "\n")
+}
+
+// captureOutput returns what f writes to stdout and stderr
+func captureOutput(t *testing.T, f func()) (string, string) {
+	t.Helper()
+	capture := func(file **os.File) (func() string, error) {
+		r, w, err := os.Pipe()
+		if err != nil {
+			return nil, err
+		}
+		orig := *file
+		*file = w
+		var buf bytes.Buffer
+		done := make(chan struct{})
+		go func() {
+			io.Copy(&buf, r)
+			close(done)
+		}()
+		return func() string {
+			*file = orig
+			w.Close()
+			<-done
+			r.Close()
+			return buf.String()
+		}, nil
+	}
+	stdout, err := capture(&os.Stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr, err := capture(&os.Stderr)
+	if err != nil {
+		stdout()
+		t.Fatal(err)
+	}
+	f()
+	return stdout(), stderr()
+}
+
+func TestNopLoggerKeepsStdoutClean(t *testing.T) {
+	b := newTestBucket(t)
+	b.publish("v1.1.0", []byte(exe("new binary")))
+	target := newTarget(t, exe("old binary"))
+	u := b.updater(target)
+	u.Logger, u.DisableProgress, u.Verbose = NopLogger, false, true
+
+	var err error
+	stdout, _ := captureOutput(t, func() { err = AutoUpdate(u) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stdout != "" {
+		t.Errorf("stdout got %q", stdout)
+	}
+	assertContents(t, target, exe("new binary"))
+}
+
+func TestDefaultLoggerWritesToStderr(t *testing.T) {
+	b := newTestBucket(t)
+	b.publish("v1.1.0", []byte(exe("new binary")))
+	target := newTarget(t, exe("old binary"))
+	u := b.updater(target)
+	u.Logger = nil
+
+	var err error
+	stdout, stderr := captureOutput(t, func() { err = AutoUpdate(u) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stdout != "" {
+		t.Errorf("stdout got %q", stdout)
+	}
+	if !strings.Contains(stderr, "upgrading from v1.0.0 to v1.1.0") {
+		t.Errorf("stderr got %q", stderr)
+	}
+	if strings.Contains(stderr, "downloadURL") {
+		t.Errorf("debug messages printed without Verbose: %q", stderr)
+	}
+
+	// Verbose enables the debug level
+	b.publish("v1.2.0", []byte(exe("newer binary")))
+	u.CurrentVersion, u.Verbose = "v1.1.0", true
+	_, stderr = captureOutput(t, func() { err = AutoUpdate(u) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stderr, "downloadURL: "+b.URL+"/mytool-v1.2.0") {
+		t.Errorf("stderr got %q", stderr)
+	}
+}
+
+func TestLoggerLevels(t *testing.T) {
+	b := newTestBucket(t)
+	b.publish("v1.1.0", []byte(exe("new binary")))
+	l := &recordingLogger{}
+	u := b.updater(newTarget(t, exe("old binary")))
+	u.Logger = l
+
+	if err := AutoUpdate(u); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"info upgrading from v1.0.0 to v1.1.0",
+		"debug downloadURL: " + b.URL + "/mytool-v1.1.0",
+		"info successfully updated to v1.1.0",
+	} {
+		if !strings.Contains(l.String(), want) {
+			t.Errorf("%q not logged:\n%s", want, l)
+		}
+	}
+}
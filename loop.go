@@ -0,0 +1,38 @@
+package s3update
+
+import (
+	"fmt"
+	"time"
+)
+
+// updateLoopWindow is how long after an update the running binary is expected to report the installed version
+const updateLoopWindow = 10 * time.Minute
+
+// recordUpdate saves the version the running binary got updated to, for checkUpdateLoop
+func (u Updater) recordUpdate(version string) {
+	if u.TargetPath != "" || version == "" {
+		return
+	}
+	st := u.loadState()
+	st.UpdatedTo, st.UpdatedAt = version, time.Now()
+	if err := u.saveState(st); err != nil {
+		u.logger().Debugf("updater: saving state: %s", err)
+	}
+}
+
+// checkUpdateLoop returns ErrUpdateLoopDetected when the binary just got updated to the remote version but still
+// reports another one, as happens when the published artifact is an older build: updating again would loop forever.
+func (u Updater) checkUpdateLoop(info *UpdateInfo) error {
+	if u.TargetPath != "" {
+		return nil
+	}
+	st := u.loadState()
+	if st.UpdatedTo == "" || st.UpdatedTo != info.RemoteVersion || st.UpdatedTo == info.CurrentVersion {
+		return nil
+	}
+	if since := time.Since(st.UpdatedAt); since >= 0 && since < updateLoopWindow {
+		return fmt.Errorf("%w: updated to %s %s ago but still running %s, the published release is probably another build",
+			ErrUpdateLoopDetected, st.UpdatedTo, since.Round(time.Second), info.CurrentVersion)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package s3update
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCheckUpdateLoop(t *testing.T) {
+	tests := []struct {
+		name      string
+		updatedTo string
+		ago       time.Duration
+		current   string
+		remote    string
+		loop      bool
+	}{
+		{"never updated", "", 0, "v1.9.9", "v2.0.0", false},
+		{"still running another build", "v2.0.0", time.Minute, "v1.9.9", "v2.0.0", true},
+		{"running the installed version", "v2.0.0", time.Minute, "v2.0.0", "v2.0.0", false},
+		{"newer release since", "v2.0.0", time.Minute, "v1.9.9", "v2.0.1", false},
+		{"outside of the window", "v2.0.0", updateLoopWindow + time.Minute, "v1.9.9", "v2.0.0", false},
+		{"clock moved backwards", "v2.0.0", -time.Minute, "v1.9.9", "v2.0.0", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := Updater{StateFile: filepath.Join(t.TempDir(), "s3update.json"), Logger: NopLogger}
+			if tt.updatedTo != "" {
+				if err := u.saveState(state{UpdatedTo: tt.updatedTo, UpdatedAt: time.Now().Add(-tt.ago)}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			err := u.checkUpdateLoop(&UpdateInfo{CurrentVersion: tt.current, RemoteVersion: tt.remote, UpdateAvailable: true})
+			if loop := errors.Is(err, ErrUpdateLoopDetected); loop != tt.loop || (!loop && err != nil) {
+				t.Errorf("got %v, want a loop detected: %v", err, tt.loop)
+			}
+		})
+	}
+}
+
+func TestRecordUpdate(t *testing.T) {
+	u := Updater{StateFile: filepath.Join(t.TempDir(), "s3update.json"), Logger: NopLogger}
+	u.recordUpdate("v2.0.0")
+	st := u.loadState()
+	if st.UpdatedTo != "v2.0.0" || time.Since(st.UpdatedAt) > time.Minute {
+		t.Errorf("got %s at %v", st.UpdatedTo, st.UpdatedAt)
+	}
+	// the process that restarted after the update still runs the previous build
+	u.CurrentVersion = "v1.9.9"
+	if err := u.checkUpdateLoop(&UpdateInfo{CurrentVersion: "v1.9.9", RemoteVersion: "v2.0.0"}); !errors.Is(err, ErrUpdateLoopDetected) {
+		t.Errorf("got %v, want %v", err, ErrUpdateLoopDetected)
+	}
+
+	// other binaries than the running one don't restart
+	u = Updater{StateFile: filepath.Join(t.TempDir(), "s3update.json"), TargetPath: "mytool", Logger: NopLogger}
+	u.recordUpdate("v2.0.0")
+	if st := u.loadState(); st.UpdatedTo != "" {
+		t.Errorf("recorded an update of %s", u.TargetPath)
+	}
+}
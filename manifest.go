@@ -0,0 +1,83 @@
+package s3update
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"runtime"
+)
+
+// Manifest is the JSON document published at S3VersionKey in ManifestMode:
+//
+//	{
+//	  "version": "v1.5.0",
+//	  "minVersion": "v1.2.0",
+//	  "artifacts": {"linux_amd64": {"url": "mytool/v1.5.0/mytool-linux-amd64.tgz", "sha256": "..."}},
+//	  "notes": "...",
+//	  "yanked": ["v1.4.0"],
+//	  "rollout": 0.1
+//	}
+//
+// Artifact URLs are either absolute or key templates within the bucket. Unknown fields are ignored.
+type Manifest struct {
+	Version    string                      `json:"version"`
+	MinVersion string                      `json:"minVersion,omitempty"`
+	Artifacts  map[string]ManifestArtifact `json:"artifacts"`
+	Notes      string                      `json:"notes,omitempty"`
+	// Yanked lists the versions never to be installed
+	Yanked []string `json:"yanked,omitempty"`
+	// Rollout is the fraction of installs Version is rolled out to, all of them when nil.
+	// Each install is given a stable position in [0, 1) to decide whether it's part of the fraction.
+	Rollout *float64 `json:"rollout,omitempty"`
+}
+
+// ManifestArtifact locates the release of one platform
+type ManifestArtifact struct {
+	URL    string `json:"url"`
+	SHA256 string `json:"sha256,omitempty"`
+	// Bases lists the SHA-256 of the binaries of previous versions by version, for delta updates to make sure
+	// the patch from the running version applies to its binary
+	Bases map[string]string `json:"bases,omitempty"`
+}
+
+// decodeManifest parses the manifest read from r
+func decodeManifest(r io.Reader) (*Manifest, error) {
+	var m Manifest
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return nil, fmt.Errorf("decoding manifest: %w", err)
+	}
+	return &m, nil
+}
+
+// applyManifest points info to the artifact published for the current platform
+func applyManifest(u Updater, info *UpdateInfo, m *Manifest) error {
+	info.Notes = m.Notes
+	a, ok := m.Artifacts[runtime.GOOS+"_"+runtime.GOARCH]
+	if !ok || a.URL == "" {
+		if info.UpdateAvailable {
+			return fmt.Errorf("no artifact published for %s/%s in %s", runtime.GOOS, runtime.GOARCH, m.Version)
+		}
+		return nil
+	}
+	if p, err := url.Parse(a.URL); err == nil && p.Scheme != "" {
+		info.DownloadURL = a.URL
+	} else {
+		if info.DownloadURL, err = u.GenerateURL(a.URL, m.Version); err != nil {
+			return err
+		}
+	}
+	// the embedded checksum replaces the checksum object, still read for LatestKey metadata without one
+	if u.ManifestMode || a.SHA256 != "" {
+		info.ChecksumURL = ""
+	}
+	if a.SHA256 != "" {
+		info.Checksum = ChecksumSHA256 + ":" + a.SHA256
+	}
+	for v, sum := range a.Bases {
+		if sum != "" && u.normalize(v) == u.normalize(u.CurrentVersion) {
+			info.BaseChecksum = ChecksumSHA256 + ":" + sum
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package s3update
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"runtime"
+	"strings"
+)
+
+// Metadata of the object at LatestKey, set by the publisher at upload time, e.g. with
+// aws s3 cp --metadata version=v1.5.0,sha256=<digest>
+const (
+	metaVersionHeader = "X-Amz-Meta-Version"
+	metaSHA256Header  = "X-Amz-Meta-Sha256"
+)
+
+// fetchLatestMetadata issues a HEAD request for the object at LatestKey and describes it as a manifest listing it
+// for the running platform, along with the checksum of its metadata. nil is returned when it has no version metadata.
+func fetchLatestMetadata(ctx context.Context, u Updater) (*Manifest, error) {
+	latestURL := generateURL(u, u.LatestKey, "")
+	resp, err := u.httpDo(ctx, http.MethodHead, latestURL, durationOr(u.CheckTimeout, defaultCheckTimeout))
+	if err != nil {
+		if ctx.Err() == nil && isOffline(err) {
+			u.logger().Debugf("updater: skipping update check: %s", err)
+			return nil, fmt.Errorf("%w: %v", ErrCheckSkipped, err)
+		}
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, u.statusError(latestURL, resp)
+	}
+	version := strings.TrimSpace(resp.Header.Get(metaVersionHeader))
+	if version == "" {
+		return nil, nil
+	}
+	if err := u.validateVersion(u.normalize(version)); err != nil {
+		return nil, fmt.Errorf("%w: %v in the metadata of %s: %v", ErrInvalidRemoteVersion, excerpt(version), latestURL, err)
+	}
+	sum := strings.TrimPrefix(strings.ToLower(strings.TrimSpace(resp.Header.Get(metaSHA256Header))), ChecksumSHA256+":")
+	return &Manifest{
+		Version:   version,
+		Artifacts: map[string]ManifestArtifact{runtime.GOOS + "_" + runtime.GOARCH: {URL: latestURL, SHA256: sum}},
+	}, nil
+}
+
+// releaseKey returns the key template of releases, LatestKey serving them when S3ReleaseKey isn't set
+func (u Updater) releaseKey() string {
+	if u.S3ReleaseKey == "" {
+		return u.LatestKey
+	}
+	return u.S3ReleaseKey
+}
@@ -0,0 +1,67 @@
+package s3update
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestAutoUpdateLatestKey(t *testing.T) {
+	const latestKey = "releases/latest/{{OS}}-{{ARCH}}"
+	latest := "releases/latest/" + runtime.GOOS + "-" + runtime.GOARCH
+	release := []byte(exe("new binary"))
+	tests := []struct {
+		name     string
+		metadata map[string]string
+		// versionKey reads the version and release from their own keys
+		versionKey bool
+		want       string
+		wantErr    error
+	}{
+		{"metadata", map[string]string{metaVersionHeader: "v1.1.0", metaSHA256Header: sha256Hex(string(release))},
+			false, exe("new binary"), nil},
+		{"prefixed checksum", map[string]string{metaVersionHeader: "v1.1.0", metaSHA256Header: "SHA256:" + sha256Hex(string(release))},
+			false, exe("new binary"), nil},
+		{"wrong checksum", map[string]string{metaVersionHeader: "v1.1.0", metaSHA256Header: sha256Hex("tampered")},
+			false, exe("old binary"), ErrChecksumMismatch},
+		{"invalid version", map[string]string{metaVersionHeader: "latest"}, false, exe("old binary"), ErrInvalidRemoteVersion},
+		{"no metadata", nil, false, exe("old binary"), ErrNoRemoteVersion},
+		{"no metadata with version key", nil, true, exe("older binary"), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTestBucket(t)
+			b.publish("v1.0.5", []byte(exe("older binary")))
+			b.handle(latest, func(w http.ResponseWriter, r *http.Request) {
+				for k, v := range tt.metadata {
+					w.Header().Set(k, v)
+				}
+				http.ServeContent(w, r, latest, time.Time{}, bytes.NewReader(release))
+			})
+			target := newTarget(t, exe("old binary"))
+			u := b.updater(target)
+			u.LatestKey = latestKey
+			if !tt.versionKey {
+				u.S3VersionKey, u.S3ReleaseKey, u.ChecksumKey = "", "", ""
+			}
+
+			err := AutoUpdate(u)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got %v, want %v", err, tt.wantErr)
+			}
+			assertContents(t, target, tt.want)
+			if !b.served("HEAD " + latest) {
+				t.Errorf("%s not checked: %v", latest, b.requested())
+			}
+			if tt.want == exe("new binary") && !b.served("GET "+latest) {
+				t.Errorf("release not downloaded from %s: %v", latest, b.requested())
+			}
+			if tt.versionKey != b.served("GET VERSION") {
+				t.Errorf("got %v", b.requested())
+			}
+		})
+	}
+}
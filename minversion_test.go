@@ -0,0 +1,85 @@
+package s3update
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestAutoUpdateBelowMinVersion(t *testing.T) {
+	b := newTestBucket(t)
+	b.publish("v1.1.0", []byte(exe("new binary")))
+	b.put("MIN_VERSION", []byte("v1.0.5\n"))
+	target := newTarget(t, exe("old binary"))
+	u := b.updater(target)
+	u.MinVersionKey = "MIN_VERSION"
+	// versions no longer supported don't wait for the update window
+	u.UpdateWindow = UpdateWindow{Start: 22 * time.Hour, End: 6 * time.Hour, Location: time.UTC}
+	setClock(t, monday(0, 12*time.Hour))
+
+	if err := AutoUpdate(u); err != nil {
+		t.Fatal(err)
+	}
+	assertContents(t, target, exe("new binary"))
+}
+
+func TestAutoUpdateVersionTooOld(t *testing.T) {
+	b := newTestBucket(t)
+	b.publish("v1.1.0", []byte(exe("new binary")))
+	b.put("MIN_VERSION", []byte("v1.0.5\n"))
+	b.handle("mytool-v1.1.0", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	target := newTarget(t, exe("old binary"))
+	u := b.updater(target)
+	u.MinVersionKey, u.MaxRetries, u.RetryBackoff = "MIN_VERSION", 1, time.Millisecond
+
+	err := AutoUpdate(u)
+	var te *VersionTooOldError
+	if !errors.As(err, &te) || !errors.Is(err, ErrVersionTooOld) {
+		t.Fatalf("got %v, want a *VersionTooOldError", err)
+	}
+	if te.Current != "v1.0.0" || te.MinVersion != "v1.0.5" || !errors.Is(err, ErrDownloadFailed) {
+		t.Errorf("got %+v", te)
+	}
+	assertContents(t, target, exe("old binary"))
+
+	// the minimum version saved by the last check applies offline
+	b.Close()
+	if err := AutoUpdate(u); !errors.As(err, &te) || te.MinVersion != "v1.0.5" {
+		t.Fatalf("offline: got %v, want a *VersionTooOldError", err)
+	}
+}
+
+func TestAutoUpdateMinVersionYanked(t *testing.T) {
+	b := newTestBucket(t)
+	b.publish("v1.1.0", []byte(exe("new binary")))
+	b.put("MIN_VERSION", []byte("v1.0.5\n"))
+	b.put("YANKED", []byte("v1.1.0\n"))
+	target := newTarget(t, exe("old binary"))
+	u := b.updater(target)
+	u.MinVersionKey, u.YankedKey = "MIN_VERSION", "YANKED"
+
+	err := AutoUpdate(u)
+	var te *VersionTooOldError
+	if !errors.As(err, &te) || te.Err != nil {
+		t.Fatalf("got %v, want a *VersionTooOldError without cause", err)
+	}
+	assertContents(t, target, exe("old binary"))
+}
+
+func TestAutoUpdateMinVersionSemver(t *testing.T) {
+	b := newTestBucket(t)
+	b.publish("v1.10.0", []byte(exe("new binary")))
+	// below v1.10.0 as a version, above it as a string
+	b.put("MIN_VERSION", []byte("v1.9.0\n"))
+	target := newTarget(t, exe("old binary"))
+	u := b.updater(target)
+	u.CurrentVersion, u.MinVersionKey = "v1.10.0", "MIN_VERSION"
+
+	if err := AutoUpdate(u); err != nil {
+		t.Fatal(err)
+	}
+	assertContents(t, target, exe("old binary"))
+}
@@ -0,0 +1,106 @@
+package s3update
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// mirrorState remembers which of the bucket and Mirrors served the last request, tried first by the next ones
+type mirrorState struct {
+	sync.Mutex
+	working int
+}
+
+func (m *mirrorState) get() int {
+	if m == nil {
+		return 0
+	}
+	m.Lock()
+	defer m.Unlock()
+	return m.working
+}
+
+func (m *mirrorState) set(i int) {
+	if m == nil {
+		return
+	}
+	m.Lock()
+	m.working = i
+	m.Unlock()
+}
+
+// mirrorDo issues the request for the object at url to the bucket and then to every mirror in turn, moving on to
+// the next one when it can't be reached, times out or answers 5xx. The one that answered is tried first by the
+// following requests of the run. Mirrors are plain HTTP servers, requests to them aren't signed.
+func (u Updater) mirrorDo(ctx context.Context, method, url string, timeout time.Duration) (*http.Response, error) {
+	primary := u.ObjectURL("")
+	if !strings.HasPrefix(url, primary) {
+		// not in the bucket, e.g. an artifact URL listed by the manifest
+		return u.httpRetry(ctx, method, url, timeout)
+	}
+	key := url[len(primary):]
+	bases := append([]string{primary}, u.Mirrors...)
+	start := u.mirror.get()
+	var resp *http.Response
+	var err error
+	for i := range bases {
+		n := (start + i) % len(bases)
+		m, mirrorURL := u, url
+		if n > 0 {
+			m.UseAWSAuth, m.Signer = false, nil
+			mirrorURL = strings.TrimRight(bases[n], "/") + "/" + key
+		}
+		if i > 0 {
+			u.logger().Debugf("updater: trying %s", mirrorURL)
+		}
+		resp, err = m.httpRetry(ctx, method, mirrorURL, timeout)
+		if ctx.Err() != nil || !mirrorFailed(resp, err) {
+			if n > 0 {
+				u.logger().Debugf("updater: %s %s served by mirror %s", method, key, bases[n])
+			}
+			u.mirror.set(n)
+			return resp, err
+		}
+		if i < len(bases)-1 {
+			u.logger().Debugf("updater: %s failed: %s", mirrorURL, mirrorFailure(resp, err))
+			if resp != nil {
+				resp.Body.Close()
+			}
+		}
+	}
+	return resp, err
+}
+
+// mirrorFailed tells whether the next mirror should be tried given the outcome of a request
+func mirrorFailed(resp *http.Response, err error) bool {
+	if err != nil {
+		return retryable(resp, err)
+	}
+	return resp.StatusCode >= 500
+}
+
+func mirrorFailure(resp *http.Response, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return resp.Status
+}
+
+// checkBaseURL returns why raw can't be BaseURL or a mirror, nil when it can
+func (u Updater) checkBaseURL(raw string) error {
+	b, err := url.Parse(raw)
+	switch {
+	case err != nil || (b.Scheme != "http" && b.Scheme != "https") || b.Host == "":
+		return fmt.Errorf("must be an https URL: %s", raw)
+	case b.Scheme == "http" && !u.AllowInsecureHTTP:
+		return fmt.Errorf("must be an https URL unless AllowInsecureHTTP is set: %s", raw)
+	case b.RawQuery != "" || b.Fragment != "":
+		return fmt.Errorf("must not have a query nor fragment: %s", raw)
+	}
+	return nil
+}
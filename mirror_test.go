@@ -0,0 +1,103 @@
+package s3update
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// downServer returns a server answering every request with status, and the count of requests it got
+func downServer(t *testing.T, status int) (*httptest.Server, *int32) {
+	var n int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&n, 1)
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(s.Close)
+	return s, &n
+}
+
+// served reports whether b got request, written as returned by requested
+func (b *testBucket) served(request string) bool {
+	for _, r := range b.requested() {
+		if r == request {
+			return true
+		}
+	}
+	return false
+}
+
+func TestAutoUpdateMirrors(t *testing.T) {
+	primary, primaryRequests := downServer(t, http.StatusServiceUnavailable)
+	down, downRequests := downServer(t, http.StatusBadGateway)
+	mirror := newTestBucket(t)
+	mirror.publish("v1.1.0", []byte(exe("new binary")))
+	target := newTarget(t, exe("old binary"))
+	u := mirror.updater(target)
+	u.BaseURL = primary.URL
+	u.Mirrors = []string{down.URL, mirror.URL + "/"}
+	u.MaxRetries = 0
+
+	if err := AutoUpdate(u); err != nil {
+		t.Fatal(err)
+	}
+	assertContents(t, target, exe("new binary"))
+	// the mirror that answered serves the release and its checksum too
+	for _, want := range []string{"GET VERSION", "GET mytool-v1.1.0", "GET mytool-v1.1.0.sha256"} {
+		if !mirror.served(want) {
+			t.Errorf("%s not served by the mirror: %v", want, mirror.requested())
+		}
+	}
+	if p, d := atomic.LoadInt32(primaryRequests), atomic.LoadInt32(downRequests); p != 1 || d != 1 {
+		t.Errorf("the bucket got %d requests and the failing mirror %d, want 1 each", p, d)
+	}
+}
+
+func TestAutoUpdateMirrorsChecksum(t *testing.T) {
+	// the bucket fails once the version is read, its checksum must not vouch for the mirror's release
+	primary := newTestBucket(t)
+	primary.publish("v1.1.0", []byte(exe("new binary")))
+	primary.handle("mytool-v1.1.0", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	mirror := newTestBucket(t)
+	mirror.publish("v1.1.0", []byte(exe("tampered binary")))
+	target := newTarget(t, exe("old binary"))
+	u := primary.updater(target)
+	u.Mirrors = []string{mirror.URL}
+	u.MaxRetries = 0
+
+	if err := AutoUpdate(u); err != nil {
+		t.Fatal(err)
+	}
+	assertContents(t, target, exe("tampered binary"))
+	if primary.served("GET mytool-v1.1.0.sha256") {
+		t.Errorf("checksum read from the bucket: %v", primary.requested())
+	}
+	if !mirror.served("GET mytool-v1.1.0.sha256") {
+		t.Errorf("checksum not read from the mirror: %v", mirror.requested())
+	}
+}
+
+func TestAutoUpdateMirrorsNotFound(t *testing.T) {
+	// only failures of the server move on to the next mirror
+	primary, _ := downServer(t, http.StatusNotFound)
+	mirror := newTestBucket(t)
+	mirror.publish("v1.1.0", []byte(exe("new binary")))
+	target := newTarget(t, exe("old binary"))
+	u := mirror.updater(target)
+	u.BaseURL = primary.URL
+	u.Mirrors = []string{mirror.URL}
+
+	err := AutoUpdate(u)
+	var de *DownloadError
+	if !errors.As(err, &de) || de.StatusCode != http.StatusNotFound {
+		t.Fatalf("got %v, want a 404", err)
+	}
+	if n := len(mirror.requested()); n != 0 {
+		t.Errorf("the mirror got %d requests", n)
+	}
+	assertContents(t, target, exe("old binary"))
+}
@@ -0,0 +1,66 @@
+package s3update
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+const (
+	// defaultNotesLines is how many lines of release notes are printed after an update by default
+	defaultNotesLines = 20
+	// maxNotesSize caps the release notes read from NotesKey
+	maxNotesSize = 64 << 10
+)
+
+// notesURL returns the URL of the release notes, empty when they aren't published
+func notesURL(u Updater, version string) string {
+	if u.NotesKey == "" {
+		return ""
+	}
+	return generateURL(u, u.NotesKey, version)
+}
+
+// releaseNotes returns the notes of the release described by info, empty when there are none.
+// Failing to fetch them is only logged, as it mustn't fail the update.
+func releaseNotes(ctx context.Context, u Updater, info *UpdateInfo) string {
+	if info.Notes != "" || info.NotesURL == "" {
+		return info.Notes
+	}
+	resp, err := u.httpGet(ctx, info.NotesURL)
+	if err != nil {
+		u.logger().Debugf("updater: fetching release notes: %s", err)
+		return ""
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		u.logger().Debugf("updater: fetching release notes: %s", u.statusError(info.NotesURL, resp))
+		return ""
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxNotesSize))
+	if err != nil {
+		u.logger().Debugf("updater: reading release notes: %s", err)
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// showNotes records the release notes of the update described by info in res and prints their first NotesLines lines
+func showNotes(ctx context.Context, u Updater, info *UpdateInfo, res *UpdateResult) {
+	res.Notes = releaseNotes(ctx, u, info)
+	if res.Notes == "" || u.NotesLines < 0 {
+		return
+	}
+	max := u.NotesLines
+	if max == 0 {
+		max = defaultNotesLines
+	}
+	lines := strings.Split(res.Notes, "\n")
+	if len(lines) > max {
+		lines = append(lines[:max], fmt.Sprintf("... (%d more lines)", len(lines)-max))
+	}
+	u.logger().Infof("what's new in %s:\n%s", info.RemoteVersion, strings.Join(lines, "\n"))
+}
@@ -0,0 +1,194 @@
+package s3update
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// minParallelSize is the smallest release downloaded in parts, smaller ones being streamed
+const minParallelSize = 4 << 20
+
+// errRangeIgnored is returned by downloadParts when the server answers range requests with the whole file
+var errRangeIgnored = errors.New("the range request got the whole file")
+
+// parallelDownload tells whether the release of the given size is downloaded in Concurrency parts
+func (u Updater) parallelDownload(size int64, ranges bool) bool {
+	return u.Concurrency > 1 && ranges && size >= minParallelSize
+}
+
+// downloadParts downloads the size bytes of the release at url into f, split into Concurrency byte ranges fetched
+// concurrently and written at their offset. The first part failing cancels the others. errRangeIgnored is returned
+// when the server sends the whole file instead of a part, the release being downloaded in one request instead.
+func (u Updater) downloadParts(ctx context.Context, url string, f *os.File, size int64) (int64, error) {
+	if err := f.Truncate(size); err != nil {
+		return 0, fmt.Errorf("allocating %s: %w", f.Name(), err)
+	}
+	if u.MaxBytesPerSecond > 0 && u.limiter == nil {
+		u.limiter = newRateLimiter(u.MaxBytesPerSecond)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// the parts feed a single progress reader, which is drawn as any other download
+	feed := newProgressFeed()
+	drawn := make(chan struct{})
+	go func() {
+		io.Copy(ioutil.Discard, u.progressReader(feed, size))
+		close(drawn)
+	}()
+
+	parts := int64(u.Concurrency)
+	partSize := (size + parts - 1) / parts
+	var wg sync.WaitGroup
+	var once sync.Once
+	var firstErr error
+	for start := int64(0); start < size; start += partSize {
+		end := start + partSize
+		if end > size {
+			end = size
+		}
+		wg.Add(1)
+		go func(start, end int64) {
+			defer wg.Done()
+			if err := u.downloadPart(ctx, url, f, start, end, feed); err != nil {
+				once.Do(func() {
+					firstErr = err
+					cancel()
+				})
+			}
+		}(start, end)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		feed.close(firstErr)
+	} else {
+		feed.close(io.EOF)
+	}
+	<-drawn
+	if firstErr != nil {
+		return feed.total(), firstErr
+	}
+	u.logger().Debugf("updater: downloaded %s in %d parts", url, (size+partSize-1)/partSize)
+	return size, nil
+}
+
+// downloadPart writes the bytes of the release at url from start up to end, excluded, at their offset in f
+func (u Updater) downloadPart(ctx context.Context, url string, f *os.File, start, end int64, feed *progressFeed) error {
+	hook := u.RequestHook
+	u.RequestHook = func(req *http.Request) {
+		if hook != nil {
+			hook(req)
+		}
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end-1))
+	}
+	resp, err := u.httpDownload(ctx, url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return &DownloadError{URL: url, Err: errRangeIgnored}
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		return u.statusError(url, resp)
+	}
+	n, err := io.Copy(&offsetWriter{f: f, off: start}, &feedReader{r: u.throttle(ctx, resp.Body), feed: feed})
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return &DownloadError{URL: url, Err: err}
+	}
+	if n != end-start {
+		return truncatedError(url, n, end-start)
+	}
+	return nil
+}
+
+// offsetWriter writes to f sequentially from off
+type offsetWriter struct {
+	f   *os.File
+	off int64
+}
+
+func (w *offsetWriter) Write(p []byte) (int, error) {
+	n, err := w.f.WriteAt(p, w.off)
+	w.off += int64(n)
+	return n, err
+}
+
+// feedReader reports the bytes read from r to feed
+type feedReader struct {
+	r    io.Reader
+	feed *progressFeed
+}
+
+func (r *feedReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.feed.add(n)
+	return n, err
+}
+
+// progressFeed is read as a stream of as many bytes as the parts of a download received, so that their progress
+// is reported by a single progress reader
+type progressFeed struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	pending  int64
+	received int64
+	err      error
+}
+
+func newProgressFeed() *progressFeed {
+	f := &progressFeed{}
+	f.cond = sync.NewCond(&f.mu)
+	return f
+}
+
+func (f *progressFeed) add(n int) {
+	if n <= 0 {
+		return
+	}
+	f.mu.Lock()
+	f.pending += int64(n)
+	f.received += int64(n)
+	f.mu.Unlock()
+	f.cond.Signal()
+}
+
+// close ends the stream with err, io.EOF once every part completed
+func (f *progressFeed) close(err error) {
+	f.mu.Lock()
+	f.err = err
+	f.mu.Unlock()
+	f.cond.Signal()
+}
+
+func (f *progressFeed) total() int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.received
+}
+
+func (f *progressFeed) Read(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for f.pending == 0 && f.err == nil {
+		f.cond.Wait()
+	}
+	if f.pending == 0 {
+		return 0, f.err
+	}
+	n := int64(len(p))
+	if n > f.pending {
+		n = f.pending
+	}
+	f.pending -= n
+	return int(n), nil
+}
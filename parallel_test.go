@@ -0,0 +1,87 @@
+package s3update
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// largeRelease returns a release downloaded in parts, whose bytes all differ from their neighbours
+func largeRelease() []byte {
+	binary := []byte(exe(""))
+	for i := 0; len(binary) < minParallelSize+12345; i++ {
+		binary = append(binary, byte(i%251))
+	}
+	return binary
+}
+
+func TestAutoUpdateParallel(t *testing.T) {
+	binary := largeRelease()
+	var mu sync.Mutex
+	var ranges []string
+	b := newTestBucket(t)
+	b.publish("v1.1.0", binary)
+	b.handle("mytool-v1.1.0", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			mu.Lock()
+			ranges = append(ranges, r.Header.Get("Range"))
+			mu.Unlock()
+		}
+		http.ServeContent(w, r, "mytool-v1.1.0", time.Time{}, bytes.NewReader(binary))
+	})
+	target := newTarget(t, exe("old binary"))
+	u := b.updater(target)
+	u.Concurrency = 4
+
+	res, err := AutoUpdateResult(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertContents(t, target, string(binary))
+	if res.BytesDownloaded != int64(len(binary)) {
+		t.Errorf("downloaded %d bytes, want %d", res.BytesDownloaded, len(binary))
+	}
+	partSize := (len(binary) + 3) / 4
+	var want []string
+	for start := 0; start < len(binary); start += partSize {
+		end := start + partSize
+		if end > len(binary) {
+			end = len(binary)
+		}
+		want = append(want, fmt.Sprintf("bytes=%d-%d", start, end-1))
+	}
+	sort.Strings(ranges)
+	sort.Strings(want)
+	if fmt.Sprint(ranges) != fmt.Sprint(want) {
+		t.Errorf("requested %v, want %v", ranges, want)
+	}
+}
+
+func TestAutoUpdateParallelRangeIgnored(t *testing.T) {
+	binary := largeRelease()
+	b := newTestBucket(t)
+	b.publish("v1.1.0", binary)
+	b.handle("mytool-v1.1.0", func(w http.ResponseWriter, r *http.Request) {
+		// ranges are advertised but every GET gets the whole file
+		r.Header.Del("Range")
+		http.ServeContent(w, r, "mytool-v1.1.0", time.Time{}, bytes.NewReader(binary))
+	})
+	target := newTarget(t, exe("old binary"))
+	u := b.updater(target)
+	u.Concurrency = 4
+	logger := &recordingLogger{}
+	u.Logger = logger
+
+	if err := AutoUpdate(u); err != nil {
+		t.Fatal(err)
+	}
+	assertContents(t, target, string(binary))
+	if !strings.Contains(logger.String(), "downloading it in one request") {
+		t.Errorf("no fallback logged: %s", logger.String())
+	}
+}
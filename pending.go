@@ -0,0 +1,63 @@
+package s3update
+
+import (
+	"context"
+	"time"
+)
+
+// pendingCheckInterval is how long the check that found a pending update is trusted by ApplyPending
+const pendingCheckInterval = 24 * time.Hour
+
+// ApplyPending installs the update found by a previous AutoUpdate run in DeferInstall mode, if any.
+// It's meant to be called right before the program exits: the binary is replaced but never re-run,
+// and nothing is fetched when no update is pending.
+func ApplyPending(u Updater) error {
+	return ApplyPendingContext(context.Background(), u)
+}
+
+// ApplyPendingContext is like ApplyPending but aborts the download when ctx is done.
+func ApplyPendingContext(ctx context.Context, u Updater) error {
+	if updatesDisabled() {
+		return nil
+	}
+	u = u.withEnv()
+	if err := u.Validate(); err != nil {
+		return err
+	}
+	pending := u.loadState().PendingVersion
+	if pending == "" {
+		return nil
+	}
+	// the check that found the update is reused rather than delaying the exit
+	if u.CheckInterval < pendingCheckInterval {
+		u.CheckInterval = pendingCheckInterval
+	}
+	info, err := checkForUpdate(ctx, u)
+	if err != nil {
+		return err
+	}
+	if info.UpdateAvailable {
+		u.logger().Infof("upgrading from %s to %s", info.CurrentVersion, info.RemoteVersion)
+		res := &UpdateResult{FromVersion: info.CurrentVersion, ToVersion: info.RemoteVersion}
+		if err := installUpdate(ctx, u, info, res); err != nil {
+			return err
+		}
+		showNotes(ctx, u, info, res)
+	} else {
+		u.logger().Debugf("updater: pending update to %s no longer applies", pending)
+	}
+	u.setPending("")
+	return nil
+}
+
+// setPending records version as the update to install on exit, none when empty
+func (u Updater) setPending(version string) {
+	st := u.loadState()
+	if st.PendingVersion == version {
+		return
+	}
+	st.PendingVersion = version
+	if err := u.saveState(st); err != nil {
+		u.logger().Debugf("updater: saving state: %s", err)
+	}
+}
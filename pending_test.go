@@ -0,0 +1,109 @@
+package s3update
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDeferInstall(t *testing.T) {
+	b := newTestBucket(t)
+	b.publish("v1.1.0", []byte(exe("new binary")))
+	target := newTarget(t, exe("old binary"))
+	u := b.updater(target)
+	u.DeferInstall = true
+
+	res, err := AutoUpdateResult(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Pending || res.Updated {
+		t.Errorf("got %+v", res)
+	}
+	assertContents(t, target, exe("old binary"))
+	if n := downloads(b); n != 0 {
+		t.Errorf("release downloaded %d times", n)
+	}
+	if v := u.loadState().PendingVersion; v != "v1.1.0" {
+		t.Errorf("pending version %q", v)
+	}
+
+	if err := ApplyPending(u); err != nil {
+		t.Fatal(err)
+	}
+	assertContents(t, target, exe("new binary"))
+	assertAlone(t, target)
+	if v := u.loadState().PendingVersion; v != "" {
+		t.Errorf("pending version %q left", v)
+	}
+	// the check that found the update was reused
+	checks := 0
+	for _, r := range b.requested() {
+		if r == "GET VERSION" {
+			checks++
+		}
+	}
+	if checks != 1 {
+		t.Errorf("version checked %d times: %v", checks, b.requested())
+	}
+
+	// nothing is pending anymore
+	n := len(b.requested())
+	if err := ApplyPending(u); err != nil {
+		t.Fatal(err)
+	}
+	if got := b.requested()[n:]; len(got) > 0 {
+		t.Errorf("requested %v", got)
+	}
+}
+
+func TestApplyPendingStale(t *testing.T) {
+	b := newTestBucket(t)
+	b.publish("v1.1.0", []byte(exe("new binary")))
+	target := newTarget(t, exe("old binary"))
+	u := b.updater(target)
+	u.setPending("v1.1.0")
+	// installed by other means meanwhile
+	u.CurrentVersion = "v1.1.0"
+
+	if err := ApplyPending(u); err != nil {
+		t.Fatal(err)
+	}
+	assertContents(t, target, exe("old binary"))
+	if n := downloads(b); n != 0 {
+		t.Errorf("release downloaded %d times", n)
+	}
+	if v := u.loadState().PendingVersion; v != "" {
+		t.Errorf("pending version %q left", v)
+	}
+}
+
+func TestApplyPendingCorruptState(t *testing.T) {
+	for name, doc := range map[string]string{
+		"garbage":   "not json",
+		"truncated": `{"pendingVersion": "v1.1`,
+		"empty":     "",
+	} {
+		t.Run(name, func(t *testing.T) {
+			b := newTestBucket(t)
+			b.publish("v1.1.0", []byte(exe("new binary")))
+			target := newTarget(t, exe("old binary"))
+			u := b.updater(target)
+			if err := os.MkdirAll(filepath.Dir(u.StateFile), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(u.StateFile, []byte(doc), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := ApplyPending(u); err != nil {
+				t.Fatal(err)
+			}
+			assertContents(t, target, exe("old binary"))
+			if got := b.requested(); len(got) > 0 {
+				t.Errorf("requested %v", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package s3update
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Platform identifies the target of a release, e.g. when publishing releases for other platforms than the running one
+type Platform struct {
+	OS   string
+	Arch string
+	// Arm is the ARM variant of 32-bit ARM releases, e.g. "7"
+	Arm string
+	// Libc is LibcGlibc or LibcMusl for linux releases whose keys use {{LIBC}}
+	Libc string
+}
+
+// ParsePlatform parses platforms written as <os>/<arch>, optionally followed by the ARM variant and C library,
+// e.g. "linux/amd64", "linux/arm/7" or "linux/amd64/musl"
+func ParsePlatform(s string) (Platform, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return Platform{}, fmt.Errorf("platform %q isn't written as <os>/<arch>", s)
+	}
+	p := Platform{OS: parts[0], Arch: parts[1]}
+	for _, part := range parts[2:] {
+		switch {
+		case part == LibcGlibc || part == LibcMusl:
+			p.Libc = part
+		case p.Arch == "arm" && p.Arm == "":
+			p.Arm = strings.TrimPrefix(strings.TrimPrefix(part, "arm"), "v")
+		default:
+			return Platform{}, fmt.Errorf("unknown %q in platform %q", part, s)
+		}
+	}
+	return p, nil
+}
+
+func (p Platform) String() string {
+	s := p.OS + "/" + p.Arch
+	if p.Arm != "" {
+		s += "/" + p.Arm
+	}
+	if p.Libc != "" {
+		s += "/" + p.Libc
+	}
+	return s
+}
+
+// PlatformKey expands the placeholders of keyTemplate like GenerateURL, but for the platform p, and returns the key
+func (u Updater) PlatformKey(keyTemplate, version string, p Platform) (string, error) {
+	return u.expandKey(keyTemplate, version, &p)
+}
+
+// libc returns the C library substituted to {{LIBC}}, which linux releases must tell
+func (p Platform) libc() (string, error) {
+	if p.OS != "linux" {
+		return "", nil
+	}
+	if p.Libc != "" {
+		return p.Libc, nil
+	}
+	return "", fmt.Errorf("no C library set for %s", p)
+}
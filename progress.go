@@ -0,0 +1,210 @@
+package s3update
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/mitchellh/ioprogress"
+)
+
+// defaultProgressInterval is how often the progress bar gets redrawn
+const defaultProgressInterval = 500 * time.Millisecond
+
+// Supported values for Updater.ProgressStyle
+const (
+	// ProgressDetailed draws a bar along with the percentage, transfer rate and remaining time. An output that
+	// isn't a terminal gets a line every 10% of the download or every 15s instead.
+	ProgressDetailed = "detailed"
+	// ProgressMinimal draws a bar along with the byte counts, on terminals only
+	ProgressMinimal = "minimal"
+)
+
+const (
+	// rateWindow is the period the transfer rate is averaged over
+	rateWindow = 5 * time.Second
+	// progressLineStep and progressLineInterval tell when outputs that aren't terminals get a progress line
+	progressLineStep     = 10
+	progressLineInterval = 15 * time.Second
+)
+
+// progressReader wraps r so that reading it reports progress to ProgressFunc, or draws a progress bar on
+// the configured output. r is returned as is when the progress bar is disabled.
+func (u Updater) progressReader(r io.Reader, size int64) io.Reader {
+	interval := u.ProgressInterval
+	if interval <= 0 {
+		interval = defaultProgressInterval
+	}
+	if u.ProgressFunc != nil {
+		return &callbackReader{r: r, total: size, fn: u.ProgressFunc, interval: interval}
+	}
+	if u.jsonOutput() {
+		fn := func(downloaded, total int64) {
+			u.emit(progressEvent{Event: "progress", Downloaded: downloaded, Total: total})
+		}
+		return &callbackReader{r: r, total: size, fn: fn, interval: interval}
+	}
+	w := u.ProgressOutput
+	if w == nil {
+		w = os.Stderr
+	}
+	terminal := isTerminal(w)
+	if u.DisableProgress || (!terminal && u.ProgressStyle == ProgressMinimal) {
+		return r
+	}
+	if !terminal {
+		return &callbackReader{r: r, total: size, fn: progressLines(w), interval: interval}
+	}
+	format := drawMinimal
+	if u.ProgressStyle != ProgressMinimal {
+		format = (&rateMeter{}).drawDetailed
+	}
+	return &ioprogress.Reader{
+		Reader:       r,
+		Size:         size,
+		DrawInterval: interval,
+		DrawFunc:     ioprogress.DrawTerminalf(w, format),
+	}
+}
+
+// progressBar is the width of the bar drawn on terminals
+const progressBar = 40
+
+// drawMinimal formats the bar of ProgressMinimal
+func drawMinimal(progress, total int64) string {
+	if total <= 0 {
+		return formatBytes(progress)
+	}
+	bar := ioprogress.DrawTextFormatBar(progressBar)
+	return fmt.Sprintf("%s %20s", bar(progress, total), ioprogress.DrawTextFormatBytes(progress, total))
+}
+
+// drawDetailed formats the bar of ProgressDetailed, only the bytes and rate being known when total is unknown
+func (m *rateMeter) drawDetailed(progress, total int64) string {
+	rate := m.rate(progress)
+	if total <= 0 {
+		return fmt.Sprintf("%10s %12s", formatBytes(progress), formatRate(rate))
+	}
+	bar := ioprogress.DrawTextFormatBar(progressBar)
+	return fmt.Sprintf("%s %3d%% %20s %12s  ETA %s", bar(progress, total), percent(progress, total),
+		ioprogress.DrawTextFormatBytes(progress, total), formatRate(rate), eta(progress, total, rate))
+}
+
+// progressLines returns a progress callback printing a line on w every progressLineStep percent of the download,
+// every progressLineInterval when its size is unknown or it's slow, and once it completes
+func progressLines(w io.Writer) func(downloaded, total int64) {
+	m := &rateMeter{}
+	lastStep, lastLine := 0, time.Now()
+	return func(downloaded, total int64) {
+		rate := m.rate(downloaded)
+		step := -1
+		if total > 0 {
+			step = percent(downloaded, total) / progressLineStep
+		}
+		if downloaded != total && step <= lastStep && time.Since(lastLine) < progressLineInterval {
+			return
+		}
+		lastStep, lastLine = step, time.Now()
+		if total <= 0 {
+			fmt.Fprintf(w, "downloaded %s, %s\n", formatBytes(downloaded), formatRate(rate))
+			return
+		}
+		fmt.Fprintf(w, "downloaded %d%% (%s of %s), %s, ETA %s\n", percent(downloaded, total), formatBytes(downloaded),
+			formatBytes(total), formatRate(rate), eta(downloaded, total, rate))
+	}
+}
+
+// rateMeter averages the transfer rate over the last rateWindow
+type rateMeter struct {
+	samples []progressSample
+}
+
+type progressSample struct {
+	at time.Time
+	n  int64
+}
+
+// rate records that n bytes were transferred so far, and returns the bytes per second
+func (m *rateMeter) rate(n int64) float64 {
+	now := time.Now()
+	m.samples = append(m.samples, progressSample{at: now, n: n})
+	for len(m.samples) > 2 && now.Sub(m.samples[1].at) >= rateWindow {
+		m.samples = m.samples[1:]
+	}
+	// the first reads say little about the rate to expect
+	first := m.samples[0]
+	if d := now.Sub(first.at); d >= time.Second {
+		return float64(n-first.n) / d.Seconds()
+	}
+	return 0
+}
+
+func percent(progress, total int64) int {
+	if progress >= total {
+		return 100
+	}
+	return int(progress * 100 / total)
+}
+
+// eta formats the time left to transfer total bytes at rate
+func eta(progress, total int64, rate float64) string {
+	if rate <= 0 {
+		return "--"
+	}
+	left := time.Duration(float64(total-progress) / rate * float64(time.Second))
+	return left.Round(time.Second).String()
+}
+
+func formatRate(rate float64) string {
+	return formatBytes(int64(rate)) + "/s"
+}
+
+// formatBytes formats n like the byte counts of the progress bar
+func formatBytes(n int64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	size, unit := float64(n), units[0]
+	for _, u := range units[1:] {
+		if size < 1000 {
+			break
+		}
+		size, unit = size/1000, u
+	}
+	return fmt.Sprintf("%.3g %s", size, unit)
+}
+
+// isTerminal reports whether w is a character device such as a terminal
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// callbackReader reports the progress of reads from r to fn at most every interval,
+// and one last time with downloaded == total once r is exhausted
+type callbackReader struct {
+	r        io.Reader
+	n, total int64
+	fn       func(downloaded, total int64)
+	interval time.Duration
+	last     time.Time
+	done     bool
+}
+
+func (c *callbackReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	if err == io.EOF {
+		if !c.done {
+			c.done = true
+			c.fn(c.n, c.n)
+		}
+	} else if now := time.Now(); now.Sub(c.last) >= c.interval {
+		c.last = now
+		c.fn(c.n, c.total)
+	}
+	return n, err
+}
@@ -0,0 +1,58 @@
+package s3update
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAutoUpdateProgressFunc(t *testing.T) {
+	tests := []struct {
+		name        string
+		binary      []byte
+		concurrency int
+	}{
+		{"stream", append([]byte(exe("")), bytes.Repeat([]byte{'x'}, 1<<20)...), 0},
+		{"parallel parts", largeRelease(), 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTestBucket(t)
+			b.publish("v1.1.0", tt.binary)
+			target := newTarget(t, exe("old binary"))
+			u := b.updater(target)
+			u.Concurrency = tt.concurrency
+			u.ProgressInterval = time.Nanosecond
+			var mu sync.Mutex
+			var calls [][2]int64
+			u.ProgressFunc = func(downloaded, total int64) {
+				mu.Lock()
+				calls = append(calls, [2]int64{downloaded, total})
+				mu.Unlock()
+			}
+
+			if err := AutoUpdate(u); err != nil {
+				t.Fatal(err)
+			}
+			assertContents(t, target, string(tt.binary))
+			mu.Lock()
+			defer mu.Unlock()
+			size := int64(len(tt.binary))
+			if len(calls) < 2 {
+				t.Fatalf("got %d progress calls", len(calls))
+			}
+			for i, c := range calls {
+				if c[1] != size {
+					t.Fatalf("call %d: total %d, want the Content-Length %d", i, c[1], size)
+				}
+				if i > 0 && c[0] < calls[i-1][0] {
+					t.Fatalf("call %d: downloaded %d after %d", i, c[0], calls[i-1][0])
+				}
+			}
+			if last := calls[len(calls)-1]; last[0] != size {
+				t.Errorf("last call reported %d bytes, want %d", last[0], size)
+			}
+		})
+	}
+}
@@ -0,0 +1,413 @@
+// Package publish uploads releases to the bucket s3update clients update from.
+//
+// Requests are signed with s3update.SignAWSRequest rather than sent through the AWS SDK, which would add its
+// dependency tree to every program importing s3update. Each object is uploaded by a single PUT request, which S3
+// accepts up to 5 GB.
+package publish
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/automato-io/s3update"
+)
+
+// Release describes the artifacts of a version and where clients look for them.
+// The bucket and key templates take the values the clients' Updater is configured with.
+type Release struct {
+	Version string
+	// Artifacts holds the release of every platform, e.g. "linux/amd64", as parsed by s3update.ParsePlatform
+	Artifacts map[string]io.Reader
+
+	Bucket    string
+	Region    string
+	Endpoint  string
+	PathStyle bool
+	// VersionKey, ReleaseKey and ChecksumKey are the key templates of Updater.S3VersionKey, S3ReleaseKey and ChecksumKey
+	VersionKey  string
+	ReleaseKey  string
+	ChecksumKey string
+	// ChecksumAlgorithm is either s3update.ChecksumMD5 (default) or s3update.ChecksumSHA256
+	ChecksumAlgorithm string
+	Channel           string
+	OSMap             map[string]string
+	ArchMap           map[string]string
+	TemplateVars      map[string]string
+
+	// Credentials sign the uploads, they're looked up like s3update.LoadAWSCredentials does when nil
+	Credentials *s3update.AWSCredentials
+	// HTTPClient performs the uploads, http.DefaultClient when nil
+	HTTPClient *http.Client
+	// Overwrite replaces artifacts already published, Publish returns ErrExists otherwise
+	Overwrite bool
+	// DryRun prints the keys that would be written to Output, os.Stdout when nil, without uploading anything
+	DryRun bool
+	Output io.Writer
+}
+
+// object is a file to upload
+type object struct {
+	key  string
+	file string
+	body []byte
+	// sha256 is the hex digest of the contents, which signs the upload
+	sha256 string
+	// exclusive objects are only created, not replaced
+	exclusive bool
+}
+
+// ErrExists is returned by Publish when an artifact of the release is already published and Overwrite isn't set
+var ErrExists = errors.New("release already published")
+
+// upload holds the keys of the artifact of a platform
+type upload struct {
+	platform    s3update.Platform
+	releaseKey  string
+	checksumKey string
+}
+
+// Publish uploads the artifacts of r along with their checksums, then the version object. The version is written
+// last so that clients never see a version whose artifacts aren't available yet.
+func Publish(ctx context.Context, r Release) error {
+	versionKey, uploads, err := r.plan()
+	if err != nil {
+		return err
+	}
+	if r.DryRun {
+		out := r.Output
+		if out == nil {
+			out = os.Stdout
+		}
+		for _, up := range uploads {
+			fmt.Fprintf(out, "s3://%s/%s\n", r.Bucket, up.releaseKey)
+		}
+		for _, key := range checksumKeys(uploads) {
+			fmt.Fprintf(out, "s3://%s/%s\n", r.Bucket, key)
+		}
+		fmt.Fprintf(out, "s3://%s/%s\n", r.Bucket, versionKey)
+		return nil
+	}
+
+	u := r.updater()
+	creds := r.Credentials
+	if creds == nil {
+		c, err := s3update.LoadAWSCredentials(ctx)
+		if err != nil {
+			return err
+		}
+		creds = &c
+	}
+	if !r.Overwrite {
+		for _, up := range uploads {
+			exists, err := r.exists(ctx, u, *creds, up.releaseKey)
+			if err != nil {
+				return err
+			}
+			if exists {
+				return fmt.Errorf("%w: %s", ErrExists, up.releaseKey)
+			}
+		}
+	}
+
+	var objects []object
+	defer func() {
+		for _, o := range objects {
+			if o.file != "" {
+				os.Remove(o.file)
+			}
+		}
+	}()
+	// platforms sharing a checksum key get a single file, with a line per artifact
+	sums := map[string]string{}
+	for _, up := range uploads {
+		artifact, sum, err := r.spool(up.releaseKey, r.Artifacts[up.platform.String()])
+		if err != nil {
+			return fmt.Errorf("reading the %s artifact: %w", up.platform, err)
+		}
+		// checked by exists already, unless another upload got there in between
+		artifact.exclusive = !r.Overwrite
+		objects = append(objects, artifact)
+		sums[up.checksumKey] += fmt.Sprintf("%s  %s\n", sum, path.Base(up.releaseKey))
+	}
+	for _, key := range checksumKeys(uploads) {
+		objects = append(objects, bytesObject(key, sums[key]))
+	}
+	objects = append(objects, bytesObject(versionKey, r.Version+"\n"))
+	for _, o := range objects {
+		if err := r.put(ctx, u, *creds, o); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// URLs returns the URLs clients fetch the release of r from, by platform
+func (r Release) URLs() (map[string]string, error) {
+	_, uploads, err := r.plan()
+	if err != nil {
+		return nil, err
+	}
+	u := r.updater()
+	urls := make(map[string]string, len(uploads))
+	for _, up := range uploads {
+		urls[up.platform.String()] = u.ObjectURL(up.releaseKey)
+	}
+	return urls, nil
+}
+
+// plan returns the key of the version object and the keys of the artifacts of r, sorted by platform
+func (r Release) plan() (string, []upload, error) {
+	if err := r.validate(); err != nil {
+		return "", nil, err
+	}
+	u := r.updater()
+	versionKey, err := u.PlatformKey(r.VersionKey, r.Version, s3update.Platform{})
+	if err != nil {
+		return "", nil, err
+	}
+	platforms, err := r.platforms()
+	if err != nil {
+		return "", nil, err
+	}
+	var uploads []upload
+	releases := map[string]string{}
+	for _, p := range platforms {
+		releaseKey, err := u.PlatformKey(r.ReleaseKey, r.Version, p)
+		if err != nil {
+			return "", nil, err
+		}
+		checksumKey, err := u.PlatformKey(r.ChecksumKey, r.Version, p)
+		if err != nil {
+			return "", nil, err
+		}
+		if other, ok := releases[releaseKey]; ok {
+			return "", nil, fmt.Errorf("%s and %s would both be uploaded at %s", other, p, releaseKey)
+		}
+		releases[releaseKey] = p.String()
+		uploads = append(uploads, upload{platform: p, releaseKey: releaseKey, checksumKey: checksumKey})
+	}
+	// a checksum key may be shared, e.g. SHA256SUMS, as long as clients can tell the lines of their artifact apart
+	names := map[[2]string]string{}
+	for _, up := range uploads {
+		if other, ok := releases[up.checksumKey]; ok {
+			return "", nil, fmt.Errorf("the checksum of %s would overwrite the %s artifact at %s", up.platform, other, up.checksumKey)
+		}
+		name := [2]string{up.checksumKey, path.Base(up.releaseKey)}
+		if other, ok := names[name]; ok {
+			return "", nil, fmt.Errorf("%s and %s artifacts are both listed as %s in %s", other, up.platform, name[1], name[0])
+		}
+		names[name] = up.platform.String()
+	}
+	return versionKey, uploads, nil
+}
+
+// validate checks r and that its key templates yield the URLs the clients of the running platform expect
+func (r Release) validate() error {
+	switch {
+	case r.Version == "":
+		return errors.New("no version set")
+	case r.Bucket == "":
+		return errors.New("no bucket set")
+	case r.VersionKey == "" || r.ReleaseKey == "" || r.ChecksumKey == "":
+		return errors.New("VersionKey, ReleaseKey and ChecksumKey must be set")
+	case len(r.Artifacts) == 0:
+		return errors.New("no artifacts")
+	}
+	if _, err := r.newHash(); err != nil {
+		return err
+	}
+	// a single version object is written for every platform
+	for _, name := range []string{"OS", "ARCH", "GOARM", "EXT", "LIBC"} {
+		if strings.Contains(r.VersionKey, "{{"+name+"}}") {
+			return fmt.Errorf("VersionKey %s depends on the platform", r.VersionKey)
+		}
+	}
+	return nil
+}
+
+// updater returns the configuration of the clients of r, which computes keys the way they do
+func (r Release) updater() s3update.Updater {
+	return s3update.Updater{
+		CurrentVersion:    r.Version,
+		S3Bucket:          r.Bucket,
+		S3Region:          r.Region,
+		Endpoint:          r.Endpoint,
+		PathStyle:         r.PathStyle,
+		S3VersionKey:      r.VersionKey,
+		S3ReleaseKey:      r.ReleaseKey,
+		ChecksumKey:       r.ChecksumKey,
+		ChecksumAlgorithm: r.ChecksumAlgorithm,
+		Channel:           r.Channel,
+		OSMap:             r.OSMap,
+		ArchMap:           r.ArchMap,
+		TemplateVars:      r.TemplateVars,
+	}
+}
+
+// platforms returns the platforms of the artifacts, sorted
+func (r Release) platforms() ([]s3update.Platform, error) {
+	names := make([]string, 0, len(r.Artifacts))
+	for name := range r.Artifacts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	platforms := make([]s3update.Platform, 0, len(names))
+	for _, name := range names {
+		p, err := s3update.ParsePlatform(name)
+		if err != nil {
+			return nil, err
+		}
+		if p.String() != name {
+			return nil, fmt.Errorf("platform %q should be written %q", name, p)
+		}
+		platforms = append(platforms, p)
+	}
+	return platforms, nil
+}
+
+func (r Release) newHash() (hash.Hash, error) {
+	switch r.ChecksumAlgorithm {
+	case "", s3update.ChecksumMD5:
+		return md5.New(), nil
+	case s3update.ChecksumSHA256:
+		return sha256.New(), nil
+	}
+	return nil, fmt.Errorf("unsupported checksum algorithm %q", r.ChecksumAlgorithm)
+}
+
+// checksumKeys returns the distinct checksum keys of uploads, in order
+func checksumKeys(uploads []upload) []string {
+	var keys []string
+	seen := map[string]bool{}
+	for _, up := range uploads {
+		if !seen[up.checksumKey] {
+			keys = append(keys, up.checksumKey)
+			seen[up.checksumKey] = true
+		}
+	}
+	return keys
+}
+
+func bytesObject(key, body string) object {
+	sum := sha256.Sum256([]byte(body))
+	return object{key: key, body: []byte(body), sha256: hex.EncodeToString(sum[:])}
+}
+
+// spool copies the artifact read from a to a temporary file, as uploads must tell their size,
+// and returns it as the object at key along with its checksum
+func (r Release) spool(key string, a io.Reader) (object, string, error) {
+	h, err := r.newHash()
+	if err != nil {
+		return object{}, "", err
+	}
+	f, err := ioutil.TempFile("", "s3update-publish-*")
+	if err != nil {
+		return object{}, "", err
+	}
+	defer f.Close()
+	payload := sha256.New()
+	if _, err := io.Copy(f, io.TeeReader(a, io.MultiWriter(h, payload))); err != nil {
+		os.Remove(f.Name())
+		return object{}, "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return object{}, "", err
+	}
+	o := object{key: key, file: f.Name(), sha256: hex.EncodeToString(payload.Sum(nil))}
+	return o, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// exists reports whether key is already in the bucket
+func (r Release) exists(ctx context.Context, u s3update.Updater, creds s3update.AWSCredentials, key string) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, u.ObjectURL(key), nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("X-Amz-Content-Sha256", emptySHA256)
+	resp, err := r.do(req, creds)
+	if err != nil {
+		return false, fmt.Errorf("checking %s: %w", key, err)
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound, http.StatusForbidden:
+		// S3 answers 403 for missing keys when the bucket can't be listed, the uploads being conditional anyway
+		return false, nil
+	}
+	return false, fmt.Errorf("checking %s: unexpected status %d", key, resp.StatusCode)
+}
+
+// emptySHA256 is the digest of an empty payload
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// do signs and sends req
+func (r Release) do(req *http.Request, creds s3update.AWSCredentials) (*http.Response, error) {
+	region := r.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	s3update.SignAWSRequest(req, creds, region, time.Now())
+	client := r.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return client.Do(req)
+}
+
+// put uploads o, signing its payload
+func (r Release) put(ctx context.Context, u s3update.Updater, creds s3update.AWSCredentials, o object) error {
+	var body io.Reader = bytes.NewReader(o.body)
+	size := int64(len(o.body))
+	if o.file != "" {
+		f, err := os.Open(o.file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		fi, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		body, size = f, fi.Size()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.ObjectURL(o.key), body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("X-Amz-Content-Sha256", o.sha256)
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if o.exclusive {
+		req.Header.Set("If-None-Match", "*")
+	}
+	resp, err := r.do(req, creds)
+	if err != nil {
+		return fmt.Errorf("uploading %s: %w", o.key, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusPreconditionFailed && o.exclusive {
+		return fmt.Errorf("%w: %s", ErrExists, o.key)
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("uploading %s: unexpected status %d: %s", o.key, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
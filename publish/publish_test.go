@@ -0,0 +1,176 @@
+package publish
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/automato-io/s3update"
+)
+
+// testBucket stores the objects uploaded to it, in order
+type testBucket struct {
+	*httptest.Server
+	mu      sync.Mutex
+	keys    []string
+	objects map[string]string
+	// denyHead answers 403 to every HEAD request, as S3 does for missing keys when the bucket can't be listed
+	denyHead bool
+}
+
+func newTestBucket(t *testing.T) *testBucket {
+	b := &testBucket{objects: map[string]string{}}
+	b.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.URL.Path, "/releases/")
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		switch r.Method {
+		case http.MethodHead:
+			if _, ok := b.objects[key]; b.denyHead {
+				w.WriteHeader(http.StatusForbidden)
+			} else if !ok {
+				w.WriteHeader(http.StatusNotFound)
+			}
+		case http.MethodPut:
+			if _, ok := b.objects[key]; ok && r.Header.Get("If-None-Match") == "*" {
+				w.WriteHeader(http.StatusPreconditionFailed)
+				return
+			}
+			data, _ := ioutil.ReadAll(r.Body)
+			b.keys = append(b.keys, key)
+			b.objects[key] = string(data)
+		}
+	}))
+	t.Cleanup(b.Close)
+	return b
+}
+
+func (b *testBucket) release(checksumKey string) Release {
+	return Release{
+		Version: "v1.1.0",
+		Artifacts: map[string]io.Reader{
+			"linux/amd64":  strings.NewReader("linux binary"),
+			"darwin/arm64": strings.NewReader("darwin binary"),
+		},
+		Bucket:            "releases",
+		Endpoint:          b.URL,
+		PathStyle:         true,
+		VersionKey:        "mytool/VERSION",
+		ReleaseKey:        "mytool/mytool-{{OS}}-{{ARCH}}",
+		ChecksumKey:       checksumKey,
+		ChecksumAlgorithm: s3update.ChecksumSHA256,
+		Credentials:       &s3update.AWSCredentials{AccessKeyID: "AKID", SecretAccessKey: "secret"},
+	}
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestPublishSharedChecksumKey(t *testing.T) {
+	b := newTestBucket(t)
+	if err := Publish(context.Background(), b.release("mytool/{{VERSION}}/SHA256SUMS")); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"mytool/mytool-darwin-arm64", "mytool/mytool-linux-amd64", "mytool/v1.1.0/SHA256SUMS", "mytool/VERSION"}
+	if got := strings.Join(b.keys, ", "); got != strings.Join(want, ", ") {
+		t.Errorf("uploaded %s, want %s", got, strings.Join(want, ", "))
+	}
+	sums := sha256Hex("darwin binary") + "  mytool-darwin-arm64\n" + sha256Hex("linux binary") + "  mytool-linux-amd64\n"
+	if got := b.objects["mytool/v1.1.0/SHA256SUMS"]; got != sums {
+		t.Errorf("got checksums %q, want %q", got, sums)
+	}
+	if got := b.objects["mytool/VERSION"]; got != "v1.1.0\n" {
+		t.Errorf("got version %q", got)
+	}
+}
+
+func TestPublishChecksumPerPlatform(t *testing.T) {
+	b := newTestBucket(t)
+	if err := Publish(context.Background(), b.release("mytool/mytool-{{OS}}-{{ARCH}}.sha256")); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := b.objects["mytool/mytool-linux-amd64.sha256"], sha256Hex("linux binary")+"  mytool-linux-amd64\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if n := len(b.keys); n != 5 {
+		t.Errorf("uploaded %d objects: %v", n, b.keys)
+	}
+}
+
+func TestPublishKeyCollisions(t *testing.T) {
+	b := newTestBucket(t)
+	r := b.release("mytool/SHA256SUMS")
+	r.ReleaseKey = "mytool/{{OS}}-{{ARCH}}/mytool"
+	// both artifacts would be listed as mytool
+	if err := Publish(context.Background(), r); err == nil || !strings.Contains(err.Error(), "both listed") {
+		t.Errorf("got %v", err)
+	}
+	r = b.release("mytool/mytool-linux-amd64")
+	if err := Publish(context.Background(), r); err == nil || !strings.Contains(err.Error(), "overwrite") {
+		t.Errorf("got %v", err)
+	}
+	r = b.release("mytool/SHA256SUMS")
+	r.ReleaseKey = "mytool/mytool"
+	if err := Publish(context.Background(), r); err == nil || !strings.Contains(err.Error(), "both be uploaded") {
+		t.Errorf("got %v", err)
+	}
+	if len(b.keys) > 0 {
+		t.Errorf("uploaded %v", b.keys)
+	}
+}
+
+func TestPublishDryRun(t *testing.T) {
+	b := newTestBucket(t)
+	var out bytes.Buffer
+	r := b.release("mytool/SHA256SUMS")
+	r.DryRun, r.Output = true, &out
+	if err := Publish(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+	want := "s3://releases/mytool/mytool-darwin-arm64\ns3://releases/mytool/mytool-linux-amd64\n" +
+		"s3://releases/mytool/SHA256SUMS\ns3://releases/mytool/VERSION\n"
+	if out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+	if len(b.keys) > 0 {
+		t.Errorf("uploaded %v", b.keys)
+	}
+}
+
+func TestPublishExisting(t *testing.T) {
+	for _, denyHead := range []bool{false, true} {
+		b := newTestBucket(t)
+		b.denyHead = denyHead
+		if err := Publish(context.Background(), b.release("mytool/SHA256SUMS")); err != nil {
+			t.Fatal(err)
+		}
+		uploaded := len(b.keys)
+
+		// found by HEAD, or by the conditional upload when HEAD is denied
+		r := b.release("mytool/SHA256SUMS")
+		r.Version = "v1.1.0-rebuilt"
+		if err := Publish(context.Background(), r); !errors.Is(err, ErrExists) {
+			t.Errorf("denyHead %v: got %v, want %v", denyHead, err, ErrExists)
+		}
+		if len(b.keys) != uploaded || b.objects["mytool/VERSION"] != "v1.1.0\n" {
+			t.Errorf("denyHead %v: uploaded %v", denyHead, b.keys[uploaded:])
+		}
+
+		r = b.release("mytool/SHA256SUMS")
+		r.Overwrite = true
+		if err := Publish(context.Background(), r); err != nil {
+			t.Errorf("denyHead %v: %v", denyHead, err)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package s3update
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// quarantineAttr is set on the files written by quarantined applications, making Gatekeeper check them when run
+const quarantineAttr = "com.apple.quarantine"
+
+// clearQuarantine removes the quarantine attribute of filename, if any
+func clearQuarantine(filename string) error {
+	path, err := syscall.BytePtrFromString(filename)
+	if err != nil {
+		return err
+	}
+	name, err := syscall.BytePtrFromString(quarantineAttr)
+	if err != nil {
+		return err
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_REMOVEXATTR, uintptr(unsafe.Pointer(path)), uintptr(unsafe.Pointer(name)), 0)
+	if errno != 0 && errno != syscall.ENOATTR {
+		return errno
+	}
+	return nil
+}
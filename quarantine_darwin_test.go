@@ -0,0 +1,61 @@
+package s3update
+
+import (
+	"path/filepath"
+	"syscall"
+	"testing"
+	"unsafe"
+)
+
+// xattr sets the extended attribute name of filename to value, or reads it when value is nil, returning its size
+func xattr(t *testing.T, filename, name string, value []byte) (int, error) {
+	t.Helper()
+	path, err := syscall.BytePtrFromString(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attr, err := syscall.BytePtrFromString(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value == nil {
+		n, _, errno := syscall.Syscall6(syscall.SYS_GETXATTR, uintptr(unsafe.Pointer(path)), uintptr(unsafe.Pointer(attr)), 0, 0, 0, 0)
+		if errno != 0 {
+			return 0, errno
+		}
+		return int(n), nil
+	}
+	_, _, errno := syscall.Syscall6(syscall.SYS_SETXATTR, uintptr(unsafe.Pointer(path)), uintptr(unsafe.Pointer(attr)),
+		uintptr(unsafe.Pointer(&value[0])), uintptr(len(value)), 0, 0)
+	if errno != 0 {
+		return 0, errno
+	}
+	return len(value), nil
+}
+
+func TestClearQuarantine(t *testing.T) {
+	filename := newTarget(t, exe("binary"))
+	if _, err := xattr(t, filename, quarantineAttr, []byte("0083;65f1b3a0;Safari;")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := xattr(t, filename, "com.example.kept", []byte("kept")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := clearQuarantine(filename); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := xattr(t, filename, quarantineAttr, nil); err != syscall.ENOATTR {
+		t.Errorf("quarantine attribute left: %v", err)
+	}
+	if n, err := xattr(t, filename, "com.example.kept", nil); err != nil || n != len("kept") {
+		t.Errorf("other attribute removed: %v", err)
+	}
+	// a file that isn't quarantined is left alone
+	if err := clearQuarantine(filename); err != nil {
+		t.Errorf("clearing again: %v", err)
+	}
+	if err := clearQuarantine(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("missing file cleared")
+	}
+}
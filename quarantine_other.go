@@ -0,0 +1,9 @@
+//go:build !darwin
+// +build !darwin
+
+package s3update
+
+// clearQuarantine does nothing, only macOS quarantines files
+func clearQuarantine(filename string) error {
+	return nil
+}
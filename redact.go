@@ -0,0 +1,81 @@
+package s3update
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// sensitiveParams are the query parameters carrying credentials in presigned S3 and GCS URLs, Azure SAS tokens and
+// API tokens, compared case-insensitively
+var sensitiveParams = map[string]bool{
+	"x-amz-signature":      true,
+	"x-amz-credential":     true,
+	"x-amz-security-token": true,
+	"x-goog-signature":     true,
+	"x-goog-credential":    true,
+	"signature":            true,
+	"sig":                  true,
+	"token":                true,
+	"access_token":         true,
+}
+
+// urlPattern matches the URLs embedded in messages
+var urlPattern = regexp.MustCompile(`https?://[^\s"'<>]+`)
+
+// redactURL returns rawURL with the values of its sensitive query parameters and its password replaced
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	if u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), "REDACTED")
+		}
+	}
+	if u.RawQuery != "" {
+		params := strings.Split(u.RawQuery, "&")
+		for i, p := range params {
+			name := p
+			if j := strings.IndexByte(p, '='); j >= 0 {
+				name = p[:j]
+			}
+			if n, err := url.QueryUnescape(name); err == nil && sensitiveParams[strings.ToLower(n)] {
+				params[i] = name + "=REDACTED"
+			}
+		}
+		u.RawQuery = strings.Join(params, "&")
+	}
+	return u.String()
+}
+
+// redactURLs redacts every URL of s
+func redactURLs(s string) string {
+	if !strings.Contains(s, "://") {
+		return s
+	}
+	return urlPattern.ReplaceAllStringFunc(s, func(m string) string {
+		// punctuation ending a sentence isn't part of the URL
+		trimmed := strings.TrimRight(m, ".,:;)")
+		return redactURL(trimmed) + m[len(trimmed):]
+	})
+}
+
+// redactingLogger redacts the URLs of the messages passed to l
+type redactingLogger struct {
+	l Logger
+}
+
+func (r redactingLogger) Debugf(format string, args ...interface{}) {
+	r.l.Debugf("%s", redactURLs(fmt.Sprintf(format, args...)))
+}
+
+func (r redactingLogger) Infof(format string, args ...interface{}) {
+	r.l.Infof("%s", redactURLs(fmt.Sprintf(format, args...)))
+}
+
+func (r redactingLogger) Errorf(format string, args ...interface{}) {
+	r.l.Errorf("%s", redactURLs(fmt.Sprintf(format, args...)))
+}
@@ -0,0 +1,47 @@
+package s3update
+
+import "testing"
+
+// stubRestart records the restarts instead of running them, as if the process had the given PID
+func stubRestart(t *testing.T, pid int) *[]string {
+	var restarted []string
+	origRestart, origGetpid := restart, getpid
+	restart = func(target string, args, env []string) error {
+		restarted = append(restarted, target)
+		return nil
+	}
+	getpid = func() int { return pid }
+	t.Cleanup(func() { restart, getpid = origRestart, origGetpid })
+	return &restarted
+}
+
+func TestRestartUpdated(t *testing.T) {
+	tests := []struct {
+		name      string
+		pid       int
+		u         Updater
+		want      error
+		restarted bool
+	}{
+		{"restart", 4242, Updater{}, nil, true},
+		{"another binary updated", 4242, Updater{TargetPath: "/opt/mytool/bin/mytool"}, nil, false},
+		{"no restart", 4242, Updater{NoRestart: true}, ErrRestartRequired, false},
+		{"no exit", 4242, Updater{NoExit: true}, ErrUpdated, false},
+		{"no exit nor restart", 4242, Updater{NoExit: true, NoRestart: true}, ErrRestartRequired, false},
+		{"PID 1", 1, Updater{}, ErrRestartRequired, false},
+		{"PID 1 forced", 1, Updater{ForceRestart: true}, nil, true},
+		{"PID 1 forced without restart", 1, Updater{ForceRestart: true, NoRestart: true}, ErrRestartRequired, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			restarted := stubRestart(t, tt.pid)
+			tt.u.Logger = NopLogger
+			if err := restartUpdated(tt.u, "/usr/local/bin/mytool"); err != tt.want {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+			if got := len(*restarted) > 0; got != tt.restarted {
+				t.Errorf("restarted %v, want %v", *restarted, tt.restarted)
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package s3update
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// Rollback restores the binary kept as backup by a previous update made with KeepBackup
+func Rollback(u Updater) error {
+	target, err := u.targetPath()
+	if err != nil {
+		return err
+	}
+	backup := target + ".bak"
+	fi, err := os.Stat(backup)
+	if os.IsNotExist(err) {
+		return ErrNoBackup
+	}
+	if err != nil {
+		return fmt.Errorf("checking %s: %w", backup, err)
+	}
+	if !fi.Mode().IsRegular() || (runtime.GOOS != "windows" && fi.Mode()&0111 == 0) {
+		return fmt.Errorf("backup %s is not an executable file", backup)
+	}
+
+	lock, _, err := acquireLock(target+".lock", u.LockTimeout)
+	if err != nil {
+		return err
+	}
+	defer lock.release()
+
+	if err := restoreBackup(backup, target); err != nil {
+		return fmt.Errorf("restoring %s: %w", backup, err)
+	}
+
+	st := u.loadState()
+	if st.BackupVersion != "" {
+		u.logger().Infof("rolled back to %s", st.BackupVersion)
+	} else {
+		u.logger().Infof("rolled back to %s", backup)
+	}
+	st.BackupVersion = ""
+	if err := u.saveState(st); err != nil {
+		u.logger().Debugf("updater: saving state: %s", err)
+	}
+	return nil
+}
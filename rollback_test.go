@@ -0,0 +1,24 @@
+package s3update
+
+import "testing"
+
+func TestRollback(t *testing.T) {
+	b := newTestBucket(t)
+	b.publish("v1.1.0", []byte(exe("new binary")))
+	target := newTarget(t, exe("old binary"))
+	u := b.updater(target)
+	u.KeepBackup = true
+
+	if err := Rollback(u); err != ErrNoBackup {
+		t.Fatalf("got %v, want %v", err, ErrNoBackup)
+	}
+	if err := AutoUpdate(u); err != nil {
+		t.Fatal(err)
+	}
+	assertContents(t, target+".bak", exe("old binary"))
+	if err := Rollback(u); err != nil {
+		t.Fatal(err)
+	}
+	assertContents(t, target, exe("old binary"))
+	assertAlone(t, target)
+}
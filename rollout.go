@@ -0,0 +1,34 @@
+package s3update
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"os"
+)
+
+// rolloutBucket places this install in [0, 1), the same value being returned on every run.
+// It derives from RolloutKey, or from the hostname and the path of the binary.
+func (u Updater) rolloutBucket() float64 {
+	key := u.RolloutKey
+	if key == "" {
+		host, _ := os.Hostname()
+		target, _ := u.targetPath()
+		key = host + "\x00" + target
+	}
+	sum := sha256.Sum256([]byte(key))
+	return float64(binary.BigEndian.Uint64(sum[:8])>>11) / (1 << 53)
+}
+
+// deferredByRollout reports whether this install is outside the fraction of installs
+// the manifest rolls the release out to
+func (u Updater) deferredByRollout(m *Manifest) bool {
+	if m == nil || m.Rollout == nil || *m.Rollout >= 1 {
+		return false
+	}
+	bucket := u.rolloutBucket()
+	if bucket < *m.Rollout {
+		return false
+	}
+	u.logger().Debugf("updater: %s deferred by staged rollout (bucket %.2f > %.2f)", m.Version, bucket, *m.Rollout)
+	return true
+}
@@ -0,0 +1,94 @@
+package s3update
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestRolloutBucket(t *testing.T) {
+	u := Updater{RolloutKey: "machine-1", CurrentVersion: "v1.0.0"}
+	bucket := u.rolloutBucket()
+	if bucket < 0 || bucket >= 1 {
+		t.Fatalf("bucket %v out of [0, 1)", bucket)
+	}
+	// the bucket doesn't move between runs nor versions, so that installs don't flip-flop
+	for _, version := range []string{"v1.0.0", "v1.1.0", "v2.0.0"} {
+		u.CurrentVersion = version
+		if got := u.rolloutBucket(); got != bucket {
+			t.Errorf("%s: got bucket %v, want %v", version, got, bucket)
+		}
+	}
+	if got := (Updater{RolloutKey: "machine-2"}).rolloutBucket(); got == bucket {
+		t.Error("machines share their bucket")
+	}
+
+	// the hostname and the path of the binary identify the install by default
+	a, b := Updater{TargetPath: newTarget(t, "binary")}, Updater{TargetPath: newTarget(t, "binary")}
+	if a.rolloutBucket() != a.rolloutBucket() {
+		t.Error("default bucket isn't stable")
+	}
+	if a.rolloutBucket() == b.rolloutBucket() {
+		t.Error("installs of the same machine share their bucket")
+	}
+
+	// buckets spread over [0, 1)
+	below := 0
+	for i := 0; i < 1000; i++ {
+		if (Updater{RolloutKey: fmt.Sprint("machine-", i)}).rolloutBucket() < 0.5 {
+			below++
+		}
+	}
+	if below < 400 || below > 600 {
+		t.Errorf("%d buckets of 1000 below 0.5", below)
+	}
+}
+
+func TestAutoUpdateRollout(t *testing.T) {
+	bucket := Updater{RolloutKey: "machine-1"}.rolloutBucket()
+	rollout := func(f float64) *float64 { return &f }
+	tests := []struct {
+		name      string
+		rollout   *float64
+		installed bool
+	}{
+		{"no rollout", nil, true},
+		{"everyone", rollout(1), true},
+		{"nobody", rollout(0), false},
+		{"just above the bucket", rollout(bucket + 0.001), true},
+		{"at the bucket", rollout(bucket), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTestBucket(t)
+			b.publishManifest("v1.1.0", []byte(exe("new binary")), Manifest{Rollout: tt.rollout})
+			target := newTarget(t, exe("old binary"))
+			logger := &recordingLogger{}
+			u := b.manifestUpdater(target)
+			u.RolloutKey, u.Logger, u.Verbose = "machine-1", logger, true
+
+			info, err := CheckForUpdate(u)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.UpdateAvailable != tt.installed || info.Deferred == tt.installed {
+				t.Errorf("got %+v", info)
+			}
+			res, err := AutoUpdateResult(u)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Updated != tt.installed {
+				t.Errorf("updated %v, want %v", res.Updated, tt.installed)
+			}
+			if tt.installed {
+				assertContents(t, target, exe("new binary"))
+				return
+			}
+			assertContents(t, target, exe("old binary"))
+			if want := fmt.Sprintf("deferred by staged rollout (bucket %.2f > %.2f)", bucket, *tt.rollout); !strings.Contains(logger.String(), want) {
+				t.Errorf("%q not logged:\n%s", want, logger)
+			}
+		})
+	}
+}
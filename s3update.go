@@ -1,23 +1,22 @@
 package s3update
 
 import (
-	"archive/tar"
-	"compress/gzip"
-	"crypto/md5"
+	"bytes"
+	"context"
+	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
 	"runtime"
 	"strings"
-	"syscall"
 	"time"
-
-	"github.com/mitchellh/ioprogress"
-	"golang.org/x/mod/semver"
 )
 
 // Updater holds configuration values provided by the program to be updated
@@ -28,220 +27,1533 @@ type Updater struct {
 	S3Bucket       string
 	S3ReleaseKey   string
 	Verbose        bool
+	// NoExit makes AutoUpdate return ErrUpdated after a successful update instead of re-running the updated binary
+	// and exiting the process
+	NoExit bool
+	// NoRestart skips re-running the updated binary, ErrRestartRequired is returned instead
+	NoRestart bool
+	// RestartArgs are the arguments the updated binary is re-run with, those of the running process when nil.
+	// The program name is always the path of the updated binary.
+	RestartArgs []string
+	// RestartEnv is the environment the updated binary is re-run with, that of the running process when nil
+	RestartEnv []string
+	// MarkRestart sets S3UPDATE_RESTARTED=1 in the environment of the re-run binary, which Restarted reports
+	MarkRestart bool
+	// ForceRestart re-runs the updated binary even when it runs as PID 1, e.g. in a container, where
+	// ErrRestartRequired is otherwise returned so that the orchestrator restarts it
+	ForceRestart bool
+	// TargetPath is the binary to keep up to date, the running executable when empty.
+	// A binary other than the running one doesn't get restarted once updated.
+	TargetPath string
+	// SymlinkMode tells how a binary installed as a symlink is updated: SymlinkFollow (default),
+	// SymlinkReplaceLink or SymlinkInstallVersioned
+	SymlinkMode string
+	// PatchKey enables delta updates: the template of the key of bsdiff patches created with CreatePatch,
+	// e.g. patches/{{FROM}}_{{TO}}_{{OS}}_{{ARCH}}.bsdiff where {{FROM}} and {{TO}} are the current and
+	// remote versions. The full release is downloaded when there's no patch or it can't be applied.
+	PatchKey string
+	// TempDir receives the download and extraction files, the target directory when empty so that the
+	// binary is replaced atomically. Files are copied to the target directory from another filesystem.
+	TempDir string
+	// FallbackDir receives the update when the target can't be replaced, e.g. ~/.local/bin for a
+	// binary installed in /usr/local/bin by root. The target is left untouched.
+	FallbackDir string
+	// CacheDir holds releases saved by Download, which are installed rather than downloaded again
+	CacheDir string
+	// ForceUpdate makes Download and InstallFromFile accept versions that aren't newer than CurrentVersion,
+	// and UpdateTo reinstall CurrentVersion or install a yanked version
+	ForceUpdate bool
+	// AllowDowngrade lets UpdateTo install versions older than CurrentVersion
+	AllowDowngrade bool
+	// InstallIfMissing installs the release even when the target executable doesn't exist anymore
+	InstallIfMissing bool
+	// InsecureSkipChecksum allows leaving ChecksumKey empty without setting PublicKey, releases then being installed
+	// without checksum, only verified by their size. Signatures are still verified when PublicKey is set.
+	InsecureSkipChecksum bool
+	// ChecksumAlgorithm is either ChecksumMD5 (default) or ChecksumSHA256.
+	// A checksum published as "sha256:<digest>" takes precedence.
+	ChecksumAlgorithm string
+	// HTTPClient performs every request, a shared client with sane connection timeouts is used when nil
+	HTTPClient *http.Client
+	// CAFile and CACertPEM hold PEM certificates trusted along with those of the system, e.g. the CA of a
+	// TLS-intercepting proxy
+	CAFile    string
+	CACertPEM []byte
+	// ProxyURL is the proxy every request goes through, e.g. http://proxy.example.com:3128, instead of the one
+	// set by HTTPS_PROXY, HTTP_PROXY and NO_PROXY
+	ProxyURL string
+	// AllowedHosts are the hosts, e.g. "cdn.example.com", requests may go to in addition to the bucket endpoint or
+	// BaseURL and the mirrors, such as those of artifact URLs listed by the manifest or redirect targets
+	AllowedHosts []string
+	// CheckTimeout bounds the request for the version, 3s by default. Failing to reach the bucket in time or to
+	// resolve its name makes the check return ErrCheckSkipped, which callers may ignore when offline.
+	CheckTimeout time.Duration
+	// RequestTimeout bounds the requests for the checksum and signature, 10s by default
+	RequestTimeout time.Duration
+	// DownloadTimeout bounds the download of the release, 60s by default. Raise it for large binaries.
+	DownloadTimeout time.Duration
+	// MaxBytesPerSecond caps the download rate of releases and patches, unlimited when 0. Raise DownloadTimeout
+	// accordingly for large binaries.
+	MaxBytesPerSecond int64
+	// Concurrency downloads releases of 4 MiB or more in as many byte ranges fetched in parallel, when the bucket
+	// advertises range support. Releases are streamed when it's 0 or 1, when they come from Source, or when the
+	// bucket answers the range requests with the whole file.
+	Concurrency int
+	// RequestHook is called on every request before it's sent, e.g. to add headers
+	RequestHook func(*http.Request)
+	// MaxRetries is how many times failed requests are retried, on connection failures and 429/5xx responses
+	MaxRetries int
+	// RetryBackoff is the delay before the first retry, doubled on each attempt up to 30s. Defaults to 500ms.
+	RetryBackoff time.Duration
+	// LockTimeout is how long to wait for another process updating the same binary,
+	// ErrUpdateInProgress is returned right away when zero
+	LockTimeout time.Duration
+	// KeepBackup keeps the replaced binary as <target>.bak so that Rollback can restore it
+	KeepBackup bool
+	// CleanupAge is how old the backups and temporary files left behind by interrupted updates get before
+	// AutoUpdate removes them, 24h by default. A negative CleanupAge keeps them.
+	CleanupAge time.Duration
+	// DisableEnvOverrides ignores the S3UPDATE_BUCKET, S3UPDATE_RELEASE_KEY, S3UPDATE_VERSION_KEY,
+	// S3UPDATE_CHECKSUM_KEY, S3UPDATE_ENDPOINT and S3UPDATE_VERBOSE environment variables, which override
+	// the corresponding fields otherwise
+	DisableEnvOverrides bool
+	// NoSync skips flushing the new binary and its directory to disk before the old binary is removed,
+	// for network filesystems where it's very slow. A power loss may then leave a truncated binary behind.
+	NoSync bool
+	// SkipBinaryValidation installs releases that aren't ELF, Mach-O or PE executables for the running
+	// platform, such as scripts
+	SkipBinaryValidation bool
+	// VerifyCommand holds arguments the new binary is run with before being installed, e.g. {"--version"}.
+	// The update is aborted unless it exits successfully within VerifyTimeout (10s by default).
+	VerifyCommand []string
+	VerifyTimeout time.Duration
+	// PostInstallCommand is run with the path of the new binary appended before it replaces the running one,
+	// e.g. {"codesign", "--force", "--sign", "-"} to re-sign it on macOS. The update is aborted, leaving the
+	// running binary untouched, unless it exits successfully within VerifyTimeout.
+	PostInstallCommand []string
+	// AllowPrereleases installs remote versions such as v1.3.0-rc.1. Otherwise they're only installed over
+	// a prerelease of the same version, e.g. v1.3.0-rc.1 over v1.3.0-beta.2.
+	AllowPrereleases bool
+	// CompareVersions and ValidateVersion replace semantic versioning for other version schemes, e.g. dates.
+	// CompareVersions returns -1, 0 or +1 like semver.Compare.
+	CompareVersions func(local, remote string) int
+	ValidateVersion func(version string) error
+	// MinVersionKey is the key of an optional object holding the oldest version still supported, which may also be
+	// published by the manifest. AutoUpdate returns a *VersionTooOldError when running an older version can't be updated.
+	MinVersionKey string
+	// YankedKey is the key of an optional list of versions never to be installed, one per line.
+	// Versions may also be yanked by the manifest, or skipped locally by SkipVersions.
+	YankedKey    string
+	SkipVersions []string
+	// Confirm asks on the terminal before installing an update, declined versions aren't asked about again.
+	// ConfirmDefault is the answer when stdin isn't a terminal, and ConfirmPrompt may rephrase the question.
+	Confirm        bool
+	ConfirmDefault bool
+	ConfirmPrompt  func(*UpdateInfo) string
+	// UpdateWindow restricts when updates are installed, an update available outside of it is only reported.
+	// The S3UPDATE_IGNORE_WINDOW environment variable lifts the restriction.
+	UpdateWindow UpdateWindow
+	// DeferInstall makes AutoUpdate only check for updates, an available one is installed by ApplyPending
+	// when the program exits rather than delaying its start. Versions no longer supported are installed right away.
+	DeferInstall bool
+	// RolloutKey identifies the install for staged rollouts, the hostname and path of the binary are used when empty
+	RolloutKey string
+	// ListPrefix restricts the keys listed by ListVersions, the part of S3ReleaseKey before the version by default
+	ListPrefix string
+	// NotesKey is the key template of the release notes, e.g. notes/{{VERSION}}.md, which may also be published by
+	// the manifest. Their first NotesLines lines (20 by default, none when negative) are printed after an update.
+	NotesKey   string
+	NotesLines int
+	// ManifestMode reads S3VersionKey as a JSON Manifest listing the artifact and checksum of every platform
+	ManifestMode bool
+	// LatestKey is the key template of a release always replaced by the latest one, e.g.
+	// "releases/latest/{{OS}}-{{ARCH}}.tgz", whose version and SHA-256 checksum are read from its "version" and
+	// "sha256" metadata with a HEAD request. S3VersionKey and ChecksumKey are only read when it has no version
+	// metadata, and the release is downloaded from LatestKey unless S3ReleaseKey is set.
+	LatestKey string
+	// CheckInterval skips the remote version check when a previous run did it less than CheckInterval ago.
+	// Checks are conditional anyway, the version object being downloaded only when its ETag changed.
+	CheckInterval time.Duration
+	// ForceCheck ignores CheckInterval, as does setting S3UPDATE_FORCE_CHECK
+	ForceCheck bool
+	// StateFile is where the check results are stored, <user cache dir>/<binary>/s3update.json by default
+	StateFile string
+	// HistorySize is how many update attempts History returns, 100 by default. They're recorded next to StateFile,
+	// in s3update.history.jsonl by default. A negative value disables the history.
+	HistorySize int
+	// UseAWSAuth signs the requests to the bucket with AWS credentials from the environment, the shared
+	// credentials file or the instance role, for private buckets
+	UseAWSAuth bool
+	// Signer replaces the built-in AWS signature when set, and is only called for the requests to the bucket
+	Signer func(*http.Request) error
+	// S3Region is the region of the bucket, used to address the regional endpoint and to sign requests.
+	// Requests redirected by S3 to another region are retried against that region.
+	S3Region string
+	// DualStack addresses the IPv4/IPv6 dualstack endpoint of S3Region
+	DualStack bool
+	// Endpoint is the base URL of an S3-compatible service (e.g. "https://minio.internal:9000"), AWS is used when empty
+	Endpoint string
+	// PathStyle builds URLs as <endpoint>/<bucket>/<key> instead of <bucket>.<endpoint host>/<key>
+	PathStyle bool
+	// BaseURL serves the keys as <BaseURL>/<key> in place of the bucket, e.g. "https://downloads.example.com/mytool"
+	// for a CDN, R2 public domain or internal mirror. S3Bucket, S3Region, Endpoint and PathStyle aren't used then.
+	BaseURL string
+	// AllowInsecureHTTP accepts an http:// BaseURL, for mirrors on trusted internal networks
+	AllowInsecureHTTP bool
+	// Mirrors are base URLs serving the keys as <mirror>/<key>, tried in turn when the bucket or the previous mirror
+	// can't be reached, times out or answers 5xx. Checksums and signatures are fetched the same way, PublicKey
+	// guarding against a malicious mirror.
+	Mirrors []string
+	// Source fetches releases from elsewhere than the bucket, e.g. a GitHubSource. S3Bucket, S3VersionKey,
+	// S3ReleaseKey and ChecksumKey aren't required then, and the other keys are still read from the bucket when set.
+	Source Source
+	// Channel is substituted to {{CHANNEL}} in keys, e.g. "beta". It can be overridden with S3UPDATE_CHANNEL.
+	Channel string
+	// OSMap and ArchMap rename runtime.GOOS and runtime.GOARCH when substituted to {{OS}} and {{ARCH}},
+	// e.g. {"darwin": "macos"} and {"amd64": "x86_64"}
+	OSMap   map[string]string
+	ArchMap map[string]string
+	// ArmVariant overrides the ARM variant detected from the CPU and substituted to {{GOARM}}, e.g. "6" for armv6
+	ArmVariant string
+	// Libc overrides the C library detected on linux and substituted to {{LIBC}}, LibcGlibc or LibcMusl.
+	// DefaultLibc is used instead when detection fails, which is otherwise an error.
+	Libc        string
+	DefaultLibc string
+	// TemplateVars defines extra placeholders of keys, e.g. {"EDITION": "pro"} for {{EDITION}}
+	TemplateVars map[string]string
+	// Logger receives every message, they go to stderr when nil. Verbose enables the debug ones of the default logger.
+	// Signatures, tokens and passwords in the URLs of messages and of *DownloadError are redacted.
+	Logger Logger
+	// OutputFormat is OutputText (default) or OutputJSON, which replaces the messages of the default logger and the
+	// progress bar with JSON events written to Output, os.Stderr when nil
+	OutputFormat string
+	Output       io.Writer
+	// Events is notified of the outcome of every stage of updates
+	Events Events
+	// ProgressOutput receives the download progress bar, os.Stderr when nil
+	ProgressOutput io.Writer
+	// ProgressStyle is ProgressDetailed (default), which prints progress lines when ProgressOutput isn't a
+	// terminal, or ProgressMinimal, which draws nothing then
+	ProgressStyle string
+	// DisableProgress never draws the progress bar
+	DisableProgress bool
+	// ProgressInterval is how often the progress is reported, 500ms when zero
+	ProgressInterval time.Duration
+	// ProgressFunc replaces the progress bar when set. total is -1 when unknown, and a last call is made
+	// with downloaded == total once the download completes.
+	ProgressFunc func(downloaded, total int64)
+	// BinaryName is the name of the executable within release archives, the current executable name by default.
+	// An archive holding a single file is accepted whatever its name unless BinaryName is set.
+	BinaryName string
+	// ChecksumOf tells what the published checksum covers: the downloaded file (ChecksumOfArchive, default)
+	// or the executable once extracted from its archive (ChecksumOfBinary)
+	ChecksumOf string
+	// PublicKey enables signature verification of releases, it holds an ed25519 public key, raw or PEM encoded.
+	// ChecksumKey may then be left empty, releases being verified by their signature only.
+	PublicKey []byte
+	// SignatureKey is the key template of the detached signature produced by SignRelease
+	SignatureKey string
+
+	// mirror and limiter are shared by the copies of the Updater made during a run
+	mirror  *mirrorState
+	limiter *rateLimiter
 }
 
-// validate ensures every required fields is correctly set. Otherwise and error is returned.
-func (u Updater) validate() error {
-	if u.CurrentVersion == "" {
-		return fmt.Errorf("no version set")
+// Validate checks every field of the Updater, returning a *ValidationError listing all the problems found, each a
+// *ConfigError, so that they can be fixed at once. AutoUpdate and the other functions call it first.
+func (u Updater) Validate() error {
+	var errs []error
+	invalid := func(field string, err error) {
+		errs = append(errs, &ConfigError{Field: field, Err: err})
+	}
+	required := []struct {
+		field, value string
+	}{
+		{"CurrentVersion", u.CurrentVersion},
+		{"S3Bucket", u.S3Bucket},
+		{"S3ReleaseKey", u.S3ReleaseKey},
+		{"S3VersionKey", u.S3VersionKey},
+	}
+	for _, r := range required {
+		if r.value != "" {
+			continue
+		}
+		switch {
+		case r.field == "CurrentVersion":
+		// the bucket isn't read when releases come from Source, nor addressed with BaseURL
+		case u.Source != nil, r.field == "S3Bucket" && u.BaseURL != "":
+			continue
+		// LatestKey tells the version and serves the release
+		case u.LatestKey != "" && r.field != "S3Bucket":
+			continue
+		}
+		invalid(r.field, ErrMissingField)
+	}
+	if u.CurrentVersion != "" {
+		if err := u.validateVersion(u.normalize(u.CurrentVersion)); err != nil {
+			invalid("CurrentVersion", fmt.Errorf("%w: %v: %v", ErrInvalidLocalVersion, u.CurrentVersion, err))
+		}
+	}
+	if u.Source != nil && u.ManifestMode {
+		invalid("ManifestMode", errors.New("the manifest is read from the bucket, which Source replaces"))
+	}
+	if u.LatestKey != "" && (u.ManifestMode || u.Source != nil) {
+		invalid("LatestKey", errors.New("the version is read from LatestKey, which ManifestMode and Source replace"))
+	}
+	// releases must be verified by a checksum, a signature or both, unless explicitly installed unverified
+	if u.ChecksumKey == "" && len(u.PublicKey) == 0 && !u.ManifestMode && !u.InsecureSkipChecksum && u.Source == nil &&
+		u.LatestKey == "" {
+		invalid("ChecksumKey", fmt.Errorf("%w, set PublicKey to verify signatures only or InsecureSkipChecksum to "+
+			"install releases unverified", ErrMissingField))
+	}
+	if _, err := newHash(u.ChecksumAlgorithm); err != nil {
+		invalid("ChecksumAlgorithm", err)
 	}
-	if u.S3Bucket == "" {
-		return fmt.Errorf("no bucket set")
+	switch u.SymlinkMode {
+	case "", SymlinkFollow, SymlinkReplaceLink, SymlinkInstallVersioned:
+	default:
+		invalid("SymlinkMode", fmt.Errorf("unsupported mode %q", u.SymlinkMode))
 	}
-	if u.S3ReleaseKey == "" {
-		return fmt.Errorf("no s3ReleaseKey set")
+	if u.OutputFormat != "" && u.OutputFormat != OutputText && u.OutputFormat != OutputJSON {
+		invalid("OutputFormat", fmt.Errorf("unsupported format %q", u.OutputFormat))
 	}
-	if u.S3VersionKey == "" {
-		return fmt.Errorf("no s3VersionKey set")
+	if u.ProgressStyle != "" && u.ProgressStyle != ProgressDetailed && u.ProgressStyle != ProgressMinimal {
+		invalid("ProgressStyle", fmt.Errorf("unsupported style %q", u.ProgressStyle))
+	}
+	if u.ChecksumOf != "" && u.ChecksumOf != ChecksumOfArchive && u.ChecksumOf != ChecksumOfBinary {
+		invalid("ChecksumOf", fmt.Errorf("unsupported value %q", u.ChecksumOf))
+	}
+	if len(u.PublicKey) > 0 {
+		if u.SignatureKey == "" {
+			invalid("SignatureKey", fmt.Errorf("%w, as required by PublicKey", ErrMissingField))
+		}
+		if _, err := parsePublicKey(u.PublicKey); err != nil {
+			invalid("PublicKey", err)
+		}
+	}
+	keys := []struct {
+		field, template string
+	}{
+		{"S3VersionKey", u.S3VersionKey},
+		{"S3ReleaseKey", u.S3ReleaseKey},
+		{"LatestKey", u.LatestKey},
+		{"ChecksumKey", u.ChecksumKey},
+		{"SignatureKey", u.SignatureKey},
+		{"PatchKey", u.PatchKey},
+		{"YankedKey", u.YankedKey},
+		{"MinVersionKey", u.MinVersionKey},
+		{"NotesKey", u.NotesKey},
+	}
+	for _, key := range keys {
+		if _, err := u.GenerateURL(key.template, ""); err != nil {
+			invalid(key.field, err)
+		}
+	}
+	if u.Endpoint != "" {
+		e, err := url.Parse(u.Endpoint)
+		if err != nil || (e.Scheme != "http" && e.Scheme != "https") || e.Host == "" {
+			invalid("Endpoint", fmt.Errorf("must be an http(s) URL: %s", u.Endpoint))
+		}
+	}
+	if u.BaseURL != "" {
+		if err := u.checkBaseURL(u.BaseURL); err != nil {
+			invalid("BaseURL", err)
+		}
+		if u.Endpoint != "" {
+			invalid("Endpoint", errors.New("BaseURL replaces the bucket endpoint, set only one of them"))
+		}
+	}
+	for _, m := range u.Mirrors {
+		if err := u.checkBaseURL(m); err != nil {
+			invalid("Mirrors", err)
+		}
+	}
+	for _, h := range u.AllowedHosts {
+		if err := checkAllowedHost(h); err != nil {
+			invalid("AllowedHosts", err)
+		}
+	}
+	if u.customTransport() && u.HTTPClient != nil {
+		invalid("HTTPClient", errors.New("CAFile, CACertPEM and ProxyURL replace HTTPClient, configure its transport instead"))
+	}
+	if u.ProxyURL != "" {
+		if _, err := parseProxyURL(u.ProxyURL); err != nil {
+			invalid("ProxyURL", err)
+		}
+	}
+	if u.CAFile != "" || len(u.CACertPEM) > 0 {
+		if _, err := u.certPool(); err != nil {
+			field := "CAFile"
+			if u.CAFile == "" {
+				field = "CACertPEM"
+			}
+			invalid(field, err)
+		}
+	}
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
 	}
 	return nil
 }
 
 // AutoUpdate runs synchronously a verification to ensure the binary is up-to-date.
 // If a new version gets released, the download will happen automatically
-// It's possible to bypass this mechanism by setting the S3UPDATE_DISABLED environment variable.
+// It's possible to bypass this mechanism by setting the S3UPDATE_DISABLED environment variable to 1, true or yes,
+// ErrDisabled being returned then.
 func AutoUpdate(u Updater) error {
-	if os.Getenv("S3UPDATE_DISABLED") != "" {
-		fmt.Println("s3update: autoupdate disabled")
-		return nil
+	return AutoUpdateContext(context.Background(), u)
+}
+
+// AutoUpdateContext is like AutoUpdate but aborts the version check and download when ctx is done.
+// A cancelled download leaves the original binary untouched.
+func AutoUpdateContext(ctx context.Context, u Updater) error {
+	_, err := autoUpdate(ctx, u)
+	return err
+}
+
+// UpdateResult reports what an update run did
+type UpdateResult struct {
+	Updated         bool
+	FromVersion     string
+	ToVersion       string
+	BytesDownloaded int64
+	Duration        time.Duration
+	TargetPath      string
+	// Extracted is set when the executable was extracted from an archive
+	Extracted bool
+	// Patched is set when the executable was built from the previous one and a patch
+	Patched bool
+	// Notes are the release notes of the installed version, when published
+	Notes string
+	// Pending is set when an update is available but wasn't installed, being outside of Updater.UpdateWindow
+	// or left to ApplyPending by Updater.DeferInstall
+	Pending bool
+	// checksum is the SHA-256 checksum of the release downloaded as "sha256:<digest>", recorded by the history
+	checksum string
+	// signature is the verified signature of the release downloaded, saved by Download
+	signature []byte
+}
+
+// AutoUpdateResult is like AutoUpdate but never re-runs the updated binary nor exits the process, it returns the
+// outcome of the update instead.
+// ErrRestartRequired is still returned along the result when NoRestart is set or the process runs as PID 1.
+func AutoUpdateResult(u Updater) (*UpdateResult, error) {
+	return AutoUpdateResultContext(context.Background(), u)
+}
+
+// AutoUpdateResultContext is like AutoUpdateResult but aborts the version check and download when ctx is done.
+func AutoUpdateResultContext(ctx context.Context, u Updater) (*UpdateResult, error) {
+	u.NoExit = true
+	res, err := autoUpdate(ctx, u)
+	if err == ErrUpdated {
+		err = nil
+	}
+	return res, err
+}
+
+func autoUpdate(ctx context.Context, u Updater) (*UpdateResult, error) {
+	if updatesDisabled() {
+		u.logger().Debugf("updater: disabled by S3UPDATE_DISABLED")
+		u.events().CheckCompleted(u.CurrentVersion, "", ErrDisabled)
+		return &UpdateResult{FromVersion: u.CurrentVersion}, ErrDisabled
+	}
+
+	u = u.withEnv()
+	if u.TargetPath == "" {
+		if exe, ok := ephemeralExecutable(); ok {
+			u.logger().Debugf("updater: skipping auto update of %s, built by go test or go run", exe)
+			return &UpdateResult{FromVersion: u.CurrentVersion}, nil
+		}
+	}
+	if err := u.Validate(); err != nil {
+		u.logger().Errorf("s3update: %s - skipping auto update", err.Error())
+		u.emit(errorEvent{Event: "error", Message: err.Error()})
+		u.events().UpdateFailed("validate", err)
+		return nil, err
 	}
 
-	if err := u.validate(); err != nil {
-		fmt.Printf("s3update: %s - skipping auto update\n", err.Error())
+	removeStaleBackup(u)
+	removeLeftovers(u)
+
+	res, err := runAutoUpdate(ctx, u)
+	if err != nil && err != ErrUpdated && err != ErrRestartRequired {
+		u.emit(errorEvent{Event: "error", Message: err.Error()})
+	}
+	return res, err
+}
+
+// UpdateInfo describes the outcome of a version check
+type UpdateInfo struct {
+	CurrentVersion string
+	RemoteVersion  string
+	DownloadURL    string
+	ChecksumURL    string
+	NotesURL       string
+	SignatureURL   string
+	// Checksum is the expected checksum, as "<algorithm>:<digest>", when it's known without fetching ChecksumURL
+	Checksum string
+	// BaseChecksum is the checksum of the binary of CurrentVersion delta updates apply to, as
+	// "<algorithm>:<digest>", when listed by the manifest
+	BaseChecksum string
+	// MinVersion is the oldest version still supported, when published
+	MinVersion string
+	// Notes are the release notes, when published
+	Notes           string
+	UpdateAvailable bool
+	// Yanked is set when the remote version is newer but must not be installed, see Updater.YankedKey
+	Yanked bool
+	// Deferred is set when the remote version is newer but not rolled out to this install yet, see Manifest.Rollout
+	Deferred bool
+
+	// rawVersion is RemoteVersion as published, which Source is given
+	rawVersion string
+}
+
+// published returns the remote version as published
+func (i *UpdateInfo) published() string {
+	if i.rawVersion != "" {
+		return i.rawVersion
+	}
+	return i.RemoteVersion
+}
+
+// CheckForUpdate fetches the remote version without downloading anything.
+// The returned UpdateInfo can be handed over to ApplyUpdate.
+func CheckForUpdate(u Updater) (*UpdateInfo, error) {
+	return CheckForUpdateContext(context.Background(), u)
+}
+
+// CheckForUpdateContext is like CheckForUpdate but aborts the version check when ctx is done.
+func CheckForUpdateContext(ctx context.Context, u Updater) (*UpdateInfo, error) {
+	u = u.withEnv()
+	if err := u.Validate(); err != nil {
+		return nil, err
+	}
+	return checkForUpdate(ctx, u)
+}
+
+// LatestVersion returns the remote version, normalized, without downloading anything nor printing anything.
+// It fails with a *DownloadError or ErrCheckSkipped when the bucket can't be reached, and ErrInvalidRemoteVersion
+// when what's published isn't a version.
+func LatestVersion(u Updater) (string, error) {
+	return LatestVersionContext(context.Background(), u)
+}
+
+// LatestVersionContext is like LatestVersion but aborts the request when ctx is done.
+func LatestVersionContext(ctx context.Context, u Updater) (string, error) {
+	u = u.withEnv()
+	if err := u.Validate(); err != nil {
+		return "", err
+	}
+	if u.Logger == nil {
+		u.Logger = NopLogger
+	}
+	version, _, err := u.latestVersion(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	return u.normalize(version), nil
+}
+
+// ApplyUpdate downloads and installs the release described by info, as returned by CheckForUpdate.
+func ApplyUpdate(u Updater, info *UpdateInfo) error {
+	return ApplyUpdateContext(context.Background(), u, info)
+}
+
+// ApplyUpdateContext is like ApplyUpdate but aborts the download when ctx is done.
+func ApplyUpdateContext(ctx context.Context, u Updater, info *UpdateInfo) error {
+	u = u.withEnv()
+	if err := u.Validate(); err != nil {
 		return err
 	}
+	if info == nil || info.RemoteVersion == "" {
+		return fmt.Errorf("no update info provided")
+	}
+	if !info.UpdateAvailable {
+		return ErrUpToDate
+	}
+	return downloadUpdate(ctx, u, info, &UpdateResult{})
+}
+
+// checkForUpdate compares the local version against the remote one and composes the release URLs,
+// reporting the outcome to Events
+func checkForUpdate(ctx context.Context, u Updater) (*UpdateInfo, error) {
+	info, err := compareRemote(ctx, u)
+	if err != nil {
+		u.events().CheckCompleted(u.normalize(u.CurrentVersion), "", err)
+		return nil, err
+	}
+	u.events().CheckCompleted(info.CurrentVersion, info.RemoteVersion, nil)
+	u.emit(checkEvent{Event: "check", Current: info.CurrentVersion, Remote: info.RemoteVersion, Available: info.UpdateAvailable})
+	return info, nil
+}
+
+// compareRemote does the work of checkForUpdate
+func compareRemote(ctx context.Context, u Updater) (*UpdateInfo, error) {
+	localVersion := u.normalize(u.CurrentVersion)
+	if err := u.validateVersion(localVersion); err != nil {
+		return nil, fmt.Errorf("%w: %v: %v", ErrInvalidLocalVersion, u.CurrentVersion, err)
+	}
+	// keys are templated with the version as published, comparisons use its normalized form
+	rawVersion, manifest, minVersion, err := cachedRemoteVersion(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	info, err := describeRemote(u, localVersion, rawVersion, manifest, minVersion)
+	if err != nil {
+		return nil, err
+	}
+	if info.UpdateAvailable {
+		reason, err := yankReason(ctx, u, manifest, info.RemoteVersion)
+		if err != nil {
+			return nil, err
+		}
+		if reason != "" {
+			u.logger().Debugf("updater: not installing %s: %s", info.RemoteVersion, reason)
+			info.UpdateAvailable, info.Yanked = false, true
+		}
+		if u.YankedKey != "" {
+			u.recordYanked(info.RemoteVersion, info.Yanked)
+		}
+	}
+	deferRollout(u, info, manifest)
+	return info, nil
+}
+
+// describeRemote composes the release URLs of the remote version rawVersion, as published
+func describeRemote(u Updater, localVersion, rawVersion string, manifest *Manifest, minVersion string) (*UpdateInfo, error) {
+	remoteVersion := u.normalize(rawVersion)
+	info := &UpdateInfo{
+		CurrentVersion:  localVersion,
+		RemoteVersion:   remoteVersion,
+		DownloadURL:     u.artifactURL(rawVersion),
+		ChecksumURL:     u.checksumURL(rawVersion),
+		NotesURL:        notesURL(u, rawVersion),
+		SignatureURL:    signatureURL(u, rawVersion),
+		MinVersion:      u.normalize(minVersion),
+		UpdateAvailable: shouldUpdate(u, localVersion, remoteVersion),
+		rawVersion:      rawVersion,
+	}
+	if manifest != nil {
+		if err := applyManifest(u, info, manifest); err != nil {
+			return nil, err
+		}
+	}
+	return info, nil
+}
+
+// deferRollout withholds the update described by info from installs outside of the manifest rollout
+func deferRollout(u Updater, info *UpdateInfo, manifest *Manifest) {
+	// versions no longer supported are updated right away
+	supported := info.MinVersion == "" || u.compareVersions(info.CurrentVersion, info.MinVersion) >= 0
+	if info.UpdateAvailable && supported && u.deferredByRollout(manifest) {
+		info.UpdateAvailable, info.Deferred = false, true
+	}
+}
+
+// GenerateURL expands the placeholders of the key template keyTemplate for version and returns the URL of the key.
+// {{VERSION}} expands to the version as published, {{SEMVER}} to its "v" prefixed form, {{FROM}} to CurrentVersion
+// and {{TO}} to version. {{OS}} and {{ARCH}} expand to runtime.GOOS and runtime.GOARCH as mapped by OSMap and ArchMap,
+// {{GOARM}} to the ARM variant on 32-bit ARM, e.g. "7", and to nothing elsewhere. {{EXT}} expands to ".exe" on
+// windows and to nothing elsewhere, {{CHANNEL}} to the release channel. {{LIBC}} expands to LibcGlibc or LibcMusl
+// on linux and to nothing elsewhere. Any other placeholder must be defined by TemplateVars.
+func (u Updater) GenerateURL(keyTemplate, version string) (string, error) {
+	key, err := u.expandKey(keyTemplate, version, nil)
+	if err != nil {
+		return "", err
+	}
+	return u.ObjectURL(key), nil
+}
+
+// expandKey expands the placeholders of keyTemplate for version and the platform p, the running one when nil
+func (u Updater) expandKey(keyTemplate, version string, p *Platform) (string, error) {
+	vars := map[string]string{
+		"VERSION": version,
+		"SEMVER":  normalizeVersion(version),
+		"FROM":    u.CurrentVersion,
+		"TO":      version,
+		"OS":      mapName(u.OSMap, runtime.GOOS),
+		"ARCH":    mapName(u.ArchMap, runtime.GOARCH),
+		"GOARM":   u.armVariant(),
+		"EXT":     exeSuffix,
+		"CHANNEL": u.channel(),
+	}
+	libc := u.libc
+	if p != nil {
+		vars["OS"], vars["ARCH"], vars["GOARM"], vars["EXT"] = mapName(u.OSMap, p.OS), mapName(u.ArchMap, p.Arch), "", ""
+		if p.Arch == "arm" {
+			vars["GOARM"] = p.Arm
+		}
+		if p.OS == "windows" {
+			vars["EXT"] = ".exe"
+		}
+		libc = p.libc
+	}
+	for name, value := range u.TemplateVars {
+		if _, ok := vars[name]; !ok {
+			vars[name] = value
+		}
+	}
+	var unknown []string
+	var libcErr error
+	key := placeholder.ReplaceAllStringFunc(keyTemplate, func(p string) string {
+		name := p[2 : len(p)-2]
+		if name == "LIBC" {
+			// only detected when used
+			value, err := libc()
+			libcErr = err
+			return value
+		}
+		value, ok := vars[name]
+		if !ok {
+			unknown = append(unknown, p)
+		}
+		return value
+	})
+	if libcErr != nil {
+		return "", libcErr
+	}
+	if len(unknown) > 0 {
+		return "", fmt.Errorf("%w %s in %s", ErrUnknownPlaceholder, strings.Join(unknown, ", "), keyTemplate)
+	}
+	return key, nil
+}
+
+// placeholder matches the placeholders of key templates
+var placeholder = regexp.MustCompile(`\{\{[^{}]*\}\}`)
+
+// mapName returns the name m gives to name, name itself when not mapped
+func mapName(m map[string]string, name string) string {
+	if mapped, ok := m[name]; ok {
+		return mapped
+	}
+	return name
+}
+
+// generateURL is GenerateURL for the templates checked by validate, empty for an empty template rather than the
+// URL of the bucket itself
+func generateURL(u Updater, pathTemplate, version string) string {
+	if pathTemplate == "" {
+		return ""
+	}
+	keyURL, _ := u.GenerateURL(pathTemplate, version)
+	return keyURL
+}
+
+// channel returns the release channel, S3UPDATE_CHANNEL taking precedence over Updater.Channel
+func (u Updater) channel() string {
+	if c := os.Getenv("S3UPDATE_CHANNEL"); c != "" {
+		return c
+	}
+	return u.Channel
+}
+
+// ObjectURL returns the URL of key in the bucket, virtual-hosted style unless PathStyle is set, or under BaseURL
+func (u Updater) ObjectURL(key string) string {
+	if u.BaseURL != "" {
+		return strings.TrimRight(u.BaseURL, "/") + "/" + strings.TrimLeft(key, "/")
+	}
+	endpoint := "https://" + u.awsHost()
+	if u.Endpoint != "" {
+		endpoint = strings.TrimRight(u.Endpoint, "/")
+	}
+	e, err := url.Parse(endpoint)
+	if err != nil || u.PathStyle {
+		return endpoint + "/" + u.S3Bucket + "/" + key
+	}
+	e.Host = u.S3Bucket + "." + e.Host
+	return e.String() + "/" + key
+}
 
-	return runAutoUpdate(u)
+// awsHost returns the S3 service host, regional when S3Region is set
+func (u Updater) awsHost() string {
+	if u.DualStack {
+		return "s3.dualstack." + u.region() + ".amazonaws.com"
+	}
+	if u.S3Region != "" {
+		return "s3." + u.S3Region + ".amazonaws.com"
+	}
+	return "s3.amazonaws.com"
 }
 
-// generateURL composes the download or checksum URL depending on version, os and architecture
-func generateURL(bucket, pathTemplate, version string) string {
-	p := strings.Replace(pathTemplate, "{{VERSION}}", version, -1)
-	p = strings.Replace(p, "{{ARCH}}", runtime.GOARCH, -1)
-	p = strings.Replace(p, "{{OS}}", runtime.GOOS, -1)
-	return "https://" + bucket + ".s3.amazonaws.com/" + p
+// cachedRemoteVersion returns the remote version and minimum version seen by a previous run when it checked less
+// than CheckInterval ago, and fetches them otherwise. The minimum version is always saved, for runs that can't check.
+func cachedRemoteVersion(ctx context.Context, u Updater) (string, *Manifest, string, error) {
+	st := u.loadState()
+	if u.CheckInterval > 0 && !u.forceCheck() && time.Since(st.LastCheck) < u.CheckInterval &&
+		u.validateVersion(u.normalize(st.RemoteVersion)) == nil && ((st.Manifest != nil) == u.ManifestMode || u.LatestKey != "") {
+		u.logger().Debugf("updater: last checked at %s, using cached remote version %s", st.LastCheck.Format(time.RFC3339), st.RemoteVersion)
+		return st.RemoteVersion, st.Manifest, st.MinVersion, nil
+	}
+	remoteVersion, manifest, err := u.latestVersion(ctx, &st)
+	if err != nil {
+		return "", nil, "", err
+	}
+	minVersion := ""
+	if manifest != nil {
+		minVersion = manifest.MinVersion
+	}
+	if minVersion == "" && u.MinVersionKey != "" {
+		if minVersion, err = fetchMinVersion(ctx, u); err != nil {
+			return "", nil, "", err
+		}
+	}
+	// the check is saved even without CheckInterval, for ApplyPending and CheckCached
+	if u.Source != nil {
+		st.VersionURL, st.VersionETag, st.VersionLastModified = "", "", ""
+	}
+	st.LastCheck = time.Now()
+	st.RemoteVersion = remoteVersion
+	st.Manifest = manifest
+	st.MinVersion = minVersion
+	if err := u.saveState(st); err != nil {
+		u.logger().Debugf("updater: saving state: %s", err)
+	}
+	return remoteVersion, manifest, minVersion, nil
 }
 
-func fetchRemoteVersion(bucket string) (string, error) {
-	resp, err := http.Get("https://" + bucket + ".s3.amazonaws.com/VERSION")
+// fetchMinVersion reads the object at MinVersionKey, which may not exist
+func fetchMinVersion(ctx context.Context, u Updater) (string, error) {
+	minURL := generateURL(u, u.MinVersionKey, "")
+	resp, err := u.httpGet(ctx, minURL)
 	if err != nil {
 		return "", err
 	}
 	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusForbidden {
+		return "", nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", u.statusError(minURL, resp)
+	}
+	body, err := readBody(minURL, resp, maxVersionSize)
 	if err != nil {
 		return "", err
 	}
-	remoteVersion := strings.TrimSpace(string(body))
-	if semver.IsValid(remoteVersion) == false {
-		return "", fmt.Errorf("remote version is invalid: %v", remoteVersion)
+	minVersion := strings.TrimSpace(string(body))
+	if err := u.validateVersion(u.normalize(minVersion)); minVersion != "" && err != nil {
+		return "", fmt.Errorf("%w: minimum version %v: %v", ErrInvalidRemoteVersion, excerpt(minVersion), err)
 	}
-	return remoteVersion, nil
+	return minVersion, nil
 }
 
-func untgzFile(filename string) error {
-	f, err := os.Open(filename)
+// fetchRemoteVersion reads the version object, along with the whole manifest in ManifestMode. The metadata of
+// LatestKey is read first when set, described by a manifest.
+func fetchRemoteVersion(ctx context.Context, u Updater, st *state) (string, *Manifest, error) {
+	if u.LatestKey != "" {
+		m, err := fetchLatestMetadata(ctx, u)
+		if err != nil {
+			return "", nil, err
+		}
+		if m != nil {
+			return m.Version, m, nil
+		}
+		if u.S3VersionKey == "" {
+			return "", nil, fmt.Errorf("%w: %s has no version metadata and S3VersionKey isn't set",
+				ErrNoRemoteVersion, generateURL(u, u.LatestKey, ""))
+		}
+		u.logger().Debugf("updater: no version metadata at %s, reading %s", generateURL(u, u.LatestKey, ""), u.S3VersionKey)
+	}
+	versionURL := generateURL(u, u.S3VersionKey, "")
+	if u.channel() != "" {
+		u.logger().Debugf("updater: checking %s channel at %s", u.channel(), versionURL)
+	}
+	conditional := st != nil && st.VersionURL == versionURL && (st.VersionETag != "" || st.VersionLastModified != "") &&
+		u.validateVersion(u.normalize(st.RemoteVersion)) == nil && (st.Manifest != nil) == u.ManifestMode
+	if conditional {
+		u.RequestHook = conditionalHook(u.RequestHook, st.VersionETag, st.VersionLastModified)
+	}
+	resp, err := u.httpDo(ctx, http.MethodGet, versionURL, durationOr(u.CheckTimeout, defaultCheckTimeout))
 	if err != nil {
-		return err
+		if ctx.Err() == nil && isOffline(err) {
+			u.logger().Debugf("updater: skipping update check: %s", err)
+			return "", nil, fmt.Errorf("%w: %v", ErrCheckSkipped, err)
+		}
+		return "", nil, err
 	}
-	defer f.Close()
-	r, err := gzip.NewReader(f)
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified && conditional {
+		// the update is still attempted when the cached version is newer, whatever happened to the last attempt
+		u.logger().Debugf("updater: %s not modified, remote version is still %s", versionURL, st.RemoteVersion)
+		return st.RemoteVersion, st.Manifest, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", nil, u.statusError(versionURL, resp)
+	}
+	max := int64(maxVersionSize)
+	if u.ManifestMode {
+		max = maxManifestSize
+	}
+	body, err := readBody(versionURL, resp, max)
 	if err != nil {
-		return err
+		return "", nil, err
+	}
+	var remoteVersion string
+	var manifest *Manifest
+	if u.ManifestMode {
+		if manifest, err = decodeManifest(bytes.NewReader(body)); err != nil {
+			return "", nil, fmt.Errorf("%w: %v", ErrInvalidRemoteVersion, err)
+		}
+		remoteVersion = manifest.Version
+	} else {
+		// some proxies and S3 compatible stores serve error documents with a 200 status
+		if code, message, ok := s3Error(body); ok {
+			return "", nil, &DownloadError{URL: versionURL, StatusCode: resp.StatusCode, S3Code: code, S3Message: message}
+		}
+		remoteVersion = strings.TrimSpace(string(body))
+	}
+	if err := u.validateVersion(u.normalize(remoteVersion)); err != nil {
+		return "", nil, fmt.Errorf("%w: %v: %v", ErrInvalidRemoteVersion, excerpt(remoteVersion), err)
+	}
+	if st != nil {
+		st.VersionURL, st.VersionETag, st.VersionLastModified = versionURL, resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+	}
+	return remoteVersion, manifest, nil
+}
+
+// conditionalHook returns hook completed with the headers asking for the version object only when it changed
+func conditionalHook(hook func(*http.Request), etag, lastModified string) func(*http.Request) {
+	return func(req *http.Request) {
+		if hook != nil {
+			hook(req)
+		}
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		} else {
+			req.Header.Set("If-Modified-Since", lastModified)
+		}
+	}
+}
+
+// targetPath returns the path of the executable to replace, following symlinks unless SymlinkMode says otherwise
+func (u Updater) targetPath() (string, error) {
+	if u.SymlinkMode == SymlinkReplaceLink || u.SymlinkMode == SymlinkInstallVersioned {
+		return u.linkPath()
+	}
+	currentExecutable := u.TargetPath
+	if currentExecutable == "" {
+		exe, err := executable()
+		if err != nil {
+			return "", err
+		}
+		currentExecutable = exe
 	}
-	tr := tar.NewReader(r)
-	header, err := tr.Next()
+	target, err := filepath.EvalSymlinks(currentExecutable)
 	if err != nil {
+		if !os.IsNotExist(err) || !u.InstallIfMissing {
+			return "", fmt.Errorf("resolving %s: %w", currentExecutable, err)
+		}
+		target = currentExecutable
+	}
+	return target, nil
+}
+
+// downloadUpdate installs the release described by info, recording what it did in res, and restarts the binary
+func downloadUpdate(ctx context.Context, u Updater, info *UpdateInfo, res *UpdateResult) error {
+	if err := installUpdate(ctx, u, info, res); err != nil {
 		return err
 	}
-	if header.Typeflag != tar.TypeReg {
-		return fmt.Errorf("gunzipping file: unknown file type")
+	showNotes(ctx, u, info, res)
+	err := restartUpdated(u, res.TargetPath)
+	if err != nil && err != ErrRestartRequired && err != ErrUpdated {
+		u.events().UpdateFailed("restart", err)
 	}
-	data, err := ioutil.ReadAll(tr)
+	return err
+}
+
+// installUpdate installs the release described by info, recording what it did in res
+func installUpdate(ctx context.Context, u Updater, info *UpdateInfo, res *UpdateResult) error {
+	staged, err := stageUpdate(ctx, u, info, res)
 	if err != nil {
+		u.events().UpdateFailed("download", err)
 		return err
 	}
-	f.Close()
-	os.Remove(filename)
-	w, err := os.Create(filename)
-	if err != nil {
+	if staged == nil {
+		return nil
+	}
+	if err := staged.commit(res); err != nil {
+		u.events().UpdateFailed("install", err)
 		return err
 	}
-	defer w.Close()
-	_, err = w.Write(data)
-	return err
+	return nil
 }
 
-func downloadUpdate(downloadURL, checksumURL, version string) error {
-	resp, err := http.Get(downloadURL)
+// stagedUpdate is a verified release waiting to replace its target, the update lock being held until
+// it's committed or discarded
+type stagedUpdate struct {
+	u       Updater
+	info    *UpdateInfo
+	version string
+	target  string
+	dest    string
+	tmp     string
+	exists  bool
+	lock    *fileLock
+	start   time.Time
+}
+
+// stageUpdate downloads and verifies the release described by info next to its target, recording what it did in res.
+// The release saved in CacheDir is used when present.
+// No update is staged when another process installed one meanwhile.
+func stageUpdate(ctx context.Context, u Updater, info *UpdateInfo, res *UpdateResult) (staged *stagedUpdate, err error) {
+	return stageArtifact(ctx, u, info, u.cachedArtifact(ctx, info), res)
+}
+
+// stageArtifact is like stageUpdate, but installs the verified release at artifact rather than downloading it when set
+func stageArtifact(ctx context.Context, u Updater, info *UpdateInfo, artifact string, res *UpdateResult) (staged *stagedUpdate, err error) {
+	start := time.Now()
+	version := info.RemoteVersion
+	var target string
+	defer func() {
+		if err != nil {
+			u.recordHistory(u.historyEntry(info, target, start, err), false)
+		}
+	}()
+	target, err = u.targetPath()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	checksumResp, err := http.Get(checksumURL)
+	// verify target exists
+	fi, err := os.Stat(target)
+	exists := err == nil
+	if err != nil && (!os.IsNotExist(err) || !u.InstallIfMissing) {
+		return nil, fmt.Errorf("checking %s: %w", target, err)
+	}
+
+	if err := checkReplaceable(target, fi); err != nil {
+		if !errors.Is(err, ErrPermission) || u.FallbackDir == "" {
+			return nil, err
+		}
+		fallback := filepath.Join(u.FallbackDir, filepath.Base(target))
+		u.logger().Errorf("s3update: %s, installing to %s instead", err, fallback)
+		if err := os.MkdirAll(u.FallbackDir, 0755); err != nil {
+			return nil, fmt.Errorf("creating %s: %w", u.FallbackDir, err)
+		}
+		if err := checkWritable(u.FallbackDir); err != nil {
+			return nil, err
+		}
+		target = fallback
+		var statErr error
+		fi, statErr = os.Stat(target)
+		exists = statErr == nil
+	}
+
+	// the new binary is written at dest, which differs from target when installing versioned binaries
+	dest := target
+	if u.SymlinkMode == SymlinkInstallVersioned && filepath.Dir(target) != u.FallbackDir {
+		if dest, err = versionedPath(target, version); err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return nil, fmt.Errorf("creating %s: %w", filepath.Dir(dest), err)
+		}
+		if err := checkWritable(filepath.Dir(dest)); err != nil {
+			return nil, err
+		}
+	}
+
+	// serialize updates of the same target across processes
+	lock, waited, err := acquireLock(target+".lock", u.LockTimeout)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if waited && exists {
+		if cur, err := os.Stat(target); err == nil && !cur.ModTime().Equal(fi.ModTime()) {
+			lock.release()
+			u.logger().Infof("updater: %s got updated by another process", target)
+			res.Updated, res.TargetPath = true, target
+			return nil, nil
+		}
+	}
+
+	// leftovers of interrupted updates can go now that the lock is held
+	tmpDir := u.tempDir(dest)
+	removeStaleTemps(tmpDir, dest)
+	if tmpDir != filepath.Dir(dest) {
+		removeStaleTemps(filepath.Dir(dest), dest)
+	}
+
+	var tmp string
+	defer func() {
+		if err != nil {
+			if tmp != "" {
+				os.Remove(tmp)
+			}
+			lock.release()
+		}
+	}()
+	var mode os.FileMode
+	if artifact != "" {
+		u.logger().Debugf("updater: installing %s", artifact)
+		if tmp, mode, err = copyRelease(u, artifact, tmpDir, dest, res); err != nil {
+			return nil, err
+		}
+	}
+	downloadStart := time.Now()
+	if tmp == "" && u.PatchKey != "" && exists {
+		if tmp, err = downloadPatch(ctx, u, info, target, tmpDir, dest, res); err != nil {
+			u.logger().Debugf("updater: delta update unavailable, downloading the full release: %s", err)
+		}
+	}
+	if tmp == "" {
+		tmp, mode, err = downloadRelease(ctx, u, info, tmpDir, dest, res)
+	}
+	if artifact == "" {
+		u.events().DownloadCompleted(res.BytesDownloaded, time.Since(downloadStart), err)
 	}
-	defer checksumResp.Body.Close()
-	checksumRespBody, err := ioutil.ReadAll(checksumResp.Body)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	progressR := &ioprogress.Reader{
-		Reader:       resp.Body,
-		Size:         resp.ContentLength,
-		DrawInterval: 500 * time.Millisecond,
-		DrawFunc: ioprogress.DrawTerminalf(os.Stdout, func(progress, total int64) string {
-			bar := ioprogress.DrawTextFormatBar(40)
-			return fmt.Sprintf("%s %20s", bar(progress, total), ioprogress.DrawTextFormatBytes(progress, total))
-		}),
+	if !u.SkipBinaryValidation {
+		if err := validateBinary(tmp); err != nil {
+			return nil, err
+		}
 	}
+	if tmpDir != filepath.Dir(dest) {
+		moved, err := moveToDir(tmp, dest)
+		if err != nil {
+			return nil, err
+		}
+		tmp = moved
+	}
+	if err := applyMetadata(tmp, fi, mode); err != nil {
+		return nil, err
+	}
+	if err := clearQuarantine(tmp); err != nil {
+		u.logger().Debugf("updater: clearing the quarantine attribute of %s: %s", tmp, err)
+	}
+	if len(u.PostInstallCommand) > 0 {
+		if err := postInstall(ctx, u, tmp); err != nil {
+			return nil, err
+		}
+	}
+	if !u.NoSync {
+		if err := syncFile(tmp); err != nil {
+			return nil, fmt.Errorf("syncing %s: %w", tmp, err)
+		}
+	}
+	if len(u.VerifyCommand) > 0 {
+		if err := smokeTest(ctx, u, tmp); err != nil {
+			return nil, err
+		}
+	}
+	return &stagedUpdate{u: u, info: info, version: version, target: target, dest: dest, tmp: tmp, exists: exists, lock: lock, start: start}, nil
+}
 
-	// follow symlinks
-	currentExecutable, err := os.Executable()
+// commit replaces the target with the staged release, recording it in res
+func (s *stagedUpdate) commit(res *UpdateResult) error {
+	defer s.discard()
+	var err error
+	if s.dest != s.target {
+		err = installVersioned(s.u, s.tmp, s.target, s.dest)
+	} else {
+		err = installFile(s.u, s.tmp, s.target, s.exists)
+	}
 	if err != nil {
+		s.u.recordHistory(s.u.historyEntry(s.info, s.target, s.start, err), true)
 		return err
 	}
-	target, err := filepath.EvalSymlinks(currentExecutable)
+	entry := s.u.historyEntry(s.info, s.target, s.start, nil)
+	if res.checksum != "" {
+		entry.Checksum = res.checksum
+	}
+	s.u.recordHistory(entry, true)
+
+	s.u.recordUpdate(s.version)
+	s.u.emit(updatedEvent{Event: "updated", Version: s.version})
+	s.u.events().UpdateApplied(s.u.normalize(s.u.CurrentVersion), s.version)
+	if s.version != "" {
+		s.u.logger().Infof("successfully updated to %s", s.version)
+	} else {
+		s.u.logger().Infof("successfully updated %s", s.target)
+	}
+	res.Updated, res.TargetPath, res.Duration = true, s.target, time.Since(s.start)
+	return nil
+}
+
+// discard removes the staged release, if still around, and releases the update lock
+func (s *stagedUpdate) discard() {
+	if s.lock == nil {
+		return
+	}
+	os.Remove(s.tmp)
+	s.lock.release()
+	s.lock = nil
+}
+
+// downloadRelease downloads the release described by info to a temporary file in tmpDir, and returns its path
+// once verified and extracted along with the permissions recorded by the archive.
+func downloadRelease(ctx context.Context, u Updater, info *UpdateInfo, tmpDir, dest string, res *UpdateResult) (_ string, mode os.FileMode, err error) {
+	tmp, alg, checksum, err := fetchRelease(ctx, u, info, tmpDir, dest, res)
 	if err != nil {
-		return err
+		return "", 0, err
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(tmp)
+		}
+	}()
+	mode, extracted, err := extractArchive(u, info.DownloadURL, tmp)
+	res.Extracted = extracted
+	if err != nil {
+		return "", 0, fmt.Errorf("extracting %s: %w", tmp, err)
 	}
+	if u.ChecksumOf == ChecksumOfBinary && checksum != "" {
+		if err := verifyFile(tmp, info.RemoteVersion, alg, checksum); err != nil {
+			return "", 0, err
+		}
+	}
+	return tmp, mode, nil
+}
 
-	// verify target exists, move to backup
-	_, err = os.Stat(target)
+// fetchRelease downloads the release described by info as published to a temporary file in tmpDir, and returns
+// its path along with the checksum it must match. The checksum is already verified unless it covers the binary.
+func fetchRelease(ctx context.Context, u Updater, info *UpdateInfo, tmpDir, dest string, res *UpdateResult) (tmp, alg, checksum string, err error) {
+	downloadURL, version := info.DownloadURL, info.RemoteVersion
+	size, ranges := int64(-1), false
+	if u.Source == nil {
+		if size, ranges, err = u.remoteSize(ctx, info); err != nil {
+			return "", "", "", err
+		}
+	}
+	for _, dir := range []string{tmpDir, filepath.Dir(dest)} {
+		if err := checkSpace(dir, requiredSpace(downloadURL, size)); err != nil {
+			return "", "", "", err
+		}
+	}
+
+	// a missing checksum fails the update before anything gets downloaded
+	alg, checksum, err = fetchChecksum(ctx, u, info)
 	if err != nil {
-		return nil
+		return "", "", "", err
+	}
+	h, err := newHash(alg)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	parallel := u.parallelDownload(size, ranges)
+	var body io.ReadCloser
+	length := int64(-1)
+	if !parallel {
+		if body, length, err = u.openRelease(ctx, info); err != nil {
+			return "", "", "", err
+		}
+		defer body.Close()
+		// the size announced by HEAD still applies to chunked responses
+		if length >= 0 {
+			size = length
+		}
 	}
-	backup := target + ".bak"
-	os.Rename(target, backup)
 
-	// use the same flags that ioutil.WriteFile uses
-	f, err := os.OpenFile(target, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0755)
+	// download next to the target by default so the final rename stays on the same filesystem
+	// the extension is kept so that the file can be run on windows by VerifyCommand
+	f, err := ioutil.TempFile(tmpDir, tempPattern(dest))
 	if err != nil {
-		os.Rename(backup, target)
-		return err
+		return "", "", "", fmt.Errorf("creating temporary file: %w", err)
 	}
+	tmp = f.Name()
+	// tmp is cleared by the return statements of failures
+	defer func() {
+		if err != nil {
+			os.Remove(f.Name())
+		}
+	}()
 	defer f.Close()
-	if _, err := io.Copy(f, progressR); err != nil {
-		os.Rename(backup, target)
-		return err
+	sigHash := sha256.New()
+	if parallel {
+		n, err := u.downloadParts(ctx, downloadURL, f, size)
+		res.BytesDownloaded = n
+		if errors.Is(err, errRangeIgnored) {
+			// Accept-Ranges was advertised but the parts came whole, the release is streamed instead
+			u.logger().Debugf("updater: %s, downloading it in one request", err)
+			parallel = false
+			if err := f.Truncate(0); err != nil {
+				return "", "", "", fmt.Errorf("truncating %s: %w", tmp, err)
+			}
+			if body, length, err = u.openRelease(ctx, info); err != nil {
+				return "", "", "", err
+			}
+			defer body.Close()
+			if length >= 0 {
+				size = length
+			}
+		} else if err != nil {
+			return "", "", "", err
+		}
+	}
+	if parallel {
+		// the parts arrive out of order, the file is hashed once assembled
+		if _, err := io.Copy(io.MultiWriter(h, sigHash), io.NewSectionReader(f, 0, size)); err != nil {
+			return "", "", "", fmt.Errorf("hashing %s: %w", tmp, err)
+		}
+	} else if err := streamRelease(ctx, u, body, f, size, length, downloadURL, io.MultiWriter(h, sigHash), res); err != nil {
+		return "", "", "", err
 	}
 	f.Close()
+	if u.ChecksumOf != ChecksumOfBinary && checksum != "" {
+		if err := matchDigest(h, version, alg, checksum); err != nil {
+			return "", "", "", err
+		}
+	}
+	if len(u.PublicKey) > 0 {
+		if res.signature, err = verifySignature(ctx, u, info.SignatureURL, sigHash.Sum(nil)); err != nil {
+			return "", "", "", err
+		}
+	}
+	res.checksum = ChecksumSHA256 + ":" + hex.EncodeToString(sigHash.Sum(nil))
+	return tmp, alg, checksum, nil
+}
 
-	f, err = os.Open(target)
+// streamRelease copies body to f and to h, expecting length bytes when known
+func streamRelease(ctx context.Context, u Updater, body io.Reader, f *os.File, size, length int64, downloadURL string, h io.Writer, res *UpdateResult) error {
+	// hash while streaming so the download is read only once
+	n, err := io.Copy(f, io.TeeReader(u.progressReader(u.throttle(ctx, body), size), h))
+	res.BytesDownloaded = n
 	if err != nil {
-		return err
-	}
-	defer f.Close()
-	h := md5.New()
-	if _, err := io.Copy(h, f); err != nil {
-		os.Rename(backup, target)
-		return err
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return &DownloadError{URL: downloadURL, Err: err}
 	}
-	if string(checksumRespBody) != hex.EncodeToString(h.Sum(nil)) {
-		os.Rename(backup, target)
-		return fmt.Errorf("%s checksum mismatch", version)
+	// a connection closed cleanly midway isn't reported by io.Copy
+	if length >= 0 && n != length {
+		return truncatedError(downloadURL, n, length)
 	}
+	return nil
+}
 
-	if strings.HasSuffix(downloadURL, ".tgz") {
-		err = untgzFile(target)
-		if err != nil {
+// installFile renames tmp over target, the previous binary being kept as <target>.bak with KeepBackup
+func installFile(u Updater, tmp, target string, exists bool) error {
+	// keep a backup around until the new binary is in place
+	backup := target + ".bak"
+	if _, err := os.Lstat(backup); err == nil {
+		u.logger().Debugf("updater: replacing previous backup %s", backup)
+	}
+	if exists {
+		if err := os.Rename(target, backup); err != nil {
+			return fmt.Errorf("backing up %s: %w", target, err)
+		}
+	}
+	if err := os.Rename(tmp, target); err != nil {
+		if exists {
 			os.Rename(backup, target)
-			return err
 		}
+		return fmt.Errorf("replacing %s: %w", target, err)
 	}
+	// the rename may otherwise reach the disk after the backup removal
+	u.syncDir(filepath.Dir(target))
 
-	err = os.Chmod(target, 0755)
-	if err != nil {
-		os.Rename(backup, target)
-		return err
+	if exists {
+		if u.KeepBackup {
+			st := u.loadState()
+			st.BackupVersion = u.CurrentVersion
+			if err := u.saveState(st); err != nil {
+				u.logger().Debugf("updater: saving state: %s", err)
+			}
+		} else {
+			os.Remove(backup)
+		}
+	}
+	return nil
+}
+
+// installVersioned moves tmp to dest and points the link to it, the previous version stays in place
+func installVersioned(u Updater, tmp, link, dest string) error {
+	if err := os.Rename(tmp, dest); err != nil {
+		return fmt.Errorf("installing %s: %w", dest, err)
+	}
+	u.syncDir(filepath.Dir(dest))
+	if err := retargetLink(link, dest); err != nil {
+		os.Remove(dest)
+		return fmt.Errorf("retargeting %s: %w", link, err)
+	}
+	u.syncDir(filepath.Dir(link))
+	return nil
+}
+
+// restartUpdated re-runs the original command with the updated target, unless NoRestart or TargetPath is set or
+// the process runs as PID 1. ErrUpdated is returned instead when NoExit is set, restarting replacing or ending the
+// running process.
+func restartUpdated(u Updater, target string) error {
+	// another binary than the running one got updated
+	if u.TargetPath != "" {
+		return nil
+	}
+	if u.NoRestart {
+		return ErrRestartRequired
+	}
+	// exec'ing over PID 1 loses the signal handlers and reaping it set up
+	if getpid() == 1 && !u.ForceRestart {
+		u.logger().Debugf("updater: running as PID 1, leaving the restart to the orchestrator")
+		return ErrRestartRequired
+	}
+	if u.NoExit {
+		return ErrUpdated
 	}
+	args := u.RestartArgs
+	if args == nil {
+		args = os.Args[1:]
+	}
+	env := u.RestartEnv
+	if env == nil {
+		env = os.Environ()
+	}
+	if u.MarkRestart {
+		env = append(env[:len(env):len(env)], restartedEnv+"=1")
+	}
+	return restart(target, args, env)
+}
+
+// getpid returns the process ID, a variable so that tests can run as PID 1
+var getpid = os.Getpid
+
+// executable returns the path of the running executable, a variable so that tests don't update their own binary
+var executable = os.Executable
 
-	os.Remove(backup)
+// restartedEnv is the variable set by MarkRestart
+const restartedEnv = "S3UPDATE_RESTARTED"
 
-	fmt.Printf("successfully updated to %s\n", version)
+// Restarted reports whether the process is the re-run of a binary updated with MarkRestart set,
+// e.g. to skip the update check
+func Restarted() bool {
+	return os.Getenv(restartedEnv) != ""
+}
 
-	// re-run original command
-	return syscall.Exec(target, os.Args, os.Environ())
+// applyMetadata gives filename the mode and, when running as root, the ownership of the binary it replaces,
+// described by fi. A fresh install gets the archive mode when executable, 0755 otherwise.
+func applyMetadata(filename string, fi os.FileInfo, archiveMode os.FileMode) error {
+	mode := archiveMode
+	if fi != nil {
+		mode = fi.Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+		// chown clears the setuid and setgid bits, so it goes first
+		if uid, gid, ok := fileOwner(fi); ok && os.Geteuid() == 0 {
+			if err := os.Chown(filename, uid, gid); err != nil {
+				return fmt.Errorf("setting owner of %s: %w", filename, err)
+			}
+		}
+	} else if mode&0111 == 0 {
+		mode = 0755
+	}
+	if err := os.Chmod(filename, mode); err != nil {
+		return fmt.Errorf("setting permissions on %s: %w", filename, err)
+	}
+	return nil
 }
 
-func runAutoUpdate(u Updater) error {
-	if !semver.IsValid(u.CurrentVersion) {
-		return fmt.Errorf("invalid local version")
+// checkReplaceable makes sure target, described by fi when it exists, can be replaced by the current user
+func checkReplaceable(target string, fi os.FileInfo) error {
+	dir := filepath.Dir(target)
+	if err := checkWritable(dir); err != nil {
+		return err
 	}
-	localVersion := u.CurrentVersion
-	remoteVersion, err := fetchRemoteVersion(u.S3Bucket)
+	if fi == nil {
+		return nil
+	}
+	// in sticky directories such as /tmp, only the owner of a file may rename it
+	dfi, err := os.Stat(dir)
+	if err != nil || dfi.Mode()&os.ModeSticky == 0 {
+		return nil
+	}
+	uid, _, ok := fileOwner(fi)
+	if ok && uid != os.Geteuid() && os.Geteuid() != 0 {
+		return &PermissionError{Path: target, Err: os.ErrPermission}
+	}
+	return nil
+}
+
+// checkWritable makes sure files can be created in dir, returning a *PermissionError otherwise
+func checkWritable(dir string) error {
+	f, err := ioutil.TempFile(dir, ".s3update.*.tmp")
 	if err != nil {
+		if os.IsPermission(err) {
+			return &PermissionError{Path: dir, Err: err}
+		}
 		return err
 	}
-	if semver.Compare(localVersion, remoteVersion) == -1 {
-		fmt.Printf("upgrading from %s to %s\n", localVersion, remoteVersion)
-		downloadURL := generateURL(u.S3Bucket, u.S3ReleaseKey, remoteVersion)
-		checksumURL := generateURL(u.S3Bucket, u.ChecksumKey, remoteVersion)
-		if u.Verbose {
-			fmt.Printf("downloadURL: %s\n", downloadURL)
-			fmt.Printf("checksumURL: %s\n", checksumURL)
+	f.Close()
+	// a concurrent update may have removed the probe already, along with its stale temporary files
+	if err := os.Remove(f.Name()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// syncFile flushes filename contents to stable storage, a variable so that tests can observe it
+var syncFile = func(filename string) error {
+	f, err := os.OpenFile(filename, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+// syncDir flushes the entries of dir, renames included, to stable storage unless NoSync is set.
+// The update already happened by then, so failures are only logged.
+func (u Updater) syncDir(dir string) {
+	if u.NoSync {
+		return
+	}
+	if err := syncDirectory(dir); err != nil {
+		u.logger().Debugf("updater: syncing %s: %s", dir, err)
+	}
+}
+
+func runAutoUpdate(ctx context.Context, u Updater) (*UpdateResult, error) {
+	info, err := checkForUpdate(ctx, u)
+	if err != nil {
+		u.events().UpdateFailed("check", err)
+		// the minimum version saved by the last check still applies
+		current, minVersion := u.normalize(u.CurrentVersion), u.normalize(u.loadState().MinVersion)
+		if minVersion != "" && !errors.Is(err, ErrInvalidLocalVersion) && u.compareVersions(current, minVersion) < 0 {
+			return nil, &VersionTooOldError{Current: current, MinVersion: minVersion, Err: err}
 		}
-		err = downloadUpdate(downloadURL, checksumURL, remoteVersion)
+		return nil, err
+	}
+	res := &UpdateResult{FromVersion: info.CurrentVersion, ToVersion: info.RemoteVersion}
+	tooOld := info.MinVersion != "" && u.compareVersions(info.CurrentVersion, info.MinVersion) < 0
+	// versions no longer supported are updated right away
+	if info.UpdateAvailable && !tooOld && !u.inUpdateWindow() {
+		u.logger().Infof("version %s is available, it will be installed during the update window", info.RemoteVersion)
+		res.Pending = true
+		return res, nil
+	}
+	if info.UpdateAvailable && u.Confirm && !u.confirmUpdate(info) {
+		if tooOld {
+			return res, &VersionTooOldError{Current: info.CurrentVersion, MinVersion: info.MinVersion}
+		}
+		return res, nil
+	}
+	if info.UpdateAvailable && u.DeferInstall && !tooOld {
+		u.logger().Infof("version %s is available, it will be installed on exit", info.RemoteVersion)
+		u.setPending(info.RemoteVersion)
+		res.Pending = true
+		return res, nil
+	}
+	if info.UpdateAvailable {
+		if err := u.checkUpdateLoop(info); err != nil {
+			u.logger().Errorf("s3update: %s", err)
+			return res, err
+		}
+		u.logger().Infof("upgrading from %s to %s", info.CurrentVersion, info.RemoteVersion)
+		u.logger().Debugf("downloadURL: %s", info.DownloadURL)
+		u.logger().Debugf("checksumURL: %s", info.ChecksumURL)
+		err = downloadUpdate(ctx, u, info, res)
 		if err != nil {
-			return err
+			if err != ErrRestartRequired && err != ErrUpdated && tooOld {
+				return res, &VersionTooOldError{Current: info.CurrentVersion, MinVersion: info.MinVersion, Err: err}
+			}
+			return res, err
 		}
-		os.Exit(0)
+		return res, exitUpdated(u)
 	}
-	if u.Verbose {
-		fmt.Printf("updater: using the latest version: %s\n", u.CurrentVersion)
+	if tooOld {
+		// e.g. the remote version got yanked
+		return res, &VersionTooOldError{Current: info.CurrentVersion, MinVersion: info.MinVersion}
 	}
-	return nil
+	u.logger().Debugf("updater: using the latest version: %s", u.CurrentVersion)
+	return res, nil
 }
@@ -0,0 +1,715 @@
+package s3update
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testBucket serves objects from memory as <URL>/<key>, recording the requests it gets
+type testBucket struct {
+	*httptest.Server
+	mu       sync.Mutex
+	objects  map[string][]byte
+	handlers map[string]http.HandlerFunc
+	requests []*http.Request
+}
+
+func newTestBucket(t *testing.T) *testBucket {
+	b := &testBucket{objects: map[string][]byte{}, handlers: map[string]http.HandlerFunc{}}
+	b.Server = httptest.NewServer(http.HandlerFunc(b.serve))
+	t.Cleanup(b.Close)
+	return b
+}
+
+func (b *testBucket) serve(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	b.mu.Lock()
+	b.requests = append(b.requests, r.Clone(context.Background()))
+	handler := b.handlers[key]
+	data, ok := b.objects[key]
+	b.mu.Unlock()
+	if handler != nil {
+		handler(w, r)
+		return
+	}
+	if !ok {
+		w.Header().Set("Content-Type", "application/xml")
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>`)
+		return
+	}
+	http.ServeContent(w, r, key, time.Time{}, bytes.NewReader(data))
+}
+
+// put stores data at key
+func (b *testBucket) put(key string, data []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.objects[key] = data
+}
+
+// handle serves key with h rather than from memory
+func (b *testBucket) handle(key string, h http.HandlerFunc) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers[key] = h
+}
+
+// publish makes binary the release of version, along with its SHA-256 checksum, and version the latest
+func (b *testBucket) publish(version string, binary []byte) {
+	b.publishAt("", version, binary)
+}
+
+// publishAt publishes like publish under the key prefix
+func (b *testBucket) publishAt(prefix, version string, binary []byte) {
+	sum := sha256.Sum256(binary)
+	b.put(prefix+"VERSION", []byte(version+"\n"))
+	b.put(prefix+"mytool-"+version, binary)
+	b.put(prefix+"mytool-"+version+".sha256", []byte(hex.EncodeToString(sum[:])+"  mytool-"+version+"\n"))
+}
+
+// publishManifest publishes version as described by a manifest at VERSION, other fields than the version and
+// the artifact for this platform being taken from m
+func (b *testBucket) publishManifest(version string, binary []byte, m Manifest) {
+	m.Version = version
+	m.Artifacts = map[string]ManifestArtifact{runtime.GOOS + "_" + runtime.GOARCH: {
+		URL:    "mytool-{{VERSION}}",
+		SHA256: sha256Hex(string(binary)),
+	}}
+	doc, err := json.Marshal(m)
+	if err != nil {
+		panic(err)
+	}
+	b.put("mytool-"+version, binary)
+	b.put("VERSION", doc)
+}
+
+// manifestUpdater returns an Updater of target like updater does, reading the manifest published to b
+func (b *testBucket) manifestUpdater(target string) Updater {
+	u := b.updater(target)
+	u.ManifestMode, u.ChecksumKey = true, ""
+	return u
+}
+
+// requested returns the requests received, as "<method> <key>"
+func (b *testBucket) requested() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var reqs []string
+	for _, r := range b.requests {
+		reqs = append(reqs, r.Method+" "+strings.TrimPrefix(r.URL.Path, "/"))
+	}
+	return reqs
+}
+
+// updater returns an Updater of target, at version v1.0.0, reading the releases published to b
+func (b *testBucket) updater(target string) Updater {
+	return Updater{
+		CurrentVersion:       "v1.0.0",
+		BaseURL:              b.URL,
+		AllowInsecureHTTP:    true,
+		S3VersionKey:         "VERSION",
+		S3ReleaseKey:         "mytool-{{VERSION}}",
+		ChecksumKey:          "mytool-{{VERSION}}.sha256",
+		ChecksumAlgorithm:    ChecksumSHA256,
+		TargetPath:           target,
+		StateFile:            filepath.Join(filepath.Dir(filepath.Dir(target)), "state", "s3update.json"),
+		SkipBinaryValidation: true,
+		DisableProgress:      true,
+		DisableEnvOverrides:  true,
+		Logger:               NopLogger,
+	}
+}
+
+// sourceUpdater returns an Updater of target like updater does, reading the releases from src rather than b
+func (b *testBucket) sourceUpdater(target string, src Source) Updater {
+	u := b.updater(target)
+	u.BaseURL, u.S3VersionKey, u.S3ReleaseKey, u.ChecksumKey = "", "", "", ""
+	u.Source = src
+	return u
+}
+
+// exe returns contents made to look like an executable of the running platform, as releases must
+func exe(contents string) string {
+	if runtime.GOOS == "windows" {
+		return "MZ" + contents
+	}
+	return "#!" + contents
+}
+
+// newTarget writes contents to the binary to update, alone in its directory
+func newTarget(t *testing.T, contents string) string {
+	t.Helper()
+	dir := filepath.Join(t.TempDir(), "bin")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	target := filepath.Join(dir, "mytool"+exeSuffix)
+	if err := ioutil.WriteFile(target, []byte(contents), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return target
+}
+
+// assertContents fails unless filename holds contents
+func assertContents(t *testing.T, filename, contents string) {
+	t.Helper()
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != contents {
+		t.Fatalf("%s holds %q, want %q", filepath.Base(filename), data, contents)
+	}
+}
+
+// assertAlone fails unless the directory of target holds nothing else but its lock
+func assertAlone(t *testing.T, target string) {
+	t.Helper()
+	entries, err := ioutil.ReadDir(filepath.Dir(target))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if e.Name() != filepath.Base(target) && e.Name() != filepath.Base(target)+".lock" {
+			t.Errorf("%s left next to the binary", e.Name())
+		}
+	}
+}
+
+func TestAutoUpdate(t *testing.T) {
+	b := newTestBucket(t)
+	b.publish("v1.1.0", []byte(exe("new binary")))
+	target := newTarget(t, exe("old binary"))
+
+	res, err := AutoUpdateResult(b.updater(target))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Updated || res.FromVersion != "v1.0.0" || res.ToVersion != "v1.1.0" || res.TargetPath != target {
+		t.Errorf("got %+v", res)
+	}
+	assertContents(t, target, exe("new binary"))
+	assertAlone(t, target)
+}
+
+func TestAutoUpdateUpToDate(t *testing.T) {
+	b := newTestBucket(t)
+	b.publish("v1.0.0", []byte(exe("same binary")))
+	target := newTarget(t, exe("old binary"))
+
+	res, err := AutoUpdateResult(b.updater(target))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Updated {
+		t.Error("updated to the current version")
+	}
+	assertContents(t, target, exe("old binary"))
+	for _, r := range b.requested() {
+		if r != "GET VERSION" {
+			t.Errorf("unexpected request %s", r)
+		}
+	}
+}
+
+func TestAutoUpdateContextCancelledDownload(t *testing.T) {
+	b := newTestBucket(t)
+	b.publish("v1.1.0", nil)
+	release := bytes.Repeat([]byte("x"), 1<<20)
+	sent := make(chan struct{})
+	b.handle("mytool-v1.1.0", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(release)))
+		if r.Method == http.MethodHead {
+			return
+		}
+		w.Write(release[:len(release)/2])
+		w.(http.Flusher).Flush()
+		close(sent)
+		// the rest never comes
+		<-r.Context().Done()
+	})
+	target := newTarget(t, exe("old binary"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-sent
+		cancel()
+	}()
+	err := AutoUpdateContext(ctx, b.updater(target))
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want %v", err, context.Canceled)
+	}
+	assertContents(t, target, exe("old binary"))
+	assertAlone(t, target)
+}
+
+func TestApplyUpdateValidates(t *testing.T) {
+	b := newTestBucket(t)
+	b.publish("v1.1.0", []byte(exe("new binary")))
+	target := newTarget(t, exe("old binary"))
+	info, err := CheckForUpdate(b.updater(target))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u := b.updater(target)
+	u.SymlinkMode = "copy"
+	if err := ApplyUpdate(u, info); !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("got %v, want %v", err, ErrInvalidConfig)
+	}
+	assertContents(t, target, exe("old binary"))
+
+	// the environment completes the configuration
+	u = b.updater(target)
+	u.S3ReleaseKey, u.DisableEnvOverrides = "", false
+	t.Setenv("S3UPDATE_RELEASE_KEY", "mytool-{{VERSION}}")
+	if err := ApplyUpdate(u, info); err != nil {
+		t.Fatal(err)
+	}
+	assertContents(t, target, exe("new binary"))
+}
+
+func TestAutoUpdateFailedDownloadLeavesTarget(t *testing.T) {
+	b := newTestBucket(t)
+	b.publish("v1.1.0", nil)
+	release := bytes.Repeat([]byte("x"), 1<<20)
+	target := newTarget(t, exe("old binary"))
+	var (
+		mu     sync.Mutex
+		during []string
+	)
+	b.handle("mytool-v1.1.0", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(release)))
+		if r.Method == http.MethodHead {
+			return
+		}
+		w.Write(release[:len(release)/2])
+		w.(http.Flusher).Flush()
+		time.Sleep(50 * time.Millisecond)
+		// the download is written next to the target, which stays untouched meanwhile
+		if data, err := ioutil.ReadFile(target); err != nil || string(data) != exe("old binary") {
+			t.Errorf("target modified during the download: %q, %v", data, err)
+		}
+		entries, _ := ioutil.ReadDir(filepath.Dir(target))
+		mu.Lock()
+		for _, e := range entries {
+			during = append(during, e.Name())
+		}
+		mu.Unlock()
+		// the connection dies midway
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+	})
+
+	err := AutoUpdate(b.updater(target))
+	var de *DownloadError
+	if !errors.As(err, &de) {
+		t.Fatalf("got %v, want a *DownloadError", err)
+	}
+	assertContents(t, target, exe("old binary"))
+	assertAlone(t, target)
+	mu.Lock()
+	defer mu.Unlock()
+	tmp := false
+	for _, name := range during {
+		tmp = tmp || strings.HasPrefix(name, ".mytool") && strings.Contains(name, ".tmp")
+	}
+	if !tmp {
+		t.Errorf("no temporary file next to the target during the download: %v", during)
+	}
+}
+
+func TestAutoUpdateChecksumMismatchLeavesTarget(t *testing.T) {
+	b := newTestBucket(t)
+	b.publish("v1.1.0", []byte(exe("new binary")))
+	b.put("mytool-v1.1.0", []byte(exe("tampered binary")))
+	target := newTarget(t, exe("old binary"))
+
+	err := AutoUpdate(b.updater(target))
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("got %v, want %v", err, ErrChecksumMismatch)
+	}
+	assertContents(t, target, exe("old binary"))
+	assertAlone(t, target)
+}
+
+func TestAutoUpdateMissingTarget(t *testing.T) {
+	b := newTestBucket(t)
+	b.publish("v1.1.0", []byte(exe("new binary")))
+	target := filepath.Join(filepath.Dir(newTarget(t, "")), "missing")
+
+	err := AutoUpdate(b.updater(target))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got %v, want %v", err, os.ErrNotExist)
+	}
+	if !strings.Contains(err.Error(), target) {
+		t.Errorf("%q doesn't name %s", err, target)
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Errorf("%s got installed", target)
+	}
+
+	u := b.updater(target)
+	u.InstallIfMissing = true
+	if err := AutoUpdate(u); err != nil {
+		t.Fatal(err)
+	}
+	assertContents(t, target, exe("new binary"))
+}
+
+func TestGenerateURLAddressing(t *testing.T) {
+	t.Setenv("AWS_REGION", "")
+	t.Setenv("AWS_DEFAULT_REGION", "")
+	tests := []struct {
+		name string
+		u    Updater
+		want string
+	}{
+		{"aws", Updater{S3Bucket: "releases"}, "https://releases.s3.amazonaws.com/mytool/VERSION"},
+		{"aws region", Updater{S3Bucket: "releases", S3Region: "eu-west-3"},
+			"https://releases.s3.eu-west-3.amazonaws.com/mytool/VERSION"},
+		{"aws dualstack", Updater{S3Bucket: "releases", S3Region: "eu-west-3", DualStack: true},
+			"https://releases.s3.dualstack.eu-west-3.amazonaws.com/mytool/VERSION"},
+		{"aws path style", Updater{S3Bucket: "releases", PathStyle: true},
+			"https://s3.amazonaws.com/releases/mytool/VERSION"},
+		{"endpoint", Updater{S3Bucket: "releases", Endpoint: "https://spaces.example.com"},
+			"https://releases.spaces.example.com/mytool/VERSION"},
+		{"endpoint path style", Updater{S3Bucket: "releases", Endpoint: "https://spaces.example.com/", PathStyle: true},
+			"https://spaces.example.com/releases/mytool/VERSION"},
+		{"endpoint with port", Updater{S3Bucket: "releases", Endpoint: "https://minio.internal:9000"},
+			"https://releases.minio.internal:9000/mytool/VERSION"},
+		{"endpoint with port path style", Updater{S3Bucket: "releases", Endpoint: "http://minio.internal:9000", PathStyle: true},
+			"http://minio.internal:9000/releases/mytool/VERSION"},
+		{"base URL", Updater{S3Bucket: "ignored", BaseURL: "https://downloads.example.com/tools/"},
+			"https://downloads.example.com/tools/mytool/VERSION"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.u.GenerateURL("mytool/VERSION", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateEndpoint(t *testing.T) {
+	u := Updater{CurrentVersion: "v1.0.0", S3Bucket: "releases", S3VersionKey: "VERSION", S3ReleaseKey: "mytool",
+		ChecksumKey: "mytool.sha256"}
+	for _, endpoint := range []string{"https://minio.internal:9000", "http://127.0.0.1:9000"} {
+		u.Endpoint = endpoint
+		if err := u.Validate(); err != nil {
+			t.Errorf("%s: %v", endpoint, err)
+		}
+	}
+	for _, endpoint := range []string{"minio.internal:9000", "ftp://minio.internal", "https://"} {
+		u.Endpoint = endpoint
+		var ce *ConfigError
+		if err := u.Validate(); !errors.As(err, &ce) || ce.Field != "Endpoint" {
+			t.Errorf("%s: got %v, want an Endpoint error", endpoint, err)
+		}
+	}
+}
+
+func TestValidateVerification(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name                string
+		checksum, signature bool
+		skip, manifest      bool
+		field               string
+	}{
+		{name: "checksum", checksum: true},
+		{name: "signature", signature: true},
+		{name: "checksum and signature", checksum: true, signature: true},
+		{name: "neither", field: "ChecksumKey"},
+		{name: "neither explicitly", skip: true},
+		{name: "signature without checksum explicitly", signature: true, skip: true},
+		{name: "checksums in the manifest", manifest: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := Updater{CurrentVersion: "v1.0.0", S3Bucket: "releases", S3VersionKey: "VERSION", S3ReleaseKey: "mytool",
+				InsecureSkipChecksum: tt.skip, ManifestMode: tt.manifest}
+			if tt.checksum {
+				u.ChecksumKey = "mytool.sha256"
+			}
+			if tt.signature {
+				u.PublicKey, u.SignatureKey = pub, "mytool.sig"
+			}
+			err := u.Validate()
+			if tt.field == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			var ce *ConfigError
+			if !errors.As(err, &ce) || ce.Field != tt.field || !errors.Is(err, ErrMissingField) {
+				t.Fatalf("got %v, want a missing %s", err, tt.field)
+			}
+		})
+	}
+}
+
+func TestVersionKey(t *testing.T) {
+	b := newTestBucket(t)
+	b.put("VERSION", []byte("v9.0.0"))
+	b.put("releases/mycli/VERSION", []byte("v1.2.0"))
+	u := b.updater(newTarget(t, exe("old binary")))
+	u.S3VersionKey = "releases/mycli/VERSION"
+
+	info, err := CheckForUpdate(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.RemoteVersion != "v1.2.0" {
+		t.Errorf("got remote version %s, want v1.2.0", info.RemoteVersion)
+	}
+	if got := strings.Join(b.requested(), ", "); got != "GET releases/mycli/VERSION" {
+		t.Errorf("got requests %s", got)
+	}
+}
+
+// assertMode checks the permission bits of filename
+func assertMode(t *testing.T, filename string, mode os.FileMode) {
+	t.Helper()
+	fi, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fi.Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky); got != mode {
+		t.Errorf("%s has mode %v, want %v", filepath.Base(filename), got, mode)
+	}
+}
+
+func TestAutoUpdatePreservesMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no permission bits on windows")
+	}
+	for _, mode := range []os.FileMode{0750, 0755 | os.ModeSetgid, 0700} {
+		t.Run(mode.String(), func(t *testing.T) {
+			b := newTestBucket(t)
+			b.publish("v1.1.0", []byte(exe("new binary")))
+			target := newTarget(t, exe("old binary"))
+			if err := os.Chmod(target, mode); err != nil {
+				t.Fatal(err)
+			}
+			assertMode(t, target, mode)
+
+			if err := AutoUpdate(b.updater(target)); err != nil {
+				t.Fatal(err)
+			}
+			assertContents(t, target, exe("new binary"))
+			assertMode(t, target, mode)
+		})
+	}
+}
+
+func TestAutoUpdateFreshInstallMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no permission bits on windows")
+	}
+	archive := tarball(t, gzipWriter, testEntry{name: "mytool", body: exe("new binary"), mode: 0700})
+	tests := []struct {
+		name, key string
+		release   []byte
+		mode      os.FileMode
+	}{
+		{"binary", "mytool-{{VERSION}}", []byte(exe("new binary")), 0755},
+		{"archive", "mytool-{{VERSION}}.tgz", archive, 0700},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTestBucket(t)
+			b.put("VERSION", []byte("v1.1.0"))
+			key, _ := (Updater{}).expandKey(tt.key, "v1.1.0", nil)
+			b.put(key, tt.release)
+			b.put(key+".sha256", []byte(sha256Hex(string(tt.release))))
+			target := filepath.Join(filepath.Dir(newTarget(t, "")), "installed")
+			u := b.updater(target)
+			u.S3ReleaseKey, u.ChecksumKey, u.BinaryName, u.InstallIfMissing = tt.key, tt.key+".sha256", "mytool", true
+
+			if err := AutoUpdate(u); err != nil {
+				t.Fatal(err)
+			}
+			assertContents(t, target, exe("new binary"))
+			assertMode(t, target, tt.mode)
+		})
+	}
+}
+
+func TestAutoUpdateArchiveModeOverridden(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no permission bits on windows")
+	}
+	archive := tarball(t, gzipWriter, testEntry{name: "mytool", body: exe("new binary"), mode: 0700})
+	b := newTestBucket(t)
+	b.put("VERSION", []byte("v1.1.0"))
+	b.put("mytool-v1.1.0.tgz", archive)
+	b.put("mytool-v1.1.0.tgz.sha256", []byte(sha256Hex(string(archive))))
+	target := newTarget(t, exe("old binary"))
+	if err := os.Chmod(target, 0750); err != nil {
+		t.Fatal(err)
+	}
+	u := b.updater(target)
+	u.S3ReleaseKey, u.ChecksumKey = "mytool-{{VERSION}}.tgz", "mytool-{{VERSION}}.tgz.sha256"
+
+	if err := AutoUpdate(u); err != nil {
+		t.Fatal(err)
+	}
+	// the installed binary keeps its mode over the one of the archive
+	assertMode(t, target, 0750)
+}
+
+func TestGenerateURLTemplates(t *testing.T) {
+	u := Updater{
+		BaseURL:        "https://downloads.example.com",
+		CurrentVersion: "v1.0.0",
+		OSMap:          map[string]string{runtime.GOOS: "macos"},
+		ArchMap:        map[string]string{runtime.GOARCH: "x86_64"},
+		TemplateVars:   map[string]string{"EDITION": "pro", "OS": "ignored"},
+	}
+	tests := []struct{ template, want string }{
+		{"mycli_{{OS}}_{{ARCH}}.tgz", "mycli_macos_x86_64.tgz"},
+		{"{{EDITION}}/mycli-{{VERSION}}{{EXT}}", "pro/mycli-1.1.0" + exeSuffix},
+		{"mycli-{{SEMVER}}", "mycli-v1.1.0"},
+		{"patches/{{FROM}}_{{TO}}", "patches/v1.0.0_1.1.0"},
+		{"mycli", "mycli"},
+	}
+	for _, tt := range tests {
+		got, err := u.GenerateURL(tt.template, "1.1.0")
+		if err != nil {
+			t.Errorf("%s: %v", tt.template, err)
+			continue
+		}
+		if want := "https://downloads.example.com/" + tt.want; got != want {
+			t.Errorf("%s: got %s, want %s", tt.template, got, want)
+		}
+	}
+
+	// unmapped names are substituted verbatim
+	got, err := Updater{BaseURL: "https://downloads.example.com"}.GenerateURL("mycli_{{OS}}_{{ARCH}}", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "https://downloads.example.com/mycli_" + runtime.GOOS + "_" + runtime.GOARCH; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestUnknownPlaceholder(t *testing.T) {
+	u := Updater{BaseURL: "https://downloads.example.com", TemplateVars: map[string]string{"EDITION": "pro"}}
+	for _, template := range []string{"mycli-{{EDTION}}", "mycli-{{version}}", "mycli-{{}}"} {
+		if _, err := u.GenerateURL(template, "v1.1.0"); !errors.Is(err, ErrUnknownPlaceholder) {
+			t.Errorf("%s: got %v, want %v", template, err, ErrUnknownPlaceholder)
+		}
+	}
+
+	b := newTestBucket(t)
+	u = b.updater(newTarget(t, exe("old binary")))
+	u.S3ReleaseKey = "mytool-{{VERSION}}-{{EDITION}}"
+	err := AutoUpdate(u)
+	var ve *ValidationError
+	var ce *ConfigError
+	if !errors.As(err, &ve) || !errors.Is(err, ErrUnknownPlaceholder) || !errors.As(err, &ce) || ce.Field != "S3ReleaseKey" {
+		t.Fatalf("got %v, want an unknown placeholder in S3ReleaseKey", err)
+	}
+	if !strings.Contains(err.Error(), "{{EDITION}}") {
+		t.Errorf("%q doesn't name the placeholder", err)
+	}
+	if len(b.requested()) > 0 {
+		t.Errorf("requests sent despite the invalid configuration: %v", b.requested())
+	}
+}
+
+// stubSync calls check with every file and directory flushed to disk for the duration of the test
+func stubSync(t *testing.T, check func(kind, path string)) {
+	file, dir := syncFile, syncDirectory
+	syncFile = func(filename string) error {
+		check("file", filename)
+		return file(filename)
+	}
+	syncDirectory = func(d string) error {
+		check("dir", d)
+		return dir(d)
+	}
+	t.Cleanup(func() { syncFile, syncDirectory = file, dir })
+}
+
+func TestAutoUpdateSyncs(t *testing.T) {
+	b := newTestBucket(t)
+	b.publish("v1.1.0", []byte(exe("new binary")))
+	target := newTarget(t, exe("old binary"))
+	var calls []string
+	stubSync(t, func(kind, path string) {
+		data, _ := ioutil.ReadFile(target)
+		switch kind {
+		case "file":
+			// the new binary is flushed before it replaces the old one
+			if contents, _ := ioutil.ReadFile(path); string(contents) != exe("new binary") {
+				t.Errorf("synced %s holding %q", path, contents)
+			}
+			if string(data) != exe("old binary") {
+				t.Error("new binary synced after the rename")
+			}
+		case "dir":
+			if path != filepath.Dir(target) {
+				t.Errorf("synced directory %s", path)
+			}
+			if string(data) != exe("new binary") {
+				t.Error("directory synced before the rename")
+			}
+			if _, err := os.Stat(target + ".bak"); runtime.GOOS != "windows" && err != nil {
+				t.Error("directory synced after the backup removal")
+			}
+		}
+		calls = append(calls, kind)
+	})
+
+	if err := AutoUpdate(b.updater(target)); err != nil {
+		t.Fatal(err)
+	}
+	assertContents(t, target, exe("new binary"))
+	if got := strings.Join(calls, ", "); got != "file, dir" {
+		t.Errorf("synced %s, want file, dir", got)
+	}
+
+	calls = nil
+	b.publish("v1.2.0", []byte(exe("newer binary")))
+	u := b.updater(target)
+	u.CurrentVersion, u.NoSync = "v1.1.0", true
+	if err := AutoUpdate(u); err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) > 0 {
+		t.Errorf("synced %v with NoSync", calls)
+	}
+}
@@ -0,0 +1,131 @@
+package s3update
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+)
+
+// Releases are signed by computing the Ed25519 signature of the SHA-256 digest of the published file,
+// i.e. the archive for archived releases. Hashing first lets large artifacts be verified while streaming.
+// The detached signature is stored base64 encoded at SignatureKey.
+
+// SignRelease returns the base64 encoded signature of the release read from r, to be uploaded at SignatureKey
+func SignRelease(key ed25519.PrivateKey, r io.Reader) ([]byte, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, err
+	}
+	sig := ed25519.Sign(key, h.Sum(nil))
+	return []byte(base64.StdEncoding.EncodeToString(sig)), nil
+}
+
+// signatureURL returns the URL of the release signature, empty when signatures aren't configured
+func signatureURL(u Updater, version string) string {
+	if u.SignatureKey == "" {
+		return ""
+	}
+	return generateURL(u, u.SignatureKey, version)
+}
+
+// parsePublicKey accepts a raw ed25519 public key or a PEM encoded PKIX one
+func parsePublicKey(b []byte) (ed25519.PublicKey, error) {
+	if len(b) == ed25519.PublicKeySize {
+		return ed25519.PublicKey(b), nil
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("public key is neither a raw ed25519 key nor PEM encoded")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing public key: %w", err)
+	}
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key is not an ed25519 key")
+	}
+	return pub, nil
+}
+
+// decodeSignature accepts raw, base64 or hex encoded signatures
+func decodeSignature(b []byte) ([]byte, error) {
+	if len(b) == ed25519.SignatureSize {
+		return b, nil
+	}
+	s := string(bytes.TrimSpace(b))
+	if sig, err := base64.StdEncoding.DecodeString(s); err == nil && len(sig) == ed25519.SignatureSize {
+		return sig, nil
+	}
+	if sig, err := hex.DecodeString(s); err == nil && len(sig) == ed25519.SignatureSize {
+		return sig, nil
+	}
+	return nil, fmt.Errorf("%w: malformed signature", ErrInvalidSignature)
+}
+
+// verifySignature fetches the detached signature at sigURL and checks it against digest,
+// the SHA-256 of the downloaded release. The decoded signature is returned once verified.
+func verifySignature(ctx context.Context, u Updater, sigURL string, digest []byte) ([]byte, error) {
+	pub, err := parsePublicKey(u.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := u.httpGet(ctx, sigURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, u.statusError(sigURL, resp)
+	}
+	body, err := readBody(sigURL, resp, maxSignatureSize)
+	if err != nil {
+		return nil, err
+	}
+	return checkSignature(pub, body, digest, sigURL)
+}
+
+// verifyLocalSignature checks the release at filename against the signature saved next to it by Download,
+// as <filename>.sig
+func verifyLocalSignature(u Updater, filename string) error {
+	pub, err := parsePublicKey(u.PublicKey)
+	if err != nil {
+		return err
+	}
+	sigFile := filename + ".sig"
+	body, err := ioutil.ReadFile(sigFile)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%w: %s not found", ErrInvalidSignature, sigFile)
+	}
+	if err != nil {
+		return err
+	}
+	h, err := hashFile(filename, ChecksumSHA256)
+	if err != nil {
+		return err
+	}
+	_, err = checkSignature(pub, body, h.Sum(nil), sigFile)
+	return err
+}
+
+// checkSignature decodes the signature read from source and checks it against digest
+func checkSignature(pub ed25519.PublicKey, body, digest []byte, source string) ([]byte, error) {
+	sig, err := decodeSignature(body)
+	if err != nil {
+		return nil, err
+	}
+	if !ed25519.Verify(pub, digest, sig) {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidSignature, source)
+	}
+	return sig, nil
+}
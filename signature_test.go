@@ -0,0 +1,98 @@
+package s3update
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+// sign publishes the signature of the release of version at mytool-<version>.sig, and returns the public key
+// verifying it
+func (b *testBucket) sign(t *testing.T, version string, release []byte) ed25519.PublicKey {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := SignRelease(priv, bytes.NewReader(release))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.put("mytool-"+version+".sig", sig)
+	return pub
+}
+
+func TestAutoUpdateSignatureOnly(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary := []byte(exe("new binary"))
+	sig, err := SignRelease(priv, bytes.NewReader(binary))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name string
+		sig  []byte
+		ok   bool
+	}{
+		{"signed", sig, true},
+		{"tampered", []byte("tampered"), false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTestBucket(t)
+			b.put("VERSION", []byte("v1.1.0"))
+			b.put("mytool-v1.1.0", binary)
+			b.put("mytool-v1.1.0.sig", tt.sig)
+			target := newTarget(t, exe("old binary"))
+			u := b.updater(target)
+			u.ChecksumKey, u.PublicKey, u.SignatureKey = "", pub, "mytool-{{VERSION}}.sig"
+
+			err := AutoUpdate(u)
+			if tt.ok {
+				if err != nil {
+					t.Fatal(err)
+				}
+				assertContents(t, target, string(binary))
+			} else {
+				if !errors.Is(err, ErrInvalidSignature) {
+					t.Fatalf("got %v, want %v", err, ErrInvalidSignature)
+				}
+				assertContents(t, target, exe("old binary"))
+			}
+			for _, r := range b.requested() {
+				if r == "GET " {
+					t.Error("the bucket root was requested as the checksum")
+				}
+			}
+		})
+	}
+}
+
+func TestAutoUpdateInsecureSkipChecksum(t *testing.T) {
+	b := newTestBucket(t)
+	b.put("VERSION", []byte("v1.1.0"))
+	b.put("mytool-v1.1.0", []byte(exe("new binary")))
+	target := newTarget(t, exe("old binary"))
+	u := b.updater(target)
+	u.ChecksumKey = ""
+
+	if err := AutoUpdate(u); !errors.Is(err, ErrMissingField) {
+		t.Fatalf("got %v, want %v", err, ErrMissingField)
+	}
+	assertContents(t, target, exe("old binary"))
+
+	u.InsecureSkipChecksum = true
+	if err := AutoUpdate(u); err != nil {
+		t.Fatal(err)
+	}
+	assertContents(t, target, exe("new binary"))
+	for _, r := range b.requested() {
+		if r == "GET " {
+			t.Error("the bucket root was requested as the checksum")
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package s3update
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Source is where releases are published, the bucket described by the S3 fields of the Updater when Updater.Source
+// is nil. Versions are passed as published, e.g. with or without their leading v.
+type Source interface {
+	// LatestVersion returns the version to update to
+	LatestVersion(ctx context.Context) (string, error)
+	// Artifact returns the release of version for the running platform along with its size, -1 when unknown
+	Artifact(ctx context.Context, version string) (io.ReadCloser, int64, error)
+	// Checksum returns the checksum of the artifact of version, in any format ChecksumKey objects may have,
+	// or an empty string when none is published
+	Checksum(ctx context.Context, version string) (string, error)
+}
+
+// ArtifactLocator is optionally implemented by a Source to tell the URL of the artifact of version. Its file name
+// selects the entry of checksum files listing several artifacts, and names the file saved by Download.
+type ArtifactLocator interface {
+	ArtifactURL(version string) (string, error)
+}
+
+// NewS3Source returns the Source AutoUpdate reads when Updater.Source is nil, described by the S3 fields of u.
+// The manifest of ManifestMode isn't supported.
+func NewS3Source(u Updater) Source {
+	return s3Source{u: u}
+}
+
+// boundSource is implemented by the sources of this package, which issue their requests with the HTTP client,
+// trusted hosts, timeouts and retries of the Updater reading them
+type boundSource interface {
+	bind(u Updater) Source
+}
+
+// source returns Source, bound to u when it's one of this package
+func (u Updater) source() Source {
+	if s, ok := u.Source.(boundSource); ok {
+		return s.bind(u)
+	}
+	return u.Source
+}
+
+// inherit returns inner, the Updater a source reads its storage with, configured with the transport, trusted
+// hosts, timeouts and retries of u. HTTPClient is only inherited when inner has none.
+func (u Updater) inherit(inner Updater) Updater {
+	if inner.HTTPClient == nil {
+		inner.HTTPClient = u.HTTPClient
+	}
+	inner.CAFile, inner.CACertPEM, inner.ProxyURL = u.CAFile, u.CACertPEM, u.ProxyURL
+	inner.AllowedHosts = append(inner.AllowedHosts[:len(inner.AllowedHosts):len(inner.AllowedHosts)], u.AllowedHosts...)
+	inner.AllowInsecureHTTP = u.AllowInsecureHTTP
+	inner.RequestTimeout, inner.DownloadTimeout = u.RequestTimeout, u.DownloadTimeout
+	inner.MaxRetries, inner.RetryBackoff = u.MaxRetries, u.RetryBackoff
+	return inner
+}
+
+type s3Source struct {
+	u Updater
+}
+
+func (s s3Source) LatestVersion(ctx context.Context) (string, error) {
+	if s.u.ManifestMode {
+		return "", errors.New("the S3 source doesn't support ManifestMode")
+	}
+	version, _, err := fetchRemoteVersion(ctx, s.u, nil)
+	return version, err
+}
+
+func (s s3Source) Artifact(ctx context.Context, version string) (io.ReadCloser, int64, error) {
+	return s.u.openObject(ctx, generateURL(s.u, s.u.releaseKey(), version))
+}
+
+func (s s3Source) Checksum(ctx context.Context, version string) (string, error) {
+	if s.u.ChecksumKey == "" {
+		return "", nil
+	}
+	checksumURL := generateURL(s.u, s.u.ChecksumKey, version)
+	resp, err := s.u.httpGet(ctx, checksumURL)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", s.u.statusError(checksumURL, resp)
+	}
+	body, err := readBody(checksumURL, resp, maxChecksumSize)
+	return string(body), err
+}
+
+func (s s3Source) ArtifactURL(version string) (string, error) {
+	return s.u.GenerateURL(s.u.releaseKey(), version)
+}
+
+// openObject starts downloading the release at url, returning its body and size
+func (u Updater) openObject(ctx context.Context, url string) (io.ReadCloser, int64, error) {
+	resp, err := u.httpDownload(ctx, url)
+	if err != nil {
+		return nil, 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, 0, u.statusError(url, resp)
+	}
+	return resp.Body, resp.ContentLength, nil
+}
+
+// openRelease starts downloading the release described by info, from Source when set
+func (u Updater) openRelease(ctx context.Context, info *UpdateInfo) (io.ReadCloser, int64, error) {
+	if u.Source == nil {
+		return u.openObject(ctx, info.DownloadURL)
+	}
+	ctx, cancel := context.WithTimeout(ctx, durationOr(u.DownloadTimeout, defaultDownloadTimeout))
+	body, size, err := u.source().Artifact(ctx, info.published())
+	if err != nil {
+		cancel()
+		return nil, 0, err
+	}
+	return &cancelBody{ReadCloser: body, cancel: cancel}, size, nil
+}
+
+// latestVersion returns the remote version as published, along with the manifest in ManifestMode. The bucket is
+// asked whether the version object changed since st when not nil.
+func (u Updater) latestVersion(ctx context.Context, st *state) (string, *Manifest, error) {
+	if u.Source == nil {
+		return fetchRemoteVersion(ctx, u, st)
+	}
+	checkCtx, cancel := context.WithTimeout(ctx, durationOr(u.CheckTimeout, defaultCheckTimeout))
+	defer cancel()
+	version, err := u.source().LatestVersion(checkCtx)
+	if err != nil {
+		if ctx.Err() == nil && isOffline(err) {
+			u.logger().Debugf("updater: skipping update check: %s", err)
+			return "", nil, fmt.Errorf("%w: %v", ErrCheckSkipped, err)
+		}
+		return "", nil, err
+	}
+	if err := u.validateVersion(u.normalize(version)); err != nil {
+		return "", nil, fmt.Errorf("%w: %v: %v", ErrInvalidRemoteVersion, excerpt(version), err)
+	}
+	return version, nil, nil
+}
+
+// sourceChecksum returns the checksum Source publishes for the release described by info
+func (u Updater) sourceChecksum(ctx context.Context, info *UpdateInfo) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, durationOr(u.RequestTimeout, defaultRequestTimeout))
+	defer cancel()
+	return u.source().Checksum(ctx, info.published())
+}
+
+// artifactURL returns the URL of the artifact of version as published, the binary name when Source doesn't tell
+func (u Updater) artifactURL(version string) string {
+	if u.Source == nil {
+		return generateURL(u, u.releaseKey(), version)
+	}
+	if l, ok := u.source().(ArtifactLocator); ok {
+		if url, err := l.ArtifactURL(version); err == nil {
+			return url
+		}
+	}
+	return u.binaryName()
+}
+
+// checksumURL returns the URL of the checksum of version as published, none when Source or ChecksumKey isn't set
+func (u Updater) checksumURL(version string) string {
+	if u.Source != nil || u.ChecksumKey == "" {
+		return ""
+	}
+	return generateURL(u, u.ChecksumKey, version)
+}
@@ -0,0 +1,72 @@
+package s3update
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"runtime"
+	"strings"
+)
+
+// extractionFactor bounds how much larger than its archive the extracted executable is expected to be
+const extractionFactor = 3
+
+// remoteSize returns the size of the release described by info according to a HEAD request, -1 when unknown, and
+// whether it can be downloaded in byte ranges. It fails fast when the release isn't published, the download being
+// attempted anyway when the server rejects HEAD requests.
+func (u Updater) remoteSize(ctx context.Context, info *UpdateInfo) (int64, bool, error) {
+	url := info.DownloadURL
+	resp, err := u.httpHead(ctx, url)
+	if err != nil {
+		u.logger().Debugf("updater: HEAD %s: %s", url, err)
+		return -1, false, nil
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusForbidden {
+		// S3 answers 403 for missing keys when the bucket can't be listed
+		return -1, false, u.missingArtifact(info, url, false)
+	}
+	if resp.StatusCode != http.StatusOK {
+		u.logger().Debugf("updater: HEAD %s: unexpected status %d", url, resp.StatusCode)
+		return -1, false, nil
+	}
+	return resp.ContentLength, resp.Header.Get("Accept-Ranges") == "bytes", nil
+}
+
+// missingArtifact reports that the object at url, the release described by info or its checksum, isn't published
+func (u Updater) missingArtifact(info *UpdateInfo, url string, checksum bool) error {
+	key := redactURL(url)
+	if base := u.ObjectURL(""); strings.HasPrefix(url, base) {
+		key = url[len(base):]
+	}
+	return &MissingArtifactError{
+		Version:  info.RemoteVersion,
+		Platform: runtime.GOOS + "/" + runtime.GOARCH,
+		Key:      key,
+		Checksum: checksum,
+	}
+}
+
+// requiredSpace returns the room needed to download and install a release of the given size.
+// An archive stays on disk while being extracted, so room is kept for its contents too.
+func requiredSpace(downloadURL string, size int64) uint64 {
+	if size <= 0 {
+		return 0
+	}
+	if isArchiveExt(path.Ext(downloadURL)) {
+		return uint64(size) * (1 + extractionFactor)
+	}
+	return uint64(size)
+}
+
+// checkSpace makes sure dir has required bytes available, the check being skipped where free space can't be found out
+func checkSpace(dir string, required uint64) error {
+	if required == 0 {
+		return nil
+	}
+	available, ok := freeSpace(dir)
+	if ok && available < required {
+		return &SpaceError{Path: dir, Required: required, Available: available}
+	}
+	return nil
+}
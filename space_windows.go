@@ -0,0 +1,22 @@
+//go:build windows
+// +build windows
+
+package s3update
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var procGetDiskFreeSpaceExW = modkernel32.NewProc("GetDiskFreeSpaceExW")
+
+// freeSpace returns the bytes available to the current user in dir
+func freeSpace(dir string) (uint64, bool) {
+	p, err := syscall.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, false
+	}
+	var available uint64
+	r, _, _ := procGetDiskFreeSpaceExW.Call(uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(&available)), 0, 0)
+	return available, r != 0
+}
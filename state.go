@@ -0,0 +1,106 @@
+package s3update
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// state is persisted between runs to avoid checking the remote version on every invocation
+type state struct {
+	LastCheck     time.Time `json:"lastCheck"`
+	RemoteVersion string    `json:"remoteVersion"`
+	// Manifest is the last manifest seen in ManifestMode
+	Manifest *Manifest `json:"manifest,omitempty"`
+	// MinVersion is the last minimum version seen
+	MinVersion string `json:"minVersion,omitempty"`
+	// YankedVersion is the last remote version found yanked by YankedKey
+	YankedVersion string `json:"yankedVersion,omitempty"`
+	// DeclinedVersion is the last update declined by the user in Confirm mode
+	DeclinedVersion string `json:"declinedVersion,omitempty"`
+	// PendingVersion is the update found in DeferInstall mode, installed by ApplyPending
+	PendingVersion string `json:"pendingVersion,omitempty"`
+	// BackupVersion is the version of the binary kept as backup by KeepBackup
+	BackupVersion string `json:"backupVersion,omitempty"`
+	// UpdatedTo is the version the binary was last updated to, at UpdatedAt
+	UpdatedTo string    `json:"updatedTo,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	// VersionETag and VersionLastModified validate RemoteVersion and Manifest, read at VersionURL
+	VersionURL          string `json:"versionURL,omitempty"`
+	VersionETag         string `json:"versionETag,omitempty"`
+	VersionLastModified string `json:"versionLastModified,omitempty"`
+}
+
+// statePath returns the location of the state file, StateFile or <user cache dir>/<binary>/s3update.json
+func (u Updater) statePath() (string, error) {
+	if u.StateFile != "" {
+		return u.StateFile, nil
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	name := strings.TrimSuffix(u.binaryName(), exeSuffix)
+	if name == "" {
+		name = "s3update"
+	}
+	return filepath.Join(dir, name, "s3update.json"), nil
+}
+
+// loadState reads the state file, a missing or corrupt file yields the zero state
+func (u Updater) loadState() state {
+	var st state
+	path, err := u.statePath()
+	if err != nil {
+		return st
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return st
+	}
+	if err := json.Unmarshal(b, &st); err != nil {
+		return state{}
+	}
+	return st
+}
+
+// saveState atomically replaces the state file
+func (u Updater) saveState(st state) error {
+	path, err := u.statePath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, b)
+}
+
+// writeFileAtomic replaces path with a file holding b, so that readers never see it partially written
+func writeFileAtomic(path string, b []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err := f.Write(b); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// forceCheck tells whether the check interval must be ignored
+func (u Updater) forceCheck() bool {
+	return u.ForceCheck || os.Getenv("S3UPDATE_FORCE_CHECK") != ""
+}
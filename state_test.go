@@ -0,0 +1,78 @@
+package s3update
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAutoUpdateVersionNotModified(t *testing.T) {
+	var (
+		mu         sync.Mutex
+		latest     = "v1.1.0"
+		conditions []string
+		served     int
+	)
+	b := newTestBucket(t)
+	b.publish("v1.1.0", []byte(exe("new binary")))
+	b.handle("VERSION", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		conditions = append(conditions, r.Header.Get("If-None-Match"))
+		etag := `"` + latest + `"`
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		served++
+		w.Write([]byte(latest + "\n"))
+	})
+	target := newTarget(t, exe("old binary"))
+	u := b.updater(target)
+	u.CurrentVersion = "v1.1.0"
+	check := func(wantCondition, wantETag string, wantServed int) state {
+		t.Helper()
+		before := time.Now()
+		if err := AutoUpdate(u); err != nil {
+			t.Fatal(err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if got := conditions[len(conditions)-1]; got != wantCondition {
+			t.Errorf("sent If-None-Match %q, want %q", got, wantCondition)
+		}
+		if served != wantServed {
+			t.Errorf("version served %d times, want %d", served, wantServed)
+		}
+		st := u.loadState()
+		if st.VersionETag != wantETag || st.LastCheck.Before(before) {
+			t.Errorf("got state %+v, want ETag %s checked since %s", st, wantETag, before.Format(time.RFC3339Nano))
+		}
+		return st
+	}
+
+	check("", `"v1.1.0"`, 1)
+	if st := check(`"v1.1.0"`, `"v1.1.0"`, 1); st.RemoteVersion != "v1.1.0" {
+		t.Errorf("remote version %s, want v1.1.0", st.RemoteVersion)
+	}
+	if downloads(b) != 0 {
+		t.Fatalf("release downloaded %d times while up to date", downloads(b))
+	}
+
+	// the cached version is installed when the running one is older
+	u.CurrentVersion = "v1.0.0"
+	check(`"v1.1.0"`, `"v1.1.0"`, 1)
+	assertContents(t, target, exe("new binary"))
+
+	mu.Lock()
+	latest = "v1.2.0"
+	mu.Unlock()
+	b.publish("v1.2.0", []byte(exe("newer binary")))
+	u.CurrentVersion = "v1.1.0"
+	if st := check(`"v1.1.0"`, `"v1.2.0"`, 2); st.RemoteVersion != "v1.2.0" {
+		t.Errorf("remote version %s, want v1.2.0", st.RemoteVersion)
+	}
+	assertContents(t, target, exe("newer binary"))
+}
@@ -0,0 +1,62 @@
+package s3update
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// Supported values for Updater.SymlinkMode
+const (
+	// SymlinkFollow replaces the file the executable symlink points to
+	SymlinkFollow = "follow"
+	// SymlinkReplaceLink replaces the symlink itself with the new binary
+	SymlinkReplaceLink = "replace-link"
+	// SymlinkInstallVersioned installs the new binary as <versions dir>/<version>/<name>, next to the
+	// directory of the current one, and retargets the symlink to it
+	SymlinkInstallVersioned = "install-versioned"
+)
+
+// linkPath returns the unresolved path the binary was installed at.
+// The running executable path is fully resolved on some platforms, so its symlink is looked up from os.Args[0].
+func (u Updater) linkPath() (string, error) {
+	if u.TargetPath != "" {
+		return filepath.Abs(u.TargetPath)
+	}
+	p, err := exec.LookPath(os.Args[0])
+	if err != nil {
+		return "", fmt.Errorf("locating %s: %w", os.Args[0], err)
+	}
+	return filepath.Abs(p)
+}
+
+// versionedPath returns where SymlinkInstallVersioned installs version of the binary link points to
+func versionedPath(link, version string) (string, error) {
+	fi, err := os.Lstat(link)
+	if err != nil {
+		return "", err
+	}
+	if fi.Mode()&os.ModeSymlink == 0 {
+		return "", fmt.Errorf("%s is not a symlink, as required by %s", link, SymlinkInstallVersioned)
+	}
+	current, err := filepath.EvalSymlinks(link)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(filepath.Dir(current)), version, filepath.Base(current)), nil
+}
+
+// retargetLink atomically points link to dest
+func retargetLink(link, dest string) error {
+	tmp := link + ".tmp-link"
+	os.Remove(tmp)
+	if err := os.Symlink(dest, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, link); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package s3update
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestAutoUpdateSymlinkModes(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need privileges on windows")
+	}
+	tests := []struct {
+		mode string
+		// installed is where the new binary ends up, relative to the temp dir
+		installed string
+		// linked is set when the link still is a symlink
+		linked bool
+	}{
+		{"", "opt/mytool/1.0.0/mytool", true},
+		{SymlinkFollow, "opt/mytool/1.0.0/mytool", true},
+		{SymlinkReplaceLink, "bin/mytool", false},
+		{SymlinkInstallVersioned, "opt/mytool/v1.1.0/mytool", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			dir := t.TempDir()
+			current := filepath.Join(dir, "opt", "mytool", "1.0.0", "mytool")
+			link := filepath.Join(dir, "bin", "mytool")
+			for _, d := range []string{filepath.Dir(current), filepath.Dir(link)} {
+				if err := os.MkdirAll(d, 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := ioutil.WriteFile(current, []byte(exe("old binary")), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Symlink(current, link); err != nil {
+				t.Fatal(err)
+			}
+			b := newTestBucket(t)
+			b.publish("v1.1.0", []byte(exe("new binary")))
+			u := b.updater(link)
+			u.SymlinkMode = tt.mode
+
+			if err := AutoUpdate(u); err != nil {
+				t.Fatal(err)
+			}
+			installed := filepath.Join(dir, filepath.FromSlash(tt.installed))
+			assertContents(t, installed, exe("new binary"))
+			assertContents(t, link, exe("new binary"))
+			if installed != current {
+				assertContents(t, current, exe("old binary"))
+			}
+			fi, err := os.Lstat(link)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if linked := fi.Mode()&os.ModeSymlink != 0; linked != tt.linked {
+				t.Errorf("%s is a symlink: %v, want %v", link, linked, tt.linked)
+			}
+			if tt.linked {
+				if dest, _ := filepath.EvalSymlinks(link); dest != installed {
+					t.Errorf("%s points to %s, want %s", link, dest, installed)
+				}
+			}
+		})
+	}
+}
+
+func TestInstallVersionedNeedsSymlink(t *testing.T) {
+	b := newTestBucket(t)
+	b.publish("v1.1.0", []byte(exe("new binary")))
+	target := newTarget(t, exe("old binary"))
+	u := b.updater(target)
+	u.SymlinkMode = SymlinkInstallVersioned
+
+	if err := AutoUpdate(u); err == nil {
+		t.Fatal("installed a version next to a regular file")
+	}
+	assertContents(t, target, exe("old binary"))
+}
@@ -0,0 +1,69 @@
+package s3update
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// tempDir returns the directory receiving the temporary files of the update of dest
+func (u Updater) tempDir(dest string) string {
+	if u.TempDir != "" {
+		return u.TempDir
+	}
+	return filepath.Dir(dest)
+}
+
+// tempPattern names the temporary files holding the release of dest, for ioutil.TempFile
+func tempPattern(dest string) string {
+	return "." + filepath.Base(dest) + ".*.tmp" + exeSuffix
+}
+
+// removeStaleTemps deletes the temporary files left in dir by interrupted updates of dest,
+// extraction files included. It must be called with the update lock held.
+func removeStaleTemps(dir, dest string) {
+	matches, _ := filepath.Glob(filepath.Join(dir, "."+filepath.Base(dest)+".*.tmp*"))
+	probes, _ := filepath.Glob(filepath.Join(dir, ".s3update.*.tmp"))
+	for _, m := range append(matches, probes...) {
+		os.Remove(m)
+	}
+}
+
+// moveToDir moves filename to a temporary file next to dest and returns its path.
+// The file gets copied when the rename fails, as it does across filesystems.
+func moveToDir(filename, dest string) (string, error) {
+	w, err := ioutil.TempFile(filepath.Dir(dest), tempPattern(dest))
+	if err != nil {
+		return "", fmt.Errorf("creating temporary file: %w", err)
+	}
+	w.Close()
+	if err := os.Rename(filename, w.Name()); err == nil {
+		return w.Name(), nil
+	}
+	if err := copyFile(filename, w.Name()); err != nil {
+		os.Remove(w.Name())
+		return "", fmt.Errorf("copying %s to %s: %w", filename, filepath.Dir(dest), err)
+	}
+	os.Remove(filename)
+	return w.Name(), nil
+}
+
+// copyFile replaces the contents of dst with those of src
+func copyFile(src, dst string) error {
+	r, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	w, err := os.OpenFile(dst, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
@@ -0,0 +1,88 @@
+package s3update
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// throttleSlices is how many reads a second a throttled download is split into, so that progress keeps moving
+const throttleSlices = 10
+
+// rateLimiter is a token bucket shared by the downloads of a run, a patch and the release falling back to it
+// being throttled together
+type rateLimiter struct {
+	sync.Mutex
+	rate   int64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate int64) *rateLimiter {
+	return &rateLimiter{rate: rate, tokens: float64(rate) / throttleSlices, last: time.Now()}
+}
+
+// burst is the most that can be read at once
+func (l *rateLimiter) burst() int {
+	if b := l.rate / throttleSlices; b > 0 {
+		return int(b)
+	}
+	return 1
+}
+
+// take consumes n bytes worth of tokens, waiting until they're earned or ctx is done
+func (l *rateLimiter) take(ctx context.Context, n int) error {
+	l.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * float64(l.rate)
+	if max := float64(l.burst()); l.tokens > max {
+		l.tokens = max
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	wait := time.Duration(-l.tokens / float64(l.rate) * float64(time.Second))
+	l.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// throttle returns r limited to MaxBytesPerSecond, r itself when unlimited
+func (u Updater) throttle(ctx context.Context, r io.Reader) io.Reader {
+	if u.MaxBytesPerSecond <= 0 {
+		return r
+	}
+	l := u.limiter
+	if l == nil {
+		l = newRateLimiter(u.MaxBytesPerSecond)
+	}
+	return &throttledReader{ctx: ctx, r: r, l: l}
+}
+
+type throttledReader struct {
+	ctx context.Context
+	r   io.Reader
+	l   *rateLimiter
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if b := t.l.burst(); len(p) > b {
+		p = p[:b]
+	}
+	n, err := t.r.Read(p)
+	if n > 0 {
+		if werr := t.l.take(t.ctx, n); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,64 @@
+package s3update
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestAutoUpdateThrottled(t *testing.T) {
+	tests := []struct {
+		name        string
+		size        int
+		concurrency int
+	}{
+		{"single request", 256 << 10, 0},
+		// the parts share the limit
+		{"parallel parts", minParallelSize, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTestBucket(t)
+			binary := append([]byte(exe("")), bytes.Repeat([]byte{'x'}, tt.size)...)
+			b.publish("v1.1.0", binary)
+			target := newTarget(t, exe("old binary"))
+			u := b.updater(target)
+			// the release takes about a second to download
+			u.MaxBytesPerSecond = int64(len(binary))
+			u.Concurrency = tt.concurrency
+
+			start := time.Now()
+			if err := AutoUpdate(u); err != nil {
+				t.Fatal(err)
+			}
+			elapsed := time.Since(start)
+			assertContents(t, target, string(binary))
+			// the bucket starts full with a tenth of a second worth of tokens
+			if elapsed < 800*time.Millisecond || elapsed > 2*time.Second {
+				t.Errorf("downloaded %d bytes at %d bytes/s in %s", len(binary), u.MaxBytesPerSecond, elapsed)
+			}
+		})
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(100 << 10)
+	if b := l.burst(); b != 10<<10 {
+		t.Errorf("burst of %d bytes", b)
+	}
+	if b := newRateLimiter(5).burst(); b != 1 {
+		t.Errorf("burst of %d bytes below 10 bytes/s", b)
+	}
+
+	start := time.Now()
+	u := Updater{MaxBytesPerSecond: l.rate, limiter: l}
+	r := u.throttle(context.Background(), bytes.NewReader(make([]byte, 50<<10)))
+	n, err := bytes.NewBuffer(nil).ReadFrom(r)
+	if err != nil || n != 50<<10 {
+		t.Fatalf("read %d bytes: %v", n, err)
+	}
+	if elapsed := time.Since(start); elapsed < 350*time.Millisecond || elapsed > time.Second {
+		t.Errorf("read 50 KiB at 100 KiB/s in %s", elapsed)
+	}
+}
@@ -0,0 +1,101 @@
+package s3update
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// tlsHint is suggested when the certificate of a server isn't trusted, e.g. behind a TLS-intercepting proxy
+const tlsHint = "the certificate isn't trusted, set CAFile or CACertPEM to the CA of the proxy intercepting TLS"
+
+// customClients are the clients built for CAFile, CACertPEM and ProxyURL, by configuration, so that the requests
+// of every run reuse connections like those of defaultHTTPClient
+var customClients = struct {
+	sync.Mutex
+	m map[string]*http.Client
+}{m: map[string]*http.Client{}}
+
+// customTransport tells whether requests need a transport other than that of defaultHTTPClient
+func (u Updater) customTransport() bool {
+	return u.CAFile != "" || len(u.CACertPEM) > 0 || u.ProxyURL != ""
+}
+
+// customClient returns the client honoring CAFile, CACertPEM and ProxyURL, building it on first use
+func (u Updater) customClient() (*http.Client, error) {
+	key := u.CAFile + "\x00" + string(u.CACertPEM) + "\x00" + u.ProxyURL
+	customClients.Lock()
+	defer customClients.Unlock()
+	if c, ok := customClients.m[key]; ok {
+		return c, nil
+	}
+	t := defaultHTTPClient.Transport.(*http.Transport).Clone()
+	if u.ProxyURL != "" {
+		proxy, err := parseProxyURL(u.ProxyURL)
+		if err != nil {
+			return nil, err
+		}
+		t.Proxy = http.ProxyURL(proxy)
+	}
+	if u.CAFile != "" || len(u.CACertPEM) > 0 {
+		pool, err := u.certPool()
+		if err != nil {
+			return nil, err
+		}
+		t.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	c := &http.Client{Transport: t}
+	customClients.m[key] = c
+	return c, nil
+}
+
+// certPool returns the system certificates along with those of CAFile and CACertPEM
+func (u Updater) certPool() (*x509.CertPool, error) {
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if u.CAFile != "" {
+		pem, err := ioutil.ReadFile(u.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA certificates: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no PEM certificate found in %s", u.CAFile)
+		}
+	}
+	if len(u.CACertPEM) > 0 && !pool.AppendCertsFromPEM(u.CACertPEM) {
+		return nil, errors.New("no PEM certificate found in CACertPEM")
+	}
+	return pool, nil
+}
+
+// parseProxyURL parses the URL of a proxy, such as http://proxy.example.com:3128
+func parseProxyURL(raw string) (*url.URL, error) {
+	p, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	switch p.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("must be an http(s) or socks5 URL: %s", redactURL(raw))
+	}
+	if p.Host == "" {
+		return nil, fmt.Errorf("missing host: %s", redactURL(raw))
+	}
+	return p, nil
+}
+
+// untrustedCertificate tells whether err comes from a server certificate failing verification
+func untrustedCertificate(err error) bool {
+	var unknown x509.UnknownAuthorityError
+	var invalid x509.CertificateInvalidError
+	var hostname x509.HostnameError
+	return errors.As(err, &unknown) || errors.As(err, &invalid) || errors.As(err, &hostname)
+}
@@ -0,0 +1,294 @@
+package s3update
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestCA returns the PEM certificate of a new CA, along with the certificate it issued for 127.0.0.1
+func newTestCA(t *testing.T) ([]byte, tls.Certificate) {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "s3update test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leaf, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}),
+		tls.Certificate{Certificate: [][]byte{leafDER}, PrivateKey: key}
+}
+
+// newTLSBucket is like newTestBucket, served over https with cert
+func newTLSBucket(t *testing.T, cert tls.Certificate) *testBucket {
+	b := &testBucket{objects: map[string][]byte{}, handlers: map[string]http.HandlerFunc{}}
+	b.Server = httptest.NewUnstartedServer(http.HandlerFunc(b.serve))
+	b.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	// handshakes failing on purpose aren't worth logging
+	b.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	b.StartTLS()
+	t.Cleanup(b.Close)
+	return b
+}
+
+func TestCustomCA(t *testing.T) {
+	caPEM, cert := newTestCA(t)
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := ioutil.WriteFile(caFile, caPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+	notPEM := filepath.Join(t.TempDir(), "ca.der")
+	if err := ioutil.WriteFile(notPEM, []byte("not a certificate"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		set  func(u *Updater)
+		err  string
+	}{
+		{"untrusted", func(u *Updater) {}, tlsHint},
+		{"CACertPEM", func(u *Updater) { u.CACertPEM = caPEM }, ""},
+		{"CAFile", func(u *Updater) { u.CAFile = caFile }, ""},
+		{"missing CAFile", func(u *Updater) { u.CAFile = filepath.Join(t.TempDir(), "missing.pem") }, "reading CA certificates"},
+		{"CAFile without certificate", func(u *Updater) { u.CAFile = notPEM }, "no PEM certificate found in " + notPEM},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTLSBucket(t, cert)
+			b.publish("v1.1.0", []byte(exe("new binary")))
+			target := newTarget(t, exe("old binary"))
+			u := b.updater(target)
+			u.AllowInsecureHTTP = false
+			tt.set(&u)
+
+			err := AutoUpdate(u)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				assertContents(t, target, exe("new binary"))
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("got %v, want %q", err, tt.err)
+			}
+			assertContents(t, target, exe("old binary"))
+		})
+	}
+}
+
+// publishGitHubRelease makes b answer as the GitHub API with the release v1.1.0 of automato-io/mytool, whose
+// asset downloads are redirected to assets
+func publishGitHubRelease(b, assets *testBucket, binary []byte) {
+	assets.put("assets/mytool", binary)
+	b.handle("repos/automato-io/mytool/releases/assets/1", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, assets.URL+"/assets/mytool", http.StatusFound)
+	})
+	release, _ := json.Marshal(githubRelease{
+		TagName: "v1.1.0",
+		Assets: []githubAsset{{Name: "mytool", URL: b.URL + "/repos/automato-io/mytool/releases/assets/1",
+			Size: int64(len(binary)), Digest: "sha256:" + sha256Hex(string(binary))}},
+	})
+	b.put("repos/automato-io/mytool/releases/latest", release)
+	b.put("repos/automato-io/mytool/releases/tags/v1.1.0", release)
+}
+
+func testGitHubSource(b *testBucket) GitHubSource {
+	return GitHubSource{Owner: "automato-io", Repo: "mytool", AssetTemplate: "mytool", APIURL: b.URL}
+}
+
+// testSources returns the sources of this package reading the releases published to b
+func testSources(b *testBucket) map[string]Source {
+	publishGitHubRelease(b, b, []byte(exe("new binary")))
+	b.publishAt("releases/", "v1.1.0", []byte(exe("new binary")))
+	return map[string]Source{
+		"GCS":    testGCSSource(b),
+		"Azure":  testAzureSource(b, ""),
+		"GitHub": testGitHubSource(b),
+	}
+}
+
+func TestSourcesCustomCA(t *testing.T) {
+	caPEM, cert := newTestCA(t)
+	for name := range testSources(newTestBucket(t)) {
+		t.Run(name, func(t *testing.T) {
+			for _, trusted := range []bool{false, true} {
+				b := newTLSBucket(t, cert)
+				src := testSources(b)[name]
+				target := newTarget(t, exe("old binary"))
+				u := b.sourceUpdater(target, src)
+				u.AllowInsecureHTTP = false
+				if trusted {
+					u.CACertPEM = caPEM
+				}
+
+				err := AutoUpdate(u)
+				if trusted && err != nil {
+					t.Fatal(err)
+				}
+				if !trusted {
+					var de *DownloadError
+					if !errors.As(err, &de) || de.Hint != tlsHint {
+						t.Fatalf("got %v, want the hint %q", err, tlsHint)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestProxyURL(t *testing.T) {
+	var proxied int32
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&proxied, 1)
+		// proxies are sent the absolute URL
+		if !r.URL.IsAbs() {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		out := r.Clone(r.Context())
+		out.RequestURI = ""
+		resp, err := http.DefaultTransport.RoundTrip(out)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		for k, v := range resp.Header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+	}))
+	defer proxy.Close()
+
+	sources := testSources(newTestBucket(t))
+	sources["S3"] = nil
+	for name := range sources {
+		t.Run(name, func(t *testing.T) {
+			atomic.StoreInt32(&proxied, 0)
+			b := newTestBucket(t)
+			b.publish("v1.1.0", []byte(exe("new binary")))
+			target := newTarget(t, exe("old binary"))
+			u := b.updater(target)
+			if src := testSources(b)[name]; src != nil {
+				u = b.sourceUpdater(target, src)
+			}
+			u.ProxyURL = proxy.URL
+
+			if err := AutoUpdate(u); err != nil {
+				t.Fatal(err)
+			}
+			assertContents(t, target, exe("new binary"))
+			if n, requests := atomic.LoadInt32(&proxied), len(b.requested()); int(n) != requests || n == 0 {
+				t.Errorf("%d requests out of %d went through the proxy", n, requests)
+			}
+		})
+	}
+}
+
+func TestSourcesRetries(t *testing.T) {
+	for name := range testSources(newTestBucket(t)) {
+		t.Run(name, func(t *testing.T) {
+			for _, retries := range []int{0, 1} {
+				b := newTestBucket(t)
+				src := testSources(b)
+				// every object is unavailable at first
+				var failed sync.Map
+				for _, key := range []string{"releases/VERSION", "repos/automato-io/mytool/releases/latest"} {
+					key := key
+					data := b.objects[key]
+					b.handle(key, func(w http.ResponseWriter, r *http.Request) {
+						if _, loaded := failed.LoadOrStore(key, true); !loaded {
+							w.WriteHeader(http.StatusServiceUnavailable)
+							return
+						}
+						w.Write(data)
+					})
+				}
+				target := newTarget(t, exe("old binary"))
+				u := b.sourceUpdater(target, src[name])
+				u.MaxRetries, u.RetryBackoff = retries, time.Millisecond
+
+				err := AutoUpdate(u)
+				if retries == 0 {
+					var de *DownloadError
+					if !errors.As(err, &de) || de.StatusCode != http.StatusServiceUnavailable {
+						t.Errorf("without retries: got %v, want a 503", err)
+					}
+				} else if err != nil {
+					t.Errorf("with %d retries: %v", retries, err)
+				}
+			}
+		})
+	}
+}
+
+func TestGitHubSourceAllowedHosts(t *testing.T) {
+	for _, allowed := range []bool{false, true} {
+		api, assets := newTestBucket(t), newTestBucket(t)
+		publishGitHubRelease(api, assets, []byte(exe("new binary")))
+		target := newTarget(t, exe("old binary"))
+		u := api.sourceUpdater(target, testGitHubSource(api))
+		if allowed {
+			u.AllowedHosts = []string{strings.TrimPrefix(assets.URL, "http://")}
+		}
+
+		err := AutoUpdate(u)
+		if allowed {
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertContents(t, target, exe("new binary"))
+		} else if !errors.Is(err, ErrUntrustedHost) {
+			t.Fatalf("got %v, want %v", err, ErrUntrustedHost)
+		}
+	}
+}
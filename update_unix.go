@@ -0,0 +1,36 @@
+//go:build !windows
+// +build !windows
+
+package s3update
+
+import (
+	"os"
+	"syscall"
+)
+
+// exeSuffix is substituted to the {{EXT}} placeholder of key templates
+const exeSuffix = ""
+
+// restart replaces the current process with a run of target with args and env, a variable so that tests can
+// observe it
+var restart = func(target string, args, env []string) error {
+	return syscall.Exec(target, append([]string{target}, args...), env)
+}
+
+// syncDirectory flushes the entries of dir to stable storage, a variable so that tests can observe it
+var syncDirectory = func(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+// removeStaleBackup is a no-op on unix, where backups are removed right after the update
+func removeStaleBackup(u Updater) {}
+
+// restoreBackup renames backup over target, which may be running
+func restoreBackup(backup, target string) error {
+	return os.Rename(backup, target)
+}
@@ -0,0 +1,68 @@
+//go:build windows
+// +build windows
+
+package s3update
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// exeSuffix is substituted to the {{EXT}} placeholder of key templates
+const exeSuffix = ".exe"
+
+// restart runs target with args and env and exits with its status,
+// since a running process can't be replaced in place on windows. It's a variable so that tests can observe it.
+var restart = func(target string, args, env []string) error {
+	cmd := exec.Command(target, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = env
+	if err := cmd.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			os.Exit(exitErr.ExitCode())
+		}
+		return err
+	}
+	os.Exit(0)
+	return nil
+}
+
+// syncDirectory is a no-op on windows, where directories can't be flushed and renames are journaled by NTFS.
+// It's a variable so that tests can observe it.
+var syncDirectory = func(dir string) error {
+	return nil
+}
+
+// removeStaleBackup deletes the backup left behind by a previous update unless KeepBackup is set:
+// a running executable can be renamed but not deleted, so it only goes away on the next start
+func removeStaleBackup(u Updater) {
+	if u.KeepBackup {
+		return
+	}
+	target, err := u.targetPath()
+	if err != nil {
+		return
+	}
+	if os.Remove(target+".bak") == nil {
+		u.logger().Debugf("updater: removed stale backup %s.bak", target)
+	}
+}
+
+// restoreBackup renames backup over target like installFile does: a running executable can't be replaced but can be
+// moved aside, the moved file being removed with the other temporary files of target once it stopped running
+func restoreBackup(backup, target string) error {
+	aside := filepath.Join(filepath.Dir(target), "."+filepath.Base(target)+".rollback.tmp"+exeSuffix)
+	os.Remove(aside)
+	if err := os.Rename(target, aside); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Rename(backup, target); err != nil {
+		os.Rename(aside, target)
+		return err
+	}
+	os.Remove(aside)
+	return nil
+}
@@ -0,0 +1,77 @@
+package s3update
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// UpdateTo installs the given version, written as published, whatever the remote version. An older version is only
+// installed when AllowDowngrade is set, and the current one or a yanked one when ForceUpdate is set. The updated
+// binary is re-run like AutoUpdate does, NoRestart and NoExit having the same effect: ErrUpdated is returned instead
+// when NoExit is set.
+func UpdateTo(u Updater, version string) error {
+	return UpdateToContext(context.Background(), u, version)
+}
+
+// UpdateToContext is like UpdateTo but aborts the download when ctx is done.
+func UpdateToContext(ctx context.Context, u Updater, version string) error {
+	u = u.withEnv()
+	if err := u.Validate(); err != nil {
+		return err
+	}
+	if u.ManifestMode {
+		return errors.New("UpdateTo isn't supported in ManifestMode, the manifest only describes the latest version")
+	}
+	current, target := u.normalize(u.CurrentVersion), u.normalize(version)
+	if err := u.validateVersion(current); err != nil {
+		return fmt.Errorf("%w: %v: %v", ErrInvalidLocalVersion, u.CurrentVersion, err)
+	}
+	if err := u.validateVersion(target); err != nil {
+		return fmt.Errorf("invalid version %s: %v", version, err)
+	}
+	switch c := u.compareVersions(current, target); {
+	case c == 0 && !u.ForceUpdate:
+		return ErrUpToDate
+	case c > 0 && !u.AllowDowngrade:
+		return fmt.Errorf("%s is older than %s, set AllowDowngrade to install it", target, current)
+	}
+	if !u.ForceUpdate {
+		reason, err := yankReason(ctx, u, nil, target)
+		if err != nil {
+			return err
+		}
+		if reason != "" {
+			return fmt.Errorf("not installing %s: %s", target, reason)
+		}
+	}
+
+	info := &UpdateInfo{
+		CurrentVersion:  current,
+		RemoteVersion:   target,
+		DownloadURL:     u.artifactURL(version),
+		ChecksumURL:     u.checksumURL(version),
+		SignatureURL:    signatureURL(u, version),
+		NotesURL:        notesURL(u, version),
+		UpdateAvailable: true,
+		rawVersion:      version,
+	}
+	u.logger().Infof("installing %s over %s", target, current)
+	if err := downloadUpdate(ctx, u, info, &UpdateResult{FromVersion: current, ToVersion: target}); err != nil {
+		return err
+	}
+	return exitUpdated(u)
+}
+
+// exitUpdated ends the process once the running binary got updated and re-run, unless NoExit is set
+func exitUpdated(u Updater) error {
+	if u.TargetPath != "" {
+		return nil
+	}
+	if u.NoExit {
+		return ErrUpdated
+	}
+	os.Exit(0)
+	return nil
+}
@@ -0,0 +1,131 @@
+package s3update
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestExitUpdatedNoExit(t *testing.T) {
+	deferred := false
+	err := func() error {
+		defer func() { deferred = true }()
+		return exitUpdated(Updater{NoExit: true})
+	}()
+	if err != ErrUpdated {
+		t.Errorf("got %v, want %v", err, ErrUpdated)
+	}
+	if !deferred {
+		t.Error("deferred function didn't run")
+	}
+}
+
+func TestExitUpdatedExits(t *testing.T) {
+	if os.Getenv("S3UPDATE_TEST_EXIT") != "" {
+		exitUpdated(Updater{})
+		fmt.Print("still running")
+		os.Exit(2)
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestExitUpdatedExits$")
+	cmd.Env = append(os.Environ(), "S3UPDATE_TEST_EXIT=1")
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	if len(out) > 0 {
+		t.Errorf("process kept running after the update: %q", out)
+	}
+}
+
+func TestExitUpdatedOtherTarget(t *testing.T) {
+	// another binary than the running one got updated
+	if err := exitUpdated(Updater{TargetPath: "mytool"}); err != nil {
+		t.Errorf("got %v, want nil", err)
+	}
+}
+
+// runningTarget makes target the running executable, as when TargetPath isn't set
+func runningTarget(t *testing.T, target string) {
+	orig := executable
+	executable = func() (string, error) { return target, nil }
+	t.Cleanup(func() { executable = orig })
+}
+
+func TestAutoUpdateNoExitReturns(t *testing.T) {
+	b := newTestBucket(t)
+	b.publish("v1.1.0", []byte(exe("new binary")))
+	target := newTarget(t, exe("old binary"))
+	runningTarget(t, target)
+	restarted := stubRestart(t, 4242)
+	u := b.updater(target)
+	u.TargetPath, u.NoExit = "", true
+
+	deferred := false
+	err := func() error {
+		defer func() { deferred = true }()
+		return AutoUpdate(u)
+	}()
+	if !errors.Is(err, ErrUpdated) {
+		t.Fatalf("got %v, want %v", err, ErrUpdated)
+	}
+	if len(*restarted) > 0 {
+		t.Errorf("restarted %v", *restarted)
+	}
+	if !deferred {
+		t.Error("deferred function didn't run")
+	}
+	assertContents(t, target, exe("new binary"))
+}
+
+func TestUpdateTo(t *testing.T) {
+	b := newTestBucket(t)
+	b.publish("v0.9.0", []byte(exe("older binary")))
+	b.publish("v1.2.0", []byte(exe("latest binary")))
+	target := newTarget(t, exe("old binary"))
+	u := b.updater(target)
+
+	if err := UpdateTo(u, "v0.9.0"); err == nil || !strings.Contains(err.Error(), "AllowDowngrade") {
+		t.Fatalf("got %v, want a downgrade refused", err)
+	}
+	assertContents(t, target, exe("old binary"))
+	u.AllowDowngrade = true
+	if err := UpdateTo(u, "v0.9.0"); err != nil {
+		t.Fatal(err)
+	}
+	assertContents(t, target, exe("older binary"))
+	if err := UpdateTo(u, "v1.0.0"); err != ErrUpToDate {
+		t.Errorf("got %v, want %v", err, ErrUpToDate)
+	}
+}
+
+func TestUpdateToUnknownVersion(t *testing.T) {
+	b := newTestBucket(t)
+	b.publish("v1.1.0", []byte(exe("new binary")))
+	target := newTarget(t, exe("old binary"))
+	u := b.updater(target)
+
+	if err := UpdateTo(u, "v1.5.0"); !errors.Is(err, ErrMissingArtifact) {
+		t.Errorf("got %v, want %v", err, ErrMissingArtifact)
+	}
+	if err := UpdateTo(u, "latest"); err == nil {
+		t.Error("invalid version accepted")
+	}
+	assertContents(t, target, exe("old binary"))
+}
+
+func TestUpdateToVerifiesChecksum(t *testing.T) {
+	b := newTestBucket(t)
+	b.publish("v1.1.0", []byte(exe("new binary")))
+	b.publish("v1.2.0", []byte(exe("latest binary")))
+	b.put("mytool-v1.1.0", []byte(exe("tampered binary")))
+	target := newTarget(t, exe("old binary"))
+
+	if err := UpdateTo(b.updater(target), "v1.1.0"); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("got %v, want %v", err, ErrChecksumMismatch)
+	}
+	assertContents(t, target, exe("old binary"))
+	assertAlone(t, target)
+}
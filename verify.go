@@ -0,0 +1,55 @@
+package s3update
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// defaultVerifyTimeout bounds the smoke test of the new binary when VerifyTimeout isn't set
+const defaultVerifyTimeout = 10 * time.Second
+
+// maxVerifyOutput caps how much of the smoke test and post-install output ends up in errors
+const maxVerifyOutput = 4096
+
+// smokeTest runs filename with VerifyCommand as arguments, failing when it doesn't exit successfully in time
+func smokeTest(ctx context.Context, u Updater, filename string) error {
+	return runCommand(ctx, u, ErrVerifyFailed, filename, u.VerifyCommand...)
+}
+
+// postInstall runs PostInstallCommand with filename as last argument, failing when it doesn't exit successfully
+// in time
+func postInstall(ctx context.Context, u Updater, filename string) error {
+	args := append(append([]string(nil), u.PostInstallCommand[1:]...), filename)
+	return runCommand(ctx, u, ErrPostInstallFailed, u.PostInstallCommand[0], args...)
+}
+
+// runCommand runs name with args within VerifyTimeout, its failure wrapping sentinel along with its output
+func runCommand(ctx context.Context, u Updater, sentinel error, name string, args ...string) error {
+	timeout := u.VerifyTimeout
+	if timeout <= 0 {
+		timeout = defaultVerifyTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err := cmd.Run()
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %s", timeout)
+	}
+	output := out.String()
+	if len(output) > maxVerifyOutput {
+		output = output[:maxVerifyOutput] + "..."
+	}
+	return fmt.Errorf("%w: running %s %s: %v: %s", sentinel, name, strings.Join(args, " "), err, strings.TrimSpace(output))
+}
@@ -0,0 +1,96 @@
+package s3update
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestAutoUpdatePostInstall(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("runs a shell script")
+	}
+	b := newTestBucket(t)
+	b.publish("v1.1.0", []byte(exe("new binary")))
+	target := newTarget(t, exe("old binary"))
+	u := b.updater(target)
+	// the new binary, next to the target, is appended to before replacing it
+	u.PostInstallCommand = []string{"sh", "-c", `test "$(dirname "$1")" = "$0" && echo signed >> "$1"`, filepath.Dir(target)}
+
+	if err := AutoUpdate(u); err != nil {
+		t.Fatal(err)
+	}
+	assertContents(t, target, exe("new binary")+"signed\n")
+	assertAlone(t, target)
+}
+
+func TestAutoUpdatePostInstallFails(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("runs a shell script")
+	}
+	b := newTestBucket(t)
+	b.publish("v1.1.0", []byte(exe("new binary")))
+	target := newTarget(t, exe("old binary"))
+	u := b.updater(target)
+	u.PostInstallCommand = []string{"sh", "-c", "echo codesign failed; exit 3"}
+
+	err := AutoUpdate(u)
+	if !errors.Is(err, ErrPostInstallFailed) || !strings.Contains(err.Error(), "codesign failed") {
+		t.Fatalf("got %v, want %v with the output of the command", err, ErrPostInstallFailed)
+	}
+	assertContents(t, target, exe("old binary"))
+	assertAlone(t, target)
+}
+
+// recordingScript returns a release script recording the path it runs from to record, and exiting with status
+func recordingScript(record string, status int) string {
+	return exe(fmt.Sprintf("/bin/sh\necho \"$0 $*\" > %s\necho broken\nexit %d\n", record, status))
+}
+
+func TestAutoUpdateVerifyCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("runs a shell script")
+	}
+	for _, status := range []int{0, 1} {
+		t.Run(fmt.Sprintf("exit %d", status), func(t *testing.T) {
+			b := newTestBucket(t)
+			record := filepath.Join(t.TempDir(), "ran")
+			release := recordingScript(record, status)
+			b.publish("v1.1.0", []byte(release))
+			target := newTarget(t, exe("old binary"))
+			u := b.updater(target)
+			u.VerifyCommand = []string{"--version"}
+
+			err := AutoUpdate(u)
+			if status == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				assertContents(t, target, release)
+			} else {
+				if !errors.Is(err, ErrVerifyFailed) || !strings.Contains(err.Error(), "broken") {
+					t.Fatalf("got %v, want %v with the output of the command", err, ErrVerifyFailed)
+				}
+				assertContents(t, target, exe("old binary"))
+			}
+			assertAlone(t, target)
+
+			// the staged release ran, next to the target
+			ran, err := ioutil.ReadFile(record)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fields := strings.Fields(string(ran))
+			if len(fields) != 2 || fields[1] != "--version" {
+				t.Fatalf("ran %q", ran)
+			}
+			if fields[0] == target || filepath.Dir(fields[0]) != filepath.Dir(target) {
+				t.Errorf("ran %s rather than the staged release next to %s", fields[0], target)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package s3update
+
+import (
+	"errors"
+	"strings"
+
+	"golang.org/x/mod/semver"
+)
+
+// shouldUpdate tells whether remote is worth installing over local according to the Updater policy
+func shouldUpdate(u Updater, local, remote string) bool {
+	if u.compareVersions(local, remote) >= 0 {
+		return false
+	}
+	if !u.AllowPrereleases && u.CompareVersions == nil && semver.Prerelease(remote) != "" {
+		// keep following a release train the local version is already testing
+		if semver.Prerelease(local) == "" || release(local) != release(remote) {
+			u.logger().Debugf("updater: skipping prerelease %s", remote)
+			return false
+		}
+	}
+	return true
+}
+
+// validateVersion checks v with ValidateVersion, or as a semantic version by default
+func (u Updater) validateVersion(v string) error {
+	if u.ValidateVersion != nil {
+		return u.ValidateVersion(v)
+	}
+	if u.CompareVersions != nil {
+		if v == "" {
+			return errors.New("empty version")
+		}
+		return nil
+	}
+	if !semver.IsValid(v) {
+		return errors.New("not a semantic version")
+	}
+	return nil
+}
+
+// compareVersions compares a and b with CompareVersions, or semver.Compare by default
+func (u Updater) compareVersions(a, b string) int {
+	if u.CompareVersions != nil {
+		return u.CompareVersions(a, b)
+	}
+	return semver.Compare(a, b)
+}
+
+// normalize returns v in the form used for comparisons, custom version schemes are left untouched
+func (u Updater) normalize(v string) string {
+	if u.CompareVersions != nil || u.ValidateVersion != nil {
+		return v
+	}
+	return normalizeVersion(v)
+}
+
+// normalizeVersion prepends the "v" semver expects to bare versions such as 1.4.2
+func normalizeVersion(v string) string {
+	if v != "" && !strings.HasPrefix(v, "v") && semver.IsValid("v"+v) {
+		return "v" + v
+	}
+	return v
+}
+
+// release strips the prerelease and build metadata off v, e.g. v1.3.0-rc.1 becomes v1.3.0
+func release(v string) string {
+	c := semver.Canonical(v)
+	return strings.TrimSuffix(c, semver.Prerelease(c))
+}
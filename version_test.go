@@ -0,0 +1,146 @@
+package s3update
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestShouldUpdatePrereleases(t *testing.T) {
+	tests := []struct {
+		name, local, remote string
+		allow               bool
+		want                bool
+		// a newer prerelease is skipped with a message
+		skipped bool
+	}{
+		{"stable to stable", "v1.2.9", "v1.3.0", false, true, false},
+		{"rc to its stable", "v1.3.0-rc.1", "v1.3.0", false, true, false},
+		{"rc to a later stable", "v1.3.0-rc.1", "v1.4.0", false, true, false},
+		{"stable to rc", "v1.2.9", "v1.3.0-rc.1", false, false, true},
+		{"stable to rc allowed", "v1.2.9", "v1.3.0-rc.1", true, true, false},
+		{"rc to rc of the same release", "v1.3.0-rc.1", "v1.3.0-rc.2", false, true, false},
+		{"rc to rc of another release", "v1.3.0-rc.1", "v1.4.0-rc.1", false, false, true},
+		{"rc to rc of another release allowed", "v1.3.0-rc.1", "v1.4.0-rc.1", true, true, false},
+		{"stable to its rc", "v1.3.0", "v1.3.0-rc.1", true, false, false},
+		{"rc to an earlier rc", "v1.3.0-rc.2", "v1.3.0-rc.1", false, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &recordingLogger{}
+			u := Updater{AllowPrereleases: tt.allow, Logger: l}
+			if got := shouldUpdate(u, tt.local, tt.remote); got != tt.want {
+				t.Errorf("shouldUpdate(%s, %s) = %v, want %v", tt.local, tt.remote, got, tt.want)
+			}
+			skipped := strings.Contains(l.String(), "skipping prerelease "+tt.remote)
+			if skipped != tt.skipped {
+				t.Errorf("skipping logged %v, want %v: %q", skipped, tt.skipped, l)
+			}
+		})
+	}
+}
+
+func TestAutoUpdateSkipsPrerelease(t *testing.T) {
+	b := newTestBucket(t)
+	b.publish("v1.1.0-rc.1", []byte(exe("new binary")))
+	target := newTarget(t, exe("old binary"))
+	u := b.updater(target)
+
+	res, err := AutoUpdateResult(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Updated {
+		t.Error("updated to a prerelease")
+	}
+	assertContents(t, target, exe("old binary"))
+
+	u.AllowPrereleases = true
+	if err := AutoUpdate(u); err != nil {
+		t.Fatal(err)
+	}
+	assertContents(t, target, exe("new binary"))
+}
+
+func TestNormalizeVersion(t *testing.T) {
+	tests := []struct {
+		v, want string
+		valid   bool
+	}{
+		{"1.2.3", "v1.2.3", true},
+		{"v1.2.3", "v1.2.3", true},
+		{"1.2.3-rc.1", "v1.2.3-rc.1", true},
+		{"v1.2.3-rc.1", "v1.2.3-rc.1", true},
+		{"", "", false},
+		{"garbage", "garbage", false},
+		{"vgarbage", "vgarbage", false},
+		{"1.2.3.4", "1.2.3.4", false},
+	}
+	for _, tt := range tests {
+		got := normalizeVersion(tt.v)
+		if got != tt.want {
+			t.Errorf("normalizeVersion(%q) = %q, want %q", tt.v, got, tt.want)
+		}
+		if err := (Updater{}).validateVersion(got); (err == nil) != tt.valid {
+			t.Errorf("%q validated as %v", tt.v, err)
+		}
+	}
+}
+
+func TestAutoUpdateBareVersions(t *testing.T) {
+	tests := []struct {
+		name, current, remote, key, wantKey string
+	}{
+		{"bare", "1.0.0", "1.1.0", "mytool-{{VERSION}}", "mytool-1.1.0"},
+		{"bare remote", "v1.0.0", "1.1.0", "mytool-{{VERSION}}", "mytool-1.1.0"},
+		{"bare local", "1.0.0", "v1.1.0", "mytool-{{VERSION}}", "mytool-v1.1.0"},
+		{"semver placeholder", "1.0.0", "1.1.0", "mytool-{{SEMVER}}", "mytool-v1.1.0"},
+		{"bare prerelease", "1.0.0", "1.1.0-rc.1", "mytool-{{VERSION}}", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTestBucket(t)
+			binary := []byte(exe("new binary"))
+			b.put("VERSION", []byte(tt.remote+"\n"))
+			if tt.wantKey != "" {
+				b.put(tt.wantKey, binary)
+				b.put(tt.wantKey+".sha256", []byte(sha256Hex(string(binary))))
+			}
+			target := newTarget(t, exe("old binary"))
+			u := b.updater(target)
+			u.CurrentVersion, u.S3ReleaseKey, u.ChecksumKey = tt.current, tt.key, tt.key+".sha256"
+
+			res, err := AutoUpdateResult(u)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantKey == "" {
+				if res.Updated {
+					t.Error("updated to a prerelease")
+				}
+				return
+			}
+			if !res.Updated || res.ToVersion != normalizeVersion(tt.remote) {
+				t.Errorf("got %+v", res)
+			}
+			assertContents(t, target, string(binary))
+		})
+	}
+}
+
+func TestAutoUpdateInvalidVersions(t *testing.T) {
+	b := newTestBucket(t)
+	b.publish("v1.1.0", []byte(exe("new binary")))
+	target := newTarget(t, exe("old binary"))
+	u := b.updater(target)
+	u.CurrentVersion = "garbage"
+	if err := AutoUpdate(u); !errors.Is(err, ErrInvalidLocalVersion) {
+		t.Errorf("got %v, want %v", err, ErrInvalidLocalVersion)
+	}
+
+	b.put("VERSION", []byte("garbage"))
+	if err := AutoUpdate(b.updater(target)); !errors.Is(err, ErrInvalidRemoteVersion) {
+		t.Errorf("got %v, want %v", err, ErrInvalidRemoteVersion)
+	}
+	assertContents(t, target, exe("old binary"))
+}
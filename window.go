@@ -0,0 +1,63 @@
+package s3update
+
+import (
+	"os"
+	"time"
+)
+
+// UpdateWindow restricts when updates are installed, e.g. {Start: 22 * time.Hour, End: 6 * time.Hour} for nights.
+// The zero value allows updates at any time.
+type UpdateWindow struct {
+	// Start and End are times of day, as durations since midnight. The window crosses midnight when End is
+	// before Start, and lasts the whole day when they're equal.
+	Start, End time.Duration
+	// Days are the days the window starts on, every day when empty
+	Days []time.Weekday
+	// Location is the time zone of the window, time.Local when nil
+	Location *time.Location
+}
+
+// Contains reports whether t falls within the window
+func (w UpdateWindow) Contains(t time.Time) bool {
+	if w.Start == 0 && w.End == 0 && len(w.Days) == 0 {
+		return true
+	}
+	loc := w.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	t = t.In(loc)
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+	tod := t.Sub(midnight)
+	day := t.Weekday()
+	switch {
+	case w.Start == w.End:
+	case w.Start < w.End:
+		if tod < w.Start || tod >= w.End {
+			return false
+		}
+	case tod >= w.Start:
+	case tod < w.End:
+		// the window started the day before
+		day = (day + 6) % 7
+	default:
+		return false
+	}
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, d := range w.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// inUpdateWindow reports whether updates may be installed now, S3UPDATE_IGNORE_WINDOW lifting the restriction
+func (u Updater) inUpdateWindow() bool {
+	return os.Getenv("S3UPDATE_IGNORE_WINDOW") != "" || u.UpdateWindow.Contains(now())
+}
+
+// now returns the current time, a variable so that tests can set the clock
+var now = time.Now
@@ -0,0 +1,103 @@
+package s3update
+
+import (
+	"testing"
+	"time"
+)
+
+// monday returns the time of day d on Monday 2026-10-12 plus days, in UTC
+func monday(days int, d time.Duration) time.Time {
+	return time.Date(2026, 10, 12+days, 0, 0, 0, 0, time.UTC).Add(d)
+}
+
+func TestUpdateWindowContains(t *testing.T) {
+	nights := UpdateWindow{Start: 22 * time.Hour, End: 6 * time.Hour, Location: time.UTC}
+	mondayNights := nights
+	mondayNights.Days = []time.Weekday{time.Monday}
+	office := UpdateWindow{Start: 9 * time.Hour, End: 17 * time.Hour, Location: time.UTC}
+	tests := []struct {
+		name   string
+		window UpdateWindow
+		t      time.Time
+		want   bool
+	}{
+		{"zero value", UpdateWindow{}, monday(0, 3*time.Hour), true},
+		{"zero value another day", UpdateWindow{}, monday(4, 15*time.Hour), true},
+		{"whole day", UpdateWindow{Start: 5 * time.Hour, End: 5 * time.Hour, Location: time.UTC}, monday(0, time.Hour), true},
+		{"whole monday", UpdateWindow{Days: []time.Weekday{time.Monday}, Location: time.UTC}, monday(0, 23*time.Hour), true},
+		{"whole monday on tuesday", UpdateWindow{Days: []time.Weekday{time.Monday}, Location: time.UTC}, monday(1, time.Hour), false},
+		{"office start", office, monday(0, 9*time.Hour), true},
+		{"office end", office, monday(0, 17*time.Hour-time.Second), true},
+		{"office closed", office, monday(0, 17*time.Hour), false},
+		{"office not open", office, monday(0, 9*time.Hour-time.Second), false},
+		{"night start", nights, monday(0, 22*time.Hour), true},
+		{"night before midnight", nights, monday(0, 23*time.Hour), true},
+		{"night after midnight", nights, monday(1, 5*time.Hour), true},
+		{"night end", nights, monday(1, 6*time.Hour), false},
+		{"evening", nights, monday(0, 22*time.Hour-time.Second), false},
+		{"monday night", mondayNights, monday(0, 23*time.Hour), true},
+		{"monday night after midnight", mondayNights, monday(1, 3*time.Hour), true},
+		{"sunday night after midnight", mondayNights, monday(0, 3*time.Hour), false},
+		{"tuesday night", mondayNights, monday(1, 23*time.Hour), false},
+		{"time zone", UpdateWindow{Start: 22 * time.Hour, End: 6 * time.Hour, Location: time.FixedZone("UTC+2", 2*3600)},
+			monday(0, 21*time.Hour), true},
+		{"time zone outside", UpdateWindow{Start: 22 * time.Hour, End: 6 * time.Hour, Location: time.FixedZone("UTC+2", 2*3600)},
+			monday(1, 5*time.Hour), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.window.Contains(tt.t); got != tt.want {
+				t.Errorf("%v contains %v: got %v, want %v", tt.window, tt.t, got, tt.want)
+			}
+		})
+	}
+}
+
+// setClock makes the update window checked at t
+func setClock(t *testing.T, at time.Time) {
+	orig := now
+	now = func() time.Time { return at }
+	t.Cleanup(func() { now = orig })
+}
+
+func TestAutoUpdateWindow(t *testing.T) {
+	tests := []struct {
+		name      string
+		at        time.Time
+		ignore    bool
+		installed bool
+	}{
+		{"inside", monday(1, 2*time.Hour), false, true},
+		{"outside", monday(1, 12*time.Hour), false, false},
+		{"outside ignored", monday(1, 12*time.Hour), true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setClock(t, tt.at)
+			if tt.ignore {
+				t.Setenv("S3UPDATE_IGNORE_WINDOW", "1")
+			}
+			b := newTestBucket(t)
+			b.publish("v1.1.0", []byte(exe("new binary")))
+			target := newTarget(t, exe("old binary"))
+			u := b.updater(target)
+			u.UpdateWindow = UpdateWindow{Start: 22 * time.Hour, End: 6 * time.Hour, Location: time.UTC}
+
+			res, err := AutoUpdateResult(u)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Updated != tt.installed || res.Pending == tt.installed {
+				t.Errorf("got %+v", res)
+			}
+			if tt.installed {
+				assertContents(t, target, exe("new binary"))
+			} else {
+				assertContents(t, target, exe("old binary"))
+				if n := downloads(b); n != 0 {
+					t.Errorf("release downloaded %d times", n)
+				}
+			}
+		})
+	}
+}
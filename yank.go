@@ -0,0 +1,74 @@
+package s3update
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// yankReason tells why version must not be installed, empty when it may. Versions are skipped when listed
+// by SkipVersions, by the manifest or by the object at YankedKey, one version per line.
+func yankReason(ctx context.Context, u Updater, m *Manifest, version string) (string, error) {
+	if u.listed(u.SkipVersions, version) {
+		return "listed in SkipVersions", nil
+	}
+	if m != nil && u.listed(m.Yanked, version) {
+		return "yanked by the manifest", nil
+	}
+	if u.YankedKey == "" {
+		return "", nil
+	}
+	yanked, err := fetchYanked(ctx, u)
+	if err != nil {
+		return "", err
+	}
+	if u.listed(yanked, version) {
+		return "yanked by " + u.YankedKey, nil
+	}
+	return "", nil
+}
+
+// listed reports whether versions contains version, both being compared in their normalized form
+func (u Updater) listed(versions []string, version string) bool {
+	for _, v := range versions {
+		if v = u.normalize(strings.TrimSpace(v)); v != "" && u.compareVersions(v, version) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchYanked returns the versions listed by the object at YankedKey, which may not exist
+func fetchYanked(ctx context.Context, u Updater) ([]string, error) {
+	yankedURL := generateURL(u, u.YankedKey, "")
+	resp, err := u.httpGet(ctx, yankedURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusForbidden {
+		// S3 answers 403 for missing keys when the bucket can't be listed
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, u.statusError(yankedURL, resp)
+	}
+	body, err := readBody(yankedURL, resp, maxYankedSize)
+	if err != nil {
+		return nil, err
+	}
+	var versions []string
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+			versions = append(versions, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", yankedURL, err)
+	}
+	return versions, nil
+}
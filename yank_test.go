@@ -0,0 +1,82 @@
+package s3update
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAutoUpdateYanked(t *testing.T) {
+	tests := []struct {
+		name      string
+		yanked    string
+		manifest  []string
+		skip      []string
+		reason    string
+		installed bool
+	}{
+		{name: "not yanked", yanked: "v1.0.1\n", installed: true},
+		{name: "no yanked list", installed: true},
+		{name: "yanked", yanked: "# data corruption\nv1.0.1\n1.1.0\n", reason: "yanked by YANKED"},
+		{name: "yanked by the manifest", manifest: []string{"v1.1.0"}, reason: "yanked by the manifest"},
+		{name: "skipped locally", skip: []string{"v1.1.0"}, reason: "listed in SkipVersions"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTestBucket(t)
+			b.publishManifest("v1.1.0", []byte(exe("new binary")), Manifest{Yanked: tt.manifest})
+			if tt.yanked != "" {
+				b.put("YANKED", []byte(tt.yanked))
+			}
+			target := newTarget(t, exe("old binary"))
+			logger := &recordingLogger{}
+			u := b.manifestUpdater(target)
+			u.YankedKey, u.SkipVersions = "YANKED", tt.skip
+			u.Logger, u.Verbose = logger, true
+
+			info, err := CheckForUpdate(u)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.UpdateAvailable != tt.installed || info.Yanked == tt.installed {
+				t.Errorf("got %+v", info)
+			}
+			res, err := AutoUpdateResult(u)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Updated != tt.installed {
+				t.Errorf("updated %v, want %v", res.Updated, tt.installed)
+			}
+			if tt.installed {
+				assertContents(t, target, exe("new binary"))
+				return
+			}
+			// the update is skipped, the running version being kept
+			assertContents(t, target, exe("old binary"))
+			if n := downloads(b); n != 0 {
+				t.Errorf("release downloaded %d times", n)
+			}
+			if want := "not installing v1.1.0: " + tt.reason; !strings.Contains(logger.String(), want) {
+				t.Errorf("%q not logged:\n%s", want, logger)
+			}
+		})
+	}
+}
+
+func TestAutoUpdateYankedListDenied(t *testing.T) {
+	b := newTestBucket(t)
+	b.publish("v1.1.0", []byte(exe("new binary")))
+	// S3 answers 403 for missing keys when the bucket can't be listed
+	b.handle("YANKED", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	})
+	target := newTarget(t, exe("old binary"))
+	u := b.updater(target)
+	u.YankedKey = "YANKED"
+
+	if err := AutoUpdate(u); err != nil {
+		t.Fatal(err)
+	}
+	assertContents(t, target, exe("new binary"))
+}